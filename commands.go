@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"docker-operator/src/audit"
+)
+
+const usage = `usage:
+  docker-operator                      run the server
+  docker-operator audit verify <file>  verify the hash chain of an audit log`
+
+// runCommand runs a command given on the command line instead of the server and returns its exit code.
+func runCommand(args []string) int {
+	switch {
+	case len(args) == 3 && args[0] == "audit" && args[1] == "verify":
+		count, err := audit.Verify(args[2])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "audit log %s is broken after %d valid entries: %v\n", args[2], count, err)
+			return 1
+		}
+		fmt.Printf("audit log %s is intact: %d entries\n", args[2], count)
+		return 0
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+}
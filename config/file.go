@@ -0,0 +1,21 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+)
+
+// LoadFile reads a yaml, json or toml file and unmarshals it into out.
+// The format is derived from the file extension.
+func LoadFile(path string, out interface{}) error {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return fmt.Errorf("could not read config file %s: %w", path, err)
+	}
+	if err := v.Unmarshal(out); err != nil {
+		return fmt.Errorf("could not parse config file %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package log
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+type requestIDKey struct{}
+
+// WithRequestID attaches the id of the request being served to the context.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the id of the request being served, empty outside of a request.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// FromContext returns the global logger, annotated with the request id of the context.
+func FromContext(ctx context.Context) *zap.SugaredLogger {
+	if id := RequestID(ctx); id != "" {
+		return zap.S().With("request_id", id)
+	}
+	return zap.S()
+}
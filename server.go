@@ -1,9 +1,32 @@
 package main
 
 import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
 	"docker-operator/config"
 	"docker-operator/log"
+	"docker-operator/src/admission"
+	"docker-operator/src/artifacts"
+	"docker-operator/src/audit"
+	"docker-operator/src/auth"
+	"docker-operator/src/coalesce"
 	"docker-operator/src/docker"
+	"docker-operator/src/history"
+	"docker-operator/src/idempotency"
+	"docker-operator/src/imagecache"
+	"docker-operator/src/limits"
+	"docker-operator/src/metrics"
+	"docker-operator/src/policy"
+	"docker-operator/src/prewarm"
+	"docker-operator/src/rbac"
+	"docker-operator/src/redact"
+	"docker-operator/src/registry"
+	"docker-operator/src/responsecache"
+	"docker-operator/src/stale"
+	"docker-operator/src/tracing"
 	routes "docker-operator/src/v1"
 
 	"github.com/gofiber/fiber/v2"
@@ -11,6 +34,10 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	loggerMgr := log.InitZapLog()
 	zap.ReplaceGlobals(loggerMgr)
 	defer loggerMgr.Sync()
@@ -20,14 +47,226 @@ func main() {
 	if err != nil {
 		logger.Fatalf(err.Error())
 	}
+	exporter, err := tracing.LoadExporter()
+	if err != nil {
+		logger.Fatalf(err.Error())
+	}
+	if exporter != nil {
+		tracing.SetExporter(exporter)
+	}
+
+	operatorMetrics := metrics.New()
+	dockerClient = metrics.NewClient(dockerClient, operatorMetrics)
+
+	registries, err := registry.Load()
+	if err != nil {
+		logger.Fatalf(err.Error())
+	}
+
+	var reviewers []docker.ImageReviewer
+	if path := config.DefaultConfig.GetString("ADMISSION_FILE"); path != "" {
+		admissionPolicy, err := admission.Load(path)
+		if err != nil {
+			logger.Fatalf(err.Error())
+		}
+		reviewers = append(reviewers, admissionPolicy)
+	}
+
+	var policyStore *policy.Store
+	if path := config.DefaultConfig.GetString("IMAGE_POLICY_FILE"); path != "" {
+		policyStore, err = policy.NewStore(path)
+		if err != nil {
+			logger.Fatalf(err.Error())
+		}
+		reloadOnHangup(policyStore)
+		// the digest rules are checked once the image is pulled
+		reviewers = append(reviewers, policyStore)
+	}
+
+	dockerService := docker.NewService(dockerClient, registries, reviewers...)
+	if path := config.DefaultConfig.GetString("STALE_FILE"); path != "" {
+		staleConfig, err := stale.Load(path)
+		if err != nil {
+			logger.Fatalf(err.Error())
+		}
+		staleOutputs, err := stale.New(staleConfig)
+		if err != nil {
+			logger.Fatalf(err.Error())
+		}
+		dockerService = stale.NewService(dockerService, staleOutputs)
+	}
+	var responseCache *responsecache.Cache
+	if path := config.DefaultConfig.GetString("RESPONSE_CACHE_FILE"); path != "" {
+		cacheConfig, err := responsecache.Load(path)
+		if err != nil {
+			logger.Fatalf(err.Error())
+		}
+		responseCache, err = responsecache.New(cacheConfig)
+		if err != nil {
+			logger.Fatalf(err.Error())
+		}
+		dockerService = responsecache.NewService(dockerService, responseCache)
+	}
+	if path := config.DefaultConfig.GetString("COALESCE_FILE"); path != "" {
+		coalesceConfig, err := coalesce.Load(path)
+		if err != nil {
+			logger.Fatalf(err.Error())
+		}
+		group, err := coalesce.New(coalesceConfig)
+		if err != nil {
+			logger.Fatalf(err.Error())
+		}
+		dockerService = coalesce.NewService(dockerService, group)
+	}
+	if policyStore != nil {
+		dockerService = policy.NewService(dockerService, policyStore)
+	}
+
+	var cache *imagecache.Cache
+	if cacheConfig := imagecache.LoadConfig(); cacheConfig.Enabled() {
+		cache, err = imagecache.New(dockerClient, cacheConfig)
+		if err != nil {
+			logger.Fatalf(err.Error())
+		}
+		dockerService = imagecache.NewService(dockerService, cache)
+	}
+	dockerService = metrics.NewService(dockerService, operatorMetrics)
+
+	var prewarmer *prewarm.Prewarmer
+	if path := config.DefaultConfig.GetString("PREWARM_FILE"); path != "" {
+		prewarmer, err = prewarm.Load(path, dockerService, registries)
+		if err != nil {
+			logger.Fatalf(err.Error())
+		}
+		if cache != nil {
+			cache.Pin(prewarmer.References()...)
+		}
+		prewarmer.Start(context.Background())
+	}
+	if cache != nil {
+		cache.Start(context.Background())
+	}
+
+	var authenticators []auth.Authenticator
+	if path := config.DefaultConfig.GetString("AUTH_FILE"); path != "" {
+		authenticators, err = auth.Load(path)
+		if err != nil {
+			logger.Fatalf(err.Error())
+		}
+	}
+
+	var authorization *rbac.Policy
+	if path := config.DefaultConfig.GetString("AUTHZ_FILE"); path != "" {
+		authorization, err = rbac.Load(path)
+		if err != nil {
+			logger.Fatalf(err.Error())
+		}
+	}
+
+	var limiter *limits.Limiter
+	if path := config.DefaultConfig.GetString("RATE_LIMIT_FILE"); path != "" {
+		limiter, err = limits.Load(path)
+		if err != nil {
+			logger.Fatalf(err.Error())
+		}
+	}
+
+	var redactor *redact.Redactor
+	if path := config.DefaultConfig.GetString("REDACTION_FILE"); path != "" {
+		redactor, err = redact.Load(path)
+		if err != nil {
+			logger.Fatalf(err.Error())
+		}
+	}
+
+	var auditLog *audit.Log
+	if path := config.DefaultConfig.GetString("AUDIT_LOG_FILE"); path != "" {
+		auditLog, err = audit.Open(path)
+		if err != nil {
+			logger.Fatalf(err.Error())
+		}
+		defer auditLog.Close()
+	}
+
+	var executionHistory *history.Store
+	if historyConfig := history.LoadConfig(); historyConfig.Enabled() {
+		executionHistory, err = history.Open(historyConfig)
+		if err != nil {
+			logger.Fatalf(err.Error())
+		}
+		defer executionHistory.Close()
+	}
+
+	var archive *artifacts.Store
+	if archiveConfig := artifacts.LoadConfig(); archiveConfig.Enabled() {
+		archive, err = artifacts.New(archiveConfig)
+		if err != nil {
+			logger.Fatalf(err.Error())
+		}
+		archive.Start(context.Background())
+	}
+
+	var idempotencyStore *idempotency.Store
+	if idempotencyConfig := idempotency.LoadConfig(); idempotencyConfig.Enabled() {
+		idempotencyStore = idempotency.New(idempotencyConfig)
+	}
 
 	app := fiber.New(fiber.Config{
 		ErrorHandler: routes.StandardErrorHandler,
 	})
-	routes.AddRoutes(app, docker.NewService(dockerClient))
+	routes.AddRoutes(app, routes.Dependencies{
+		Service:        dockerService,
+		Client:         dockerClient,
+		Registries:     registries,
+		Prewarmer:      prewarmer,
+		Cache:          cache,
+		ResponseCache:  responseCache,
+		Authenticators: authenticators,
+		Authorization:  authorization,
+		Limiter:        limiter,
+		Idempotency:    idempotencyStore,
+		AuditLog:       auditLog,
+		Redactor:       redactor,
+		History:        executionHistory,
+		Artifacts:      archive,
+		Metrics:        operatorMetrics,
+		AdminToken:     config.DefaultConfig.GetString("ADMIN_TOKEN"),
+	})
 
+	shutdownOnTerm(app)
 	err = app.Listen(config.DefaultConfig.GetString("API_PORT"))
+	// deferred calls are skipped by Fatalf, the buffered spans are exported first
+	if shutdownErr := tracing.Shutdown(context.Background()); shutdownErr != nil {
+		logger.Errorw("could not export spans", "error", shutdownErr)
+	}
 	if err != nil {
 		logger.Fatalf(err.Error())
 	}
 }
+
+// shutdownOnTerm stops the server once the process receives SIGTERM or SIGINT,
+// letting the requests in progress finish.
+func shutdownOnTerm(app *fiber.App) {
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		<-term
+		zap.S().Info("shutting down")
+		if err := app.Shutdown(); err != nil {
+			zap.S().Errorw("could not shut down", "error", err)
+		}
+	}()
+}
+
+// reloadOnHangup reloads the image policy whenever the process receives SIGHUP.
+func reloadOnHangup(store *policy.Store) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			if err := store.Reload(); err != nil {
+				zap.S().Errorw("could not reload image policy", "error", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,148 @@
+package admission
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"docker-operator/config"
+
+	"github.com/docker/docker/api/types"
+	"go.uber.org/zap"
+)
+
+// Policy holds the checks run against the config of an image before it is run.
+type Policy struct {
+	// RejectRootUser rejects images that do not set a user or run as root.
+	RejectRootUser bool `mapstructure:"reject_root_user"`
+	// RequiredLabels are labels every image has to carry, e.g. "org.opencontainers.image.source".
+	RequiredLabels []string `mapstructure:"required_labels"`
+	// MaxAgeDays rejects images built more than this many days ago. Zero disables the check.
+	MaxAgeDays int `mapstructure:"max_age_days"`
+	// RejectExposedPorts rejects images that declare exposed ports.
+	RejectExposedPorts bool `mapstructure:"reject_exposed_ports"`
+	// RejectVolumes rejects images that declare volumes.
+	RejectVolumes bool `mapstructure:"reject_volumes"`
+
+	now func() time.Time
+}
+
+// Violation explains why an image was rejected.
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// RejectedError is returned when an image violates the admission policy.
+type RejectedError struct {
+	Image      string
+	Violations []Violation
+}
+
+func (e *RejectedError) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, violation := range e.Violations {
+		messages = append(messages, violation.Message)
+	}
+	return fmt.Sprintf("image %s rejected by admission policy: %s", e.Image, strings.Join(messages, "; "))
+}
+
+// Load reads the admission policy from the given file.
+func Load(path string) (*Policy, error) {
+	policy := &Policy{}
+	if err := config.LoadFile(path, policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// Review checks the inspected image and returns a RejectedError listing every violated rule.
+func (p *Policy) Review(image string, inspect types.ImageInspect) error {
+	violations := p.violations(inspect)
+	if len(violations) == 0 {
+		return nil
+	}
+	zap.S().Warnw("image rejected by admission policy", "image", image, "violations", violations)
+	return &RejectedError{Image: image, Violations: violations}
+}
+
+func (p *Policy) violations(inspect types.ImageInspect) []Violation {
+	var violations []Violation
+	var (
+		user         string
+		labels       map[string]string
+		exposedPorts []string
+		volumes      []string
+	)
+	if inspect.Config != nil {
+		user = inspect.Config.User
+		labels = inspect.Config.Labels
+		for port := range inspect.Config.ExposedPorts {
+			exposedPorts = append(exposedPorts, string(port))
+		}
+		for volume := range inspect.Config.Volumes {
+			volumes = append(volumes, volume)
+		}
+		sort.Strings(exposedPorts)
+		sort.Strings(volumes)
+	}
+
+	if p.RejectRootUser && isRoot(user) {
+		violations = append(violations, Violation{
+			Rule:    "reject_root_user",
+			Message: fmt.Sprintf("image runs as root (user %q)", user),
+		})
+	}
+	for _, label := range p.RequiredLabels {
+		if labels[label] == "" {
+			violations = append(violations, Violation{
+				Rule:    "required_labels",
+				Message: fmt.Sprintf("image is missing label %s", label),
+			})
+		}
+	}
+	if p.MaxAgeDays > 0 {
+		created, err := time.Parse(time.RFC3339Nano, inspect.Created)
+		maxAge := time.Duration(p.MaxAgeDays) * 24 * time.Hour
+		switch {
+		case err != nil:
+			violations = append(violations, Violation{
+				Rule:    "max_age_days",
+				Message: fmt.Sprintf("image has no valid creation date %q", inspect.Created),
+			})
+		case p.currentTime().Sub(created) > maxAge:
+			violations = append(violations, Violation{
+				Rule:    "max_age_days",
+				Message: fmt.Sprintf("image was created on %s, more than %d days ago", created.Format("2006-01-02"), p.MaxAgeDays),
+			})
+		}
+	}
+	if p.RejectExposedPorts && len(exposedPorts) > 0 {
+		violations = append(violations, Violation{
+			Rule:    "reject_exposed_ports",
+			Message: fmt.Sprintf("image exposes ports %s", strings.Join(exposedPorts, ", ")),
+		})
+	}
+	if p.RejectVolumes && len(volumes) > 0 {
+		violations = append(violations, Violation{
+			Rule:    "reject_volumes",
+			Message: fmt.Sprintf("image declares volumes %s", strings.Join(volumes, ", ")),
+		})
+	}
+	return violations
+}
+
+func (p *Policy) currentTime() time.Time {
+	if p.now != nil {
+		return p.now()
+	}
+	return time.Now()
+}
+
+// isRoot reports whether a user or user:group string of an image config runs as root.
+// Images without a user run as root.
+func isRoot(user string) bool {
+	name := strings.SplitN(user, ":", 2)[0]
+	return name == "" || name == "root" || name == "0"
+}
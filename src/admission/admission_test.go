@@ -0,0 +1,86 @@
+package admission
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/go-connections/nat"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicy_Review(t *testing.T) {
+	policy := &Policy{
+		RejectRootUser:     true,
+		RequiredLabels:     []string{"org.opencontainers.image.authors", "org.opencontainers.image.source"},
+		MaxAgeDays:         30,
+		RejectExposedPorts: true,
+		RejectVolumes:      true,
+		now: func() time.Time {
+			return time.Date(2021, 6, 30, 0, 0, 0, 0, time.UTC)
+		},
+	}
+	tests := []struct {
+		description    string
+		inspect        types.ImageInspect
+		wantViolations []Violation
+	}{
+		{
+			description: "admit a compliant image",
+			inspect: types.ImageInspect{
+				Created: "2021-06-20T10:00:00.123456789Z",
+				Config: &container.Config{
+					User: "app:app",
+					Labels: map[string]string{
+						"org.opencontainers.image.authors": "data-team",
+						"org.opencontainers.image.source":  "https://git.internal/data/report",
+					},
+				},
+			},
+		},
+		{
+			description: "reject an image violating every rule",
+			inspect: types.ImageInspect{
+				Created: "2021-01-01T00:00:00Z",
+				Config: &container.Config{
+					User:         "0:0",
+					ExposedPorts: nat.PortSet{"8080/tcp": struct{}{}, "443/tcp": struct{}{}},
+					Volumes:      map[string]struct{}{"/data": {}},
+					Labels: map[string]string{
+						"org.opencontainers.image.authors": "data-team",
+					},
+				},
+			},
+			wantViolations: []Violation{
+				{Rule: "reject_root_user", Message: `image runs as root (user "0:0")`},
+				{Rule: "required_labels", Message: "image is missing label org.opencontainers.image.source"},
+				{Rule: "max_age_days", Message: "image was created on 2021-01-01, more than 30 days ago"},
+				{Rule: "reject_exposed_ports", Message: "image exposes ports 443/tcp, 8080/tcp"},
+				{Rule: "reject_volumes", Message: "image declares volumes /data"},
+			},
+		},
+		{
+			description: "treat an image without config as root without labels",
+			inspect:     types.ImageInspect{Created: "2021-06-29T00:00:00Z"},
+			wantViolations: []Violation{
+				{Rule: "reject_root_user", Message: `image runs as root (user "")`},
+				{Rule: "required_labels", Message: "image is missing label org.opencontainers.image.authors"},
+				{Rule: "required_labels", Message: "image is missing label org.opencontainers.image.source"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			err := policy.Review("registry.internal/report:1.0", test.inspect)
+			if test.wantViolations == nil {
+				assert.NoError(t, err)
+				return
+			}
+			var rejected *RejectedError
+			assert.True(t, errors.As(err, &rejected))
+			assert.Equal(t, test.wantViolations, rejected.Violations)
+		})
+	}
+}
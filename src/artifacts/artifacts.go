@@ -0,0 +1,274 @@
+package artifacts
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"docker-operator/config"
+
+	"go.uber.org/zap"
+)
+
+// NotFoundError is returned for executions without archived logs.
+var NotFoundError = fmt.Errorf("logs not found")
+
+// ExistsError is returned when the logs of an execution are already archived.
+var ExistsError = fmt.Errorf("logs already archived")
+
+const (
+	stdoutFile = "stdout"
+	stderrFile = "stderr"
+	metaFile   = "meta.json"
+)
+
+// Config holds where the logs are archived and for how long.
+type Config struct {
+	Dir string
+	// MaxSize caps each stream of an execution, the rest is dropped. A zero cap is not enforced.
+	MaxSize int64
+	// MaxTotalSize caps the archive, the oldest logs are removed first. A zero cap is not enforced.
+	MaxTotalSize int64
+	// Retention is how long the logs are kept. A zero retention keeps them until the total size is reached.
+	Retention time.Duration
+	// Interval between two removals of expired logs.
+	Interval time.Duration
+}
+
+// LoadConfig reads the artifact store settings from the environment.
+func LoadConfig() Config {
+	interval := config.DefaultConfig.GetDuration("ARTIFACTS_INTERVAL")
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	return Config{
+		Dir:          config.DefaultConfig.GetString("ARTIFACTS_DIR"),
+		MaxSize:      int64(config.DefaultConfig.GetSizeInBytes("ARTIFACTS_MAX_SIZE")),
+		MaxTotalSize: int64(config.DefaultConfig.GetSizeInBytes("ARTIFACTS_MAX_TOTAL_SIZE")),
+		Retention:    config.DefaultConfig.GetDuration("ARTIFACTS_RETENTION"),
+		Interval:     interval,
+	}
+}
+
+// Enabled reports whether a directory is set.
+func (c Config) Enabled() bool {
+	return c.Dir != ""
+}
+
+// Logs are the archived stdout and stderr of an execution.
+type Logs struct {
+	Execution  string    `json:"execution"`
+	Time       time.Time `json:"time"`
+	StdoutSize int64     `json:"stdout_size"`
+	StderrSize int64     `json:"stderr_size"`
+	Truncated  bool      `json:"truncated"`
+	Stdout     string    `json:"stdout"`
+	Stderr     string    `json:"stderr"`
+}
+
+// Store keeps the stdout and stderr of every execution in a directory of its own.
+type Store struct {
+	config Config
+
+	mu sync.Mutex
+}
+
+// New builds a store, creating its directory.
+func New(cfg Config) (*Store, error) {
+	if err := os.MkdirAll(cfg.Dir, 0700); err != nil {
+		return nil, fmt.Errorf("could not create artifact directory %s: %w", cfg.Dir, err)
+	}
+	return &Store{config: cfg}, nil
+}
+
+// Save archives the output of an execution, capping each stream. The logs of
+// an execution are archived once, an existing archive is never overwritten.
+func (s *Store) Save(id string, stdout, stderr []byte) error {
+	if !validID(id) {
+		return fmt.Errorf("invalid execution id %q", id)
+	}
+	logs := Logs{
+		Execution:  id,
+		Time:       time.Now().UTC(),
+		StdoutSize: int64(len(stdout)),
+		StderrSize: int64(len(stderr)),
+	}
+	stdout, logs.Truncated = s.capped(stdout, logs.Truncated)
+	stderr, logs.Truncated = s.capped(stderr, logs.Truncated)
+	meta, err := json.Marshal(logs)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dir := filepath.Join(s.config.Dir, id)
+	if err := os.Mkdir(dir, 0700); os.IsExist(err) {
+		return fmt.Errorf("could not archive logs of %s: %w", id, ExistsError)
+	} else if err != nil {
+		return fmt.Errorf("could not archive logs of %s: %w", id, err)
+	}
+	// the metadata is written last, the logs are found once they are complete
+	for _, file := range []struct {
+		name string
+		data []byte
+	}{{stdoutFile, stdout}, {stderrFile, stderr}, {metaFile, meta}} {
+		if err := writeNew(filepath.Join(dir, file.name), file.data); err != nil {
+			return fmt.Errorf("could not archive logs of %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// writeNew writes a file that must not exist yet.
+func writeNew(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *Store) capped(data []byte, truncated bool) ([]byte, bool) {
+	if s.config.MaxSize > 0 && int64(len(data)) > s.config.MaxSize {
+		return data[:s.config.MaxSize], true
+	}
+	return data, truncated
+}
+
+// Exists reports whether logs are archived under the execution id.
+func (s *Store) Exists(id string) bool {
+	if !validID(id) {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(s.config.Dir, id))
+	return err == nil
+}
+
+// Get returns the archived logs of an execution.
+func (s *Store) Get(id string) (Logs, error) {
+	if !validID(id) {
+		return Logs{}, NotFoundError
+	}
+	dir := filepath.Join(s.config.Dir, id)
+	meta, err := ioutil.ReadFile(filepath.Join(dir, metaFile))
+	if os.IsNotExist(err) {
+		return Logs{}, NotFoundError
+	}
+	if err != nil {
+		return Logs{}, err
+	}
+	var logs Logs
+	if err := json.Unmarshal(meta, &logs); err != nil {
+		return Logs{}, err
+	}
+	stdout, err := ioutil.ReadFile(filepath.Join(dir, stdoutFile))
+	if err != nil {
+		return Logs{}, err
+	}
+	stderr, err := ioutil.ReadFile(filepath.Join(dir, stderrFile))
+	if err != nil {
+		return Logs{}, err
+	}
+	logs.Stdout = string(stdout)
+	logs.Stderr = string(stderr)
+	return logs, nil
+}
+
+// Start removes the expired logs on the configured interval until the context is done.
+func (s *Store) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(s.config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			s.Prune(time.Now())
+		}
+	}()
+}
+
+// Prune removes the logs older than the retention, then the oldest logs until
+// the archive is within its total size, and returns the executions removed.
+func (s *Store) Prune(now time.Time) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	archived, err := s.archived()
+	if err != nil {
+		zap.S().Errorw("could not list archived logs", "error", err)
+		return nil
+	}
+	var total int64
+	for _, logs := range archived {
+		total += logs.size
+	}
+	var removed []string
+	for _, logs := range archived {
+		expired := s.config.Retention > 0 && now.Sub(logs.time) > s.config.Retention
+		overBudget := s.config.MaxTotalSize > 0 && total > s.config.MaxTotalSize
+		if !expired && !overBudget {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(s.config.Dir, logs.id)); err != nil {
+			zap.S().Errorw("could not remove archived logs", "execution", logs.id, "error", err)
+			continue
+		}
+		total -= logs.size
+		removed = append(removed, logs.id)
+	}
+	return removed
+}
+
+type archivedLogs struct {
+	id   string
+	time time.Time
+	size int64
+}
+
+// archived lists the archived logs, oldest first.
+func (s *Store) archived() ([]archivedLogs, error) {
+	dirs, err := ioutil.ReadDir(s.config.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var archived []archivedLogs
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		files, err := ioutil.ReadDir(filepath.Join(s.config.Dir, dir.Name()))
+		if err != nil {
+			return nil, err
+		}
+		logs := archivedLogs{id: dir.Name(), time: dir.ModTime()}
+		for _, file := range files {
+			logs.size += file.Size()
+			if file.ModTime().After(logs.time) {
+				logs.time = file.ModTime()
+			}
+		}
+		archived = append(archived, logs)
+	}
+	sort.Slice(archived, func(i, j int) bool {
+		return archived[i].time.Before(archived[j].time)
+	})
+	return archived, nil
+}
+
+// validID keeps execution ids from escaping the directory of the store.
+func validID(id string) bool {
+	return id != "" && id != "." && id != ".." && filepath.Base(id) == id
+}
@@ -0,0 +1,62 @@
+package artifacts
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore_Save(t *testing.T) {
+	store, err := New(Config{Dir: t.TempDir(), MaxSize: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, store.Save("req-1", []byte("ok"), []byte("failure")))
+	logs, err := store.Get("req-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", logs.Stdout)
+	assert.Equal(t, "fail", logs.Stderr)
+	assert.Equal(t, int64(2), logs.StdoutSize)
+	assert.Equal(t, int64(7), logs.StderrSize)
+	assert.True(t, logs.Truncated)
+
+	// the logs of an execution are never overwritten
+	assert.ErrorIs(t, store.Save("req-1", []byte("forged"), nil), ExistsError)
+	logs, err = store.Get("req-1")
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", logs.Stdout)
+
+	_, err = store.Get("req-2")
+	assert.Equal(t, NotFoundError, err)
+	_, err = store.Get("..")
+	assert.Equal(t, NotFoundError, err)
+	assert.Error(t, store.Save("..", nil, nil))
+}
+
+func TestStore_Prune(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(Config{Dir: dir, Retention: 24 * time.Hour, MaxTotalSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	age := func(id string, d time.Duration) {
+		for _, name := range []string{"", stdoutFile, stderrFile, metaFile} {
+			path := filepath.Join(dir, id, name)
+			assert.NoError(t, os.Chtimes(path, now.Add(-d), now.Add(-d)))
+		}
+	}
+	assert.NoError(t, store.Save("expired", []byte("ok"), nil))
+	age("expired", 48*time.Hour)
+	assert.NoError(t, store.Save("oldest", make([]byte, 600), nil))
+	age("oldest", 2*time.Hour)
+	assert.NoError(t, store.Save("newest", make([]byte, 600), nil))
+	age("newest", time.Hour)
+
+	assert.Equal(t, []string{"expired", "oldest"}, store.Prune(now))
+	_, err = store.Get("newest")
+	assert.NoError(t, err)
+}
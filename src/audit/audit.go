@@ -0,0 +1,223 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Outcomes of an execution attempt.
+const (
+	OutcomeSuccess         = "success"
+	OutcomeUnauthenticated = "unauthenticated"
+	OutcomeDenied          = "denied"
+	OutcomeRateLimited     = "rate_limited"
+	OutcomeInvalid         = "invalid"
+	OutcomeNotFound        = "not_found"
+	OutcomeCancelled       = "cancelled"
+	OutcomeInProgress      = "in_progress"
+	OutcomeError           = "error"
+)
+
+// Entry is an execution attempt. Each entry holds the hash of the previous one,
+// so removing or changing an entry breaks the chain.
+type Entry struct {
+	Seq        uint64    `json:"seq"`
+	Time       time.Time `json:"time"`
+	Execution  string    `json:"execution,omitempty"`
+	Caller     string    `json:"caller"`
+	Client     string    `json:"client"`
+	Method     string    `json:"method"`
+	Image      string    `json:"image"`
+	Digest     string    `json:"digest,omitempty"`
+	ParamsHash string    `json:"params_hash"`
+	Outcome    string    `json:"outcome"`
+	Status     int       `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+	PrevHash   string    `json:"prev_hash"`
+	Hash       string    `json:"hash"`
+}
+
+// Log appends entries to a file, one JSON document per line.
+type Log struct {
+	mu       sync.Mutex
+	file     *os.File
+	seq      uint64
+	lastHash string
+}
+
+// Open opens the audit log for appending and continues the chain of its last entry.
+// A torn entry at the end of the file, left by a write that never completed, is cut off.
+func Open(path string) (*Log, error) {
+	last, size, torn, err := readLast(path)
+	if err != nil {
+		return nil, err
+	}
+	if torn != nil {
+		zap.S().Warnw("truncating torn entry at the end of the audit log", "file", path, "entry", string(torn))
+		if err := os.Truncate(path, size); err != nil {
+			return nil, fmt.Errorf("could not truncate torn entry of audit log %s: %w", path, err)
+		}
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open audit log %s: %w", path, err)
+	}
+	l := &Log{file: file}
+	if last != nil {
+		l.seq = last.Seq
+		l.lastHash = last.Hash
+	}
+	return l, nil
+}
+
+// Append chains the entry to the previous one and writes it to the file.
+func (l *Log) Append(entry Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entry.Seq = l.seq + 1
+	entry.Time = entry.Time.UTC()
+	entry.PrevHash = l.lastHash
+	hash, err := entry.hash()
+	if err != nil {
+		return err
+	}
+	entry.Hash = hash
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("could not write audit log: %w", err)
+	}
+	if err := l.file.Sync(); err != nil {
+		return fmt.Errorf("could not write audit log: %w", err)
+	}
+	l.seq = entry.Seq
+	l.lastHash = entry.Hash
+	return nil
+}
+
+// Close closes the file of the audit log.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// Verify checks the chain of the audit log and returns the number of entries.
+// It fails on the first entry that was changed, removed or inserted, and on a
+// torn entry at the end of the file.
+func Verify(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	var prev Entry
+	count := 0
+	torn, err := readLines(file, func(line []byte) error {
+		n := count + 1
+		var entry Entry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return fmt.Errorf("line %d: invalid entry: %w", n, err)
+		}
+		if entry.Seq != prev.Seq+1 {
+			return fmt.Errorf("line %d: expected entry %d, found %d", n, prev.Seq+1, entry.Seq)
+		}
+		if entry.PrevHash != prev.Hash {
+			return fmt.Errorf("line %d: entry %d does not follow entry %d", n, entry.Seq, prev.Seq)
+		}
+		hash, err := entry.hash()
+		if err != nil {
+			return err
+		}
+		if hash != entry.Hash {
+			return fmt.Errorf("line %d: entry %d was modified", n, entry.Seq)
+		}
+		prev = entry
+		count = n
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+	if torn != nil {
+		return count, fmt.Errorf("line %d: torn entry, its write never completed", count+1)
+	}
+	return count, nil
+}
+
+// hash returns the SHA-256 hash of the entry without its own hash.
+func (e Entry) hash() (string, error) {
+	e.Hash = ""
+	data, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// readLast returns the last complete entry of the file, nil when it is missing
+// or empty, along with the size of the file up to that entry and the torn
+// entry following it, if any.
+func readLast(path string) (*Entry, int64, []byte, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, 0, nil, nil
+	}
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("could not open audit log %s: %w", path, err)
+	}
+	defer file.Close()
+	var last []byte
+	var size int64
+	torn, err := readLines(file, func(line []byte) error {
+		last = append(last[:0], line...)
+		size += int64(len(line)) + 1
+		return nil
+	})
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("could not read audit log %s: %w", path, err)
+	}
+	if last == nil {
+		return nil, size, torn, nil
+	}
+	entry := &Entry{}
+	if err := json.Unmarshal(last, entry); err != nil {
+		return nil, 0, nil, fmt.Errorf("could not read last entry of audit log %s: %w", path, err)
+	}
+	return entry, size, torn, nil
+}
+
+// readLines calls fn with every line of the reader ending in a newline and
+// returns the bytes after the last one, which belong to a torn entry.
+func readLines(r io.Reader, fn func(line []byte) error) ([]byte, error) {
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				return line, nil
+			}
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := fn(bytes.TrimSuffix(line, []byte("\n"))); err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package audit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLog_Verify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, log.Append(Entry{Time: time.Now(), Caller: "ci", Image: "registry.local/alpine:3.13", Outcome: OutcomeSuccess, Status: 200}))
+	assert.NoError(t, log.Append(Entry{Time: time.Now(), Caller: "ci", Image: "registry.local/billing:1", Outcome: OutcomeDenied, Status: 403}))
+	assert.NoError(t, log.Close())
+
+	// reopening continues the chain
+	log, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, log.Append(Entry{Time: time.Now(), Caller: "alice", Image: "registry.local/alpine:3.13", Outcome: OutcomeSuccess, Status: 200}))
+	assert.NoError(t, log.Close())
+
+	count, err := Verify(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+
+	tests := []struct {
+		description string
+		content     string
+		wantCount   int
+		wantError   string
+	}{
+		{
+			description: "detect a modified entry",
+			content:     lines[0] + strings.Replace(lines[1], `"outcome":"denied"`, `"outcome":"success"`, 1) + lines[2],
+			wantCount:   1,
+			wantError:   "line 2: entry 2 was modified",
+		},
+		{
+			description: "detect a removed entry",
+			content:     lines[0] + lines[2],
+			wantCount:   1,
+			wantError:   "line 2: expected entry 2, found 3",
+		},
+		{
+			description: "detect a reordered entry",
+			content:     lines[1] + lines[0] + lines[2],
+			wantCount:   0,
+			wantError:   "line 1: expected entry 1, found 2",
+		},
+		{
+			description: "detect a torn entry",
+			content:     lines[0] + lines[1] + lines[2][:20],
+			wantCount:   2,
+			wantError:   "line 3: torn entry, its write never completed",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			tampered := filepath.Join(t.TempDir(), "audit.log")
+			if err := ioutil.WriteFile(tampered, []byte(test.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			count, err := Verify(tampered)
+			assert.EqualError(t, err, test.wantError)
+			assert.Equal(t, test.wantCount, count)
+		})
+	}
+}
+
+func TestOpen_TornEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	log, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, log.Append(Entry{Time: time.Now(), Caller: "ci", Image: "registry.local/alpine:3.13", Outcome: OutcomeSuccess, Status: 200}))
+	assert.NoError(t, log.Close())
+	// the operator stopped in the middle of a write
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"seq":2,"time":"2021-`)
+	file.Close()
+
+	log, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, log.Append(Entry{Time: time.Now(), Caller: "ci", Image: "registry.local/alpine:3.13", Outcome: OutcomeSuccess, Status: 200}))
+	assert.NoError(t, log.Close())
+
+	count, err := Verify(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
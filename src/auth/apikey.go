@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// APIKeyHeader carries the API key of a request.
+const APIKeyHeader = "X-API-Key"
+
+// Key is an API key, only its hash is kept.
+type Key struct {
+	Identity string   `mapstructure:"identity"`
+	Groups   []string `mapstructure:"groups"`
+	// Hash is the hex encoded SHA-256 hash of the key.
+	Hash string `mapstructure:"hash"`
+}
+
+// APIKeys authenticates requests by their API key.
+type APIKeys struct {
+	keys []apiKey
+}
+
+type apiKey struct {
+	identity Identity
+	hash     []byte
+}
+
+// NewAPIKeys validates the keys and builds their authenticator.
+func NewAPIKeys(keys []Key) (*APIKeys, error) {
+	a := &APIKeys{}
+	for _, key := range keys {
+		if key.Identity == "" {
+			return nil, fmt.Errorf("api key without identity")
+		}
+		hash, err := hex.DecodeString(key.Hash)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("api key of %s: hash must be a hex encoded SHA-256 hash", key.Identity)
+		}
+		a.keys = append(a.keys, apiKey{
+			identity: Identity{Name: key.Identity, Method: "api-key", Groups: key.Groups},
+			hash:     hash,
+		})
+	}
+	return a, nil
+}
+
+func (a *APIKeys) Authenticate(c *fiber.Ctx) (*Identity, error) {
+	key := c.Get(APIKeyHeader)
+	if key == "" {
+		return nil, nil
+	}
+	hash := sha256.Sum256([]byte(key))
+	for _, k := range a.keys {
+		if subtle.ConstantTimeCompare(hash[:], k.hash) == 1 {
+			identity := k.identity
+			return &identity, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown api key")
+}
+
+// HashKey returns the hash of an API key as it is stored in the config file.
+func HashKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+
+	"docker-operator/config"
+	"docker-operator/log"
+	"docker-operator/src/common"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const identityKey = "identity"
+
+// Identity is the authenticated caller of a request.
+type Identity struct {
+	Name string `json:"name"`
+	// Method is how the caller was authenticated, e.g. "api-key", "hmac" or "jwt".
+	Method string `json:"method"`
+	// Issuer of the token the caller was authenticated with.
+	Issuer string   `json:"issuer,omitempty"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+// Authenticator authenticates a request. It returns a nil identity and no
+// error when the request does not carry its kind of credentials.
+type Authenticator interface {
+	Authenticate(c *fiber.Ctx) (*Identity, error)
+}
+
+// Config is the file listing the callers allowed to run images.
+type Config struct {
+	// Keys are API keys sent in the X-API-Key header, stored as SHA-256 hashes.
+	Keys []Key `mapstructure:"keys"`
+	// Signers sign their requests with a shared secret.
+	Signers []Signer `mapstructure:"signers"`
+	// JWT accepts bearer tokens of an OIDC provider.
+	JWT *JWTConfig `mapstructure:"jwt"`
+	// MaxSkew is how far the timestamp of a signed request may be from now, 5 minutes by default.
+	MaxSkew time.Duration `mapstructure:"max_skew"`
+}
+
+// Load reads the authentication config from the given file and builds its authenticators.
+func Load(path string) ([]Authenticator, error) {
+	cfg := Config{}
+	if err := config.LoadFile(path, &cfg); err != nil {
+		return nil, err
+	}
+	return cfg.Authenticators()
+}
+
+// Authenticators builds the authenticators of the configured credentials.
+func (cfg Config) Authenticators() ([]Authenticator, error) {
+	var authenticators []Authenticator
+	if len(cfg.Keys) > 0 {
+		keys, err := NewAPIKeys(cfg.Keys)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, keys)
+	}
+	if len(cfg.Signers) > 0 {
+		signatures, err := NewSignatures(cfg.Signers, cfg.MaxSkew)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, signatures)
+	}
+	if cfg.JWT != nil {
+		jwt, err := NewJWT(*cfg.JWT)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, jwt)
+	}
+	if len(authenticators) == 0 {
+		return nil, fmt.Errorf("no keys, signers or jwt configured")
+	}
+	return authenticators, nil
+}
+
+// Middleware rejects requests no authenticator accepts and attaches the identity
+// of the caller to the others.
+func Middleware(authenticators ...Authenticator) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		for _, authenticator := range authenticators {
+			identity, err := authenticator.Authenticate(c)
+			if err != nil {
+				log.FromContext(c.UserContext()).Warnw("authentication failed", "path", c.Path(), "client", c.IP(), "error", err)
+				return unauthorized(c)
+			}
+			if identity != nil {
+				c.Locals(identityKey, identity)
+				return c.Next()
+			}
+		}
+		return unauthorized(c)
+	}
+}
+
+// FromContext returns the identity of the caller, nil when the request was not authenticated.
+func FromContext(c *fiber.Ctx) *Identity {
+	identity, _ := c.Locals(identityKey).(*Identity)
+	return identity
+}
+
+func unauthorized(c *fiber.Ctx) error {
+	return common.ErrorJSON(c, fiber.StatusUnauthorized, fiber.Map{
+		"msg": "unauthorized",
+	})
+}
@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	now := time.Unix(1622505600, 0)
+	keys, err := NewAPIKeys([]Key{{Identity: "ci", Groups: []string{"builders"}, Hash: HashKey("ci-key")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signatures, err := NewSignatures([]Signer{{ID: "billing", Identity: "billing-service", Secret: "shared"}}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signatures.now = func() time.Time { return now }
+
+	app := fiber.New()
+	app.Post("/exec/*", Middleware(keys, signatures), func(c *fiber.Ctx) error {
+		identity := FromContext(c)
+		return c.SendString(identity.Method + ":" + identity.Name)
+	})
+
+	signed := func(timestamp time.Time, body string) map[string]string {
+		unix := timestamp.Unix()
+		return map[string]string{
+			SignatureKeyHeader:       "billing",
+			SignatureTimestampHeader: strconv.FormatInt(unix, 10),
+			SignatureHeader:          Sign("shared", unix, "POST", "/exec/tool/1?a=b", []byte(body)),
+		}
+	}
+	replayed := signed(now, "{}")
+
+	tests := []struct {
+		description        string
+		headers            map[string]string
+		body               string
+		expectedStatusCode int
+		expectedBody       string
+	}{
+		{
+			description:        "reject requests without credentials",
+			expectedStatusCode: http.StatusUnauthorized,
+			expectedBody:       `{"error":true,"msg":"unauthorized"}`,
+		},
+		{
+			description:        "accept a known api key",
+			headers:            map[string]string{APIKeyHeader: "ci-key"},
+			expectedStatusCode: http.StatusOK,
+			expectedBody:       "api-key:ci",
+		},
+		{
+			description:        "reject an unknown api key",
+			headers:            map[string]string{APIKeyHeader: "guess"},
+			expectedStatusCode: http.StatusUnauthorized,
+			expectedBody:       `{"error":true,"msg":"unauthorized"}`,
+		},
+		{
+			description:        "accept a signed request",
+			headers:            replayed,
+			body:               "{}",
+			expectedStatusCode: http.StatusOK,
+			expectedBody:       "hmac:billing-service",
+		},
+		{
+			description:        "reject a replayed signature",
+			headers:            replayed,
+			body:               "{}",
+			expectedStatusCode: http.StatusUnauthorized,
+			expectedBody:       `{"error":true,"msg":"unauthorized"}`,
+		},
+		{
+			description:        "reject a signature of another body",
+			headers:            signed(now.Add(-time.Second), "{}"),
+			body:               `{"amount":1000}`,
+			expectedStatusCode: http.StatusUnauthorized,
+			expectedBody:       `{"error":true,"msg":"unauthorized"}`,
+		},
+		{
+			description:        "reject an expired signature",
+			headers:            signed(now.Add(-2*time.Minute), "{}"),
+			body:               "{}",
+			expectedStatusCode: http.StatusUnauthorized,
+			expectedBody:       `{"error":true,"msg":"unauthorized"}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/exec/tool/1?a=b", strings.NewReader(test.body))
+			for key, value := range test.headers {
+				req.Header.Set(key, value)
+			}
+			resp, err := app.Test(req, -1)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedStatusCode, resp.StatusCode)
+			body, err := ioutil.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedBody, string(body))
+		})
+	}
+}
+
+func TestNewAPIKeys(t *testing.T) {
+	_, err := NewAPIKeys([]Key{{Identity: "ci", Hash: "not-a-hash"}})
+	assert.EqualError(t, err, "api key of ci: hash must be a hex encoded SHA-256 hash")
+}
@@ -0,0 +1,238 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+)
+
+// minRefetch limits how often an unknown key id triggers a refetch of the key set.
+const minRefetch = time.Minute
+
+// minRetry and maxRetry bound the backoff between the failed fetches of the key set.
+const (
+	minRetry = 5 * time.Second
+	maxRetry = 5 * time.Minute
+)
+
+// KeySet caches the public keys of a JWKS file or URL. The keys are fetched again
+// after the refresh interval and when a token is signed with an unknown key, so
+// rotated keys are picked up. While the key set cannot be fetched the cached keys
+// are kept, and the fetch is retried with a backoff.
+type KeySet struct {
+	file     string
+	url      string
+	client   *http.Client
+	interval time.Duration
+	now      func() time.Time
+	group    singleflight.Group
+
+	mu          sync.Mutex
+	keys        map[string]crypto.PublicKey
+	fetchedAt   time.Time
+	attemptedAt time.Time
+	failures    int
+}
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// NewKeySet builds a key set read from a file or fetched from a URL and fetches it once.
+func NewKeySet(file, url string, interval time.Duration) (*KeySet, error) {
+	if (file == "") == (url == "") {
+		return nil, fmt.Errorf("exactly one of jwks_file and jwks_url is required")
+	}
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	k := &KeySet{
+		file:     file,
+		url:      url,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		interval: interval,
+		now:      time.Now,
+	}
+	if err := k.refresh(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Key returns the key with the given id. An empty id matches the only key of the set.
+func (k *KeySet) Key(kid string) (crypto.PublicKey, error) {
+	k.mu.Lock()
+	now := k.now()
+	key, ok := k.lookup(kid)
+	due := now.Sub(k.fetchedAt) > k.interval || (!ok && now.Sub(k.attemptedAt) > minRefetch)
+	if k.failures > 0 && now.Sub(k.attemptedAt) < retryAfter(k.failures) {
+		due = false
+	}
+	k.mu.Unlock()
+
+	if due {
+		// the callers finding the key set due share one fetch
+		if _, err, _ := k.group.Do("refresh", func() (interface{}, error) { return nil, k.refresh() }); err != nil {
+			zap.S().Errorw("could not refresh key set, keeping the cached keys", "error", err)
+		} else {
+			k.mu.Lock()
+			key, ok = k.lookup(kid)
+			k.mu.Unlock()
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+func (k *KeySet) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(k.keys) == 1 {
+		for _, key := range k.keys {
+			return key, true
+		}
+	}
+	key, ok := k.keys[kid]
+	return key, ok
+}
+
+// retryAfter is the backoff after the given number of failed fetches in a row.
+func retryAfter(failures int) time.Duration {
+	backoff := minRetry
+	for i := 1; i < failures && backoff < maxRetry; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRetry {
+		return maxRetry
+	}
+	return backoff
+}
+
+func (k *KeySet) refresh() error {
+	keys, err := k.load()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.attemptedAt = k.now()
+	if err != nil {
+		k.failures++
+		return err
+	}
+	k.keys = keys
+	k.fetchedAt = k.attemptedAt
+	k.failures = 0
+	return nil
+}
+
+// load fetches and parses the key set. The keys of unsupported types or curves
+// are skipped, so they do not take the other keys of the set down with them.
+func (k *KeySet) load() (map[string]crypto.PublicKey, error) {
+	data, err := k.fetch()
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("could not parse key set: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, key := range set.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		publicKey, err := key.publicKey()
+		if err != nil {
+			zap.S().Warnw("skipping key of key set", "kid", key.Kid, "error", err)
+			continue
+		}
+		keys[key.Kid] = publicKey
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("key set has no usable signing key")
+	}
+	return keys, nil
+}
+
+func (k *KeySet) fetch() ([]byte, error) {
+	if k.file != "" {
+		return ioutil.ReadFile(k.file)
+	}
+	resp, err := k.client.Get(k.url)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch key set: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("could not fetch key set: %s", resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+func (key jwk) publicKey() (crypto.PublicKey, error) {
+	switch key.Kty {
+	case "RSA":
+		n, err := decodeBigInt(key.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(key.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch key.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", key.Crv)
+		}
+		x, err := decodeBigInt(key.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(key.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on curve %s", key.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", key.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key parameter: %w", err)
+	}
+	return new(big.Int).SetBytes(data), nil
+}
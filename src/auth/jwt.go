@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// JWTConfig validates bearer tokens issued by an OIDC provider.
+type JWTConfig struct {
+	Issuer   string `mapstructure:"issuer"`
+	Audience string `mapstructure:"audience"`
+	// JWKSFile or JWKSURL holds the public keys of the issuer.
+	JWKSFile string `mapstructure:"jwks_file"`
+	JWKSURL  string `mapstructure:"jwks_url"`
+	// RefreshInterval between two fetches of the keys, 1 hour by default.
+	RefreshInterval time.Duration `mapstructure:"refresh_interval"`
+	// GroupsClaim names the claim listing the groups of the caller, "groups" by default.
+	GroupsClaim string `mapstructure:"groups_claim"`
+	// Leeway tolerates clock skew when checking exp and nbf.
+	Leeway time.Duration `mapstructure:"leeway"`
+}
+
+var algorithms = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+}
+
+// JWT authenticates requests by a bearer token signed with RS* or ES* algorithms.
+type JWT struct {
+	config JWTConfig
+	keys   *KeySet
+	now    func() time.Time
+}
+
+// NewJWT validates the config and fetches the keys of the issuer.
+func NewJWT(cfg JWTConfig) (*JWT, error) {
+	if cfg.Issuer == "" || cfg.Audience == "" {
+		return nil, fmt.Errorf("jwt: issuer and audience are required")
+	}
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+	keys, err := NewKeySet(cfg.JWKSFile, cfg.JWKSURL, cfg.RefreshInterval)
+	if err != nil {
+		return nil, fmt.Errorf("jwt: %w", err)
+	}
+	return &JWT{config: cfg, keys: keys, now: time.Now}, nil
+}
+
+func (j *JWT) Authenticate(c *fiber.Ctx) (*Identity, error) {
+	header := c.Get(fiber.HeaderAuthorization)
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil, nil
+	}
+	claims, err := j.Validate(strings.TrimPrefix(header, "Bearer "))
+	if err != nil {
+		return nil, err
+	}
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, fmt.Errorf("token without subject")
+	}
+	return &Identity{
+		Name:   subject,
+		Method: "jwt",
+		Issuer: j.config.Issuer,
+		Groups: stringList(claims[j.config.GroupsClaim]),
+	}, nil
+}
+
+// Validate checks the signature, issuer, audience and lifetime of a token and returns its claims.
+func (j *JWT) Validate(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header: %w", err)
+	}
+	hash, ok := algorithms[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+	key, err := j.keys.Key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature")
+	}
+	if err := verify(header.Alg, hash, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %w", err)
+	}
+	if issuer, _ := claims["iss"].(string); issuer != j.config.Issuer {
+		return nil, fmt.Errorf("unexpected issuer %q", issuer)
+	}
+	if !contains(stringList(claims["aud"]), j.config.Audience) {
+		return nil, fmt.Errorf("token not issued for audience %q", j.config.Audience)
+	}
+	now := j.now()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("token without expiry")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(j.config.Leeway)) {
+		return nil, fmt.Errorf("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(j.config.Leeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, fmt.Errorf("token not valid yet")
+	}
+	return claims, nil
+}
+
+func verify(alg string, hash crypto.Hash, key crypto.PublicKey, signed, signature []byte) error {
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			return fmt.Errorf("algorithm %s does not match the key", alg)
+		}
+		if err := rsa.VerifyPKCS1v15(k, hash, digest, signature); err != nil {
+			return fmt.Errorf("invalid token signature")
+		}
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(alg, "ES") || len(signature) != 2*size {
+			return fmt.Errorf("algorithm %s does not match the key", alg)
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return fmt.Errorf("invalid token signature")
+		}
+	default:
+		return fmt.Errorf("unsupported key type %T", key)
+	}
+	return nil
+}
+
+func decodeSegment(segment string, out interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+// stringList reads a claim holding a string or a list of strings.
+func stringList(claim interface{}) []string {
+	switch value := claim.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		list := make([]string, 0, len(value))
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,307 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testIssuer   = "https://idp.example.com"
+	testAudience = "docker-operator"
+)
+
+func TestJWT_Validate(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, jwksFile, rsaJWK("rsa-1", &rsaKey.PublicKey), ecJWK("ec-1", &ecKey.PublicKey))
+	validator, err := NewJWT(JWTConfig{Issuer: testIssuer, Audience: testAudience, JWKSFile: jwksFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1622505600, 0)
+	validator.now = func() time.Time { return now }
+
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss":    testIssuer,
+			"aud":    []string{"other", testAudience},
+			"sub":    "alice",
+			"groups": []string{"builders", "oncall"},
+			"exp":    now.Add(time.Hour).Unix(),
+		}
+		for key, value := range overrides {
+			c[key] = value
+		}
+		return c
+	}
+
+	tests := []struct {
+		description string
+		token       string
+		wantError   string
+	}{
+		{
+			description: "accept a token signed with RS256",
+			token:       signRS256(t, rsaKey, "rsa-1", claims(nil)),
+		},
+		{
+			description: "accept a token signed with ES256",
+			token:       signES256(t, ecKey, "ec-1", claims(nil)),
+		},
+		{
+			description: "reject a token signed with an unknown key",
+			token:       signRS256(t, otherKey, "rsa-1", claims(nil)),
+			wantError:   "invalid token signature",
+		},
+		{
+			description: "reject a token of another issuer",
+			token:       signRS256(t, rsaKey, "rsa-1", claims(map[string]interface{}{"iss": "https://evil.example.com"})),
+			wantError:   `unexpected issuer "https://evil.example.com"`,
+		},
+		{
+			description: "reject a token for another audience",
+			token:       signRS256(t, rsaKey, "rsa-1", claims(map[string]interface{}{"aud": "other"})),
+			wantError:   `token not issued for audience "docker-operator"`,
+		},
+		{
+			description: "reject an expired token",
+			token:       signRS256(t, rsaKey, "rsa-1", claims(map[string]interface{}{"exp": now.Add(-time.Minute).Unix()})),
+			wantError:   "token expired",
+		},
+		{
+			description: "reject unsigned tokens",
+			token:       encodeSegment(t, map[string]string{"alg": "none"}) + "." + encodeSegment(t, claims(nil)) + ".",
+			wantError:   `unsupported algorithm "none"`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			_, err := validator.Validate(test.token)
+			if test.wantError == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, test.wantError)
+			}
+		})
+	}
+
+	t.Run("attach the subject and groups to the identity", func(t *testing.T) {
+		app := fiber.New()
+		app.Get("/", Middleware(validator), func(c *fiber.Ctx) error {
+			return c.JSON(FromContext(c))
+		})
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Bearer "+signRS256(t, rsaKey, "rsa-1", claims(nil)))
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		assert.Equal(t, `{"name":"alice","method":"jwt","issuer":"https://idp.example.com","groups":["builders","oncall"]}`, string(body))
+	})
+}
+
+func TestKeySet_Rotation(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rotated int32
+	var fetches int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		keys := []map[string]string{rsaJWK("old", &oldKey.PublicKey)}
+		if atomic.LoadInt32(&rotated) == 1 {
+			keys = []map[string]string{rsaJWK("new", &newKey.PublicKey)}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	}))
+	defer server.Close()
+
+	validator, err := NewJWT(JWTConfig{Issuer: testIssuer, Audience: testAudience, JWKSURL: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	claims := map[string]interface{}{"iss": testIssuer, "aud": testAudience, "sub": "alice", "exp": now.Add(time.Hour).Unix()}
+	_, err = validator.Validate(signRS256(t, oldKey, "old", claims))
+	assert.NoError(t, err)
+
+	atomic.StoreInt32(&rotated, 1)
+	// unknown key ids are only refetched once per minute
+	validator.keys.now = func() time.Time { return now.Add(2 * minRefetch) }
+	_, err = validator.Validate(signRS256(t, newKey, "new", claims))
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetches))
+}
+
+func TestKeySet_Unavailable(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var down int32
+	var fetches int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		if atomic.LoadInt32(&down) == 1 {
+			<-release
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{rsaJWK("rsa-1", &key.PublicKey)}})
+	}))
+	defer server.Close()
+
+	keys, err := NewKeySet("", server.URL, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&down, 1)
+	now := time.Now().Add(2 * time.Hour)
+	keys.now = func() time.Time { return now }
+
+	// the callers finding the key set stale share one fetch and keep the cached key
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := keys.Key("rsa-1")
+			assert.NoError(t, err)
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetches))
+
+	// the fetch is not retried before the backoff
+	_, err = keys.Key("rsa-1")
+	assert.NoError(t, err)
+	_, err = keys.Key("unknown")
+	assert.Error(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetches))
+	now = now.Add(minRetry + time.Second)
+	_, err = keys.Key("rsa-1")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&fetches))
+	now = now.Add(minRetry + time.Second)
+	_, err = keys.Key("rsa-1")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&fetches), "the backoff grows")
+}
+
+func TestKeySet_UnsupportedKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, jwksFile,
+		map[string]string{"kid": "okp-1", "kty": "OKP", "crv": "Ed25519", "x": "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"},
+		map[string]string{"kid": "ec-1", "kty": "EC", "crv": "secp256k1", "x": "AA", "y": "AA"},
+		rsaJWK("rsa-1", &key.PublicKey),
+	)
+	keys, err := NewKeySet(jwksFile, "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = keys.Key("rsa-1")
+	assert.NoError(t, err)
+	_, err = keys.Key("okp-1")
+	assert.Error(t, err)
+}
+
+func writeJWKS(t *testing.T, path string, keys ...map[string]string) {
+	data, err := json.Marshal(map[string]interface{}{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kid": kid,
+		"kty": "RSA",
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kid": kid,
+		"kty": "EC",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.Bytes()),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.Bytes()),
+	}
+}
+
+func encodeSegment(t *testing.T, value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func signRS256(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	signed := encodeSegment(t, map[string]string{"alg": "RS256", "kid": kid}) + "." + encodeSegment(t, claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func signES256(t *testing.T, key *ecdsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	signed := encodeSegment(t, map[string]string{"alg": "ES256", "kid": kid}) + "." + encodeSegment(t, claims)
+	digest := sha256.Sum256([]byte(signed))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
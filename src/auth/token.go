@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// AdminTokenMethod is the method of the identity authenticated with the admin token.
+const AdminTokenMethod = "admin-token"
+
+// Token authenticates requests carrying a static token as bearer token. Other
+// bearer tokens are left to the next authenticators.
+type Token struct {
+	token string
+}
+
+// NewToken builds the authenticator of the admin token.
+func NewToken(token string) *Token {
+	return &Token{token: token}
+}
+
+func (t *Token) Authenticate(c *fiber.Ctx) (*Identity, error) {
+	header := c.Get(fiber.HeaderAuthorization)
+	if t.token == "" || !strings.HasPrefix(header, "Bearer ") {
+		return nil, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(header, "Bearer ")), []byte(t.token)) != 1 {
+		return nil, nil
+	}
+	return &Identity{Name: "admin", Method: AdminTokenMethod}, nil
+}
@@ -0,0 +1,120 @@
+package coalesce
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"docker-operator/config"
+	"docker-operator/src/docker"
+	"docker-operator/src/registry"
+)
+
+// Rule lets the identical in-flight executions of the images matching its glob share one run.
+type Rule struct {
+	// Image is a glob matched against the image path and the full name, e.g. "tools/*".
+	Image string `mapstructure:"image"`
+}
+
+// Config holds the images whose identical executions are coalesced.
+type Config struct {
+	Images []Rule `mapstructure:"images"`
+}
+
+// Load reads the coalesced images from a file.
+func Load(path string) (Config, error) {
+	cfg := Config{}
+	if err := config.LoadFile(path, &cfg); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// call is a run shared by the executions with the same key.
+type call struct {
+	// leader is the id of the execution running the call.
+	leader  string
+	done    chan struct{}
+	out     []byte
+	headers map[string]string
+	err     error
+}
+
+// Group tracks the runs in flight of the coalesced images.
+type Group struct {
+	rules []Rule
+
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// New validates the rules of the group.
+func New(cfg Config) (*Group, error) {
+	for i, rule := range cfg.Images {
+		if rule.Image == "" {
+			return nil, fmt.Errorf("coalescing rule %d has no image", i)
+		}
+	}
+	return &Group{rules: cfg.Images, calls: make(map[string]*call)}, nil
+}
+
+// Enabled reports whether the executions of the image are coalesced.
+func (g *Group) Enabled(image string) bool {
+	for _, rule := range g.rules {
+		if registry.MatchImage(rule.Image, image) {
+			return true
+		}
+	}
+	return false
+}
+
+// Do runs fn once for the calls with the same key in flight and hands every
+// caller a copy of its output. shared reports whether the output came from the
+// run of another caller, leader is the id of the execution of that caller. A
+// caller whose context is done stops waiting, the run goes on for the others.
+func (g *Group) Do(ctx context.Context, key string, fn func() ([]byte, *docker.Headers, error)) (out []byte, headers *docker.Headers, leader string, shared bool, err error) {
+	g.mu.Lock()
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		select {
+		case <-c.done:
+			out, headers, err := c.result()
+			return out, headers, c.leader, true, err
+		case <-ctx.Done():
+			return nil, nil, c.leader, true, fmt.Errorf("%w: %v", docker.CancelledError, ctx.Err())
+		}
+	}
+	c := &call{leader: docker.ExecutionID(ctx), done: make(chan struct{})}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	out, headers, err = fn()
+	c.out, c.err = out, err
+	if headers != nil {
+		c.headers = headers.Header
+	}
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	close(c.done)
+	return out, headers, "", false, err
+}
+
+// InFlight returns the number of runs in flight.
+func (g *Group) InFlight() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.calls)
+}
+
+func (c *call) result() ([]byte, *docker.Headers, error) {
+	if c.err != nil {
+		return nil, nil, c.err
+	}
+	headers := make(map[string]string, len(c.headers))
+	for name, value := range c.headers {
+		headers[name] = value
+	}
+	return append([]byte{}, c.out...), &docker.Headers{Header: headers}, nil
+}
@@ -0,0 +1,166 @@
+package coalesce
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"docker-operator/src/docker"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+const testImage = "registry.local/tools/report:1"
+
+func newTestService(t *testing.T, rules ...Rule) (*docker.MockServiceInterface, *Group, docker.ServiceInterface) {
+	ctrl := gomock.NewController(t)
+	t.Cleanup(ctrl.Finish)
+	next := docker.NewMockServiceInterface(ctrl)
+	next.EXPECT().ImageDigest(gomock.Any(), gomock.Any()).Return("sha256:1", nil).AnyTimes()
+	group, err := New(Config{Images: rules})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return next, group, NewService(next, group)
+}
+
+// waitInFlight waits until the run is in flight so the next executions join it.
+func waitInFlight(t *testing.T, group *Group) {
+	for i := 0; group.InFlight() == 0; i++ {
+		if i == 500 {
+			t.Fatal("no run in flight")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestService_Coalesce(t *testing.T) {
+	next, group, service := newTestService(t, Rule{Image: "tools/*"})
+	release := make(chan struct{})
+	next.EXPECT().RunContainer(testImage, []string{"a=1"}, gomock.Any()).
+		DoAndReturn(func(image string, params []string, ctx context.Context) ([]byte, *docker.Headers, error) {
+			<-release
+			return []byte("ok"), &docker.Headers{Header: map[string]string{"Content-Type": "text/plain"}}, nil
+		})
+	next.EXPECT().RunContainerPost(testImage, []string{"a=1"}, gomock.Any()).Return([]byte("posted"), &docker.Headers{}, nil)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	joined := make(map[string]string)
+	digests := make(map[string]string)
+	run := func(id string) {
+		defer wg.Done()
+		ctx := docker.WithExecutionID(context.Background(), id)
+		ctx = docker.WithExecutionJoined(ctx, func(leader string) {
+			mu.Lock()
+			defer mu.Unlock()
+			joined[id] = leader
+		})
+		ctx = docker.WithImageDigest(ctx, func(digest string) {
+			mu.Lock()
+			defer mu.Unlock()
+			digests[id] = digest
+		})
+		out, headers, err := service.RunContainer(testImage, []string{"a=1"}, ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, "ok", string(out))
+		assert.Equal(t, map[string]string{"Content-Type": "text/plain"}, headers.Header)
+	}
+	wg.Add(1)
+	go run("leader")
+	waitInFlight(t, group)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go run(fmt.Sprintf("waiter-%d", i))
+	}
+	// another method never joins the run
+	out, _, err := service.RunContainerPost(testImage, []string{"a=1"}, context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "posted", string(out))
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, 0, group.InFlight())
+	// the waiters are joined to the execution that ran the container
+	assert.Len(t, joined, 5)
+	for id, leader := range joined {
+		assert.Equal(t, "leader", leader, id)
+		assert.Equal(t, "sha256:1", digests[id], id)
+	}
+}
+
+func TestService_Replay(t *testing.T) {
+	next, group, service := newTestService(t, Rule{Image: "tools/*"})
+	release := make(chan struct{})
+	next.EXPECT().RunContainer(testImage, nil, gomock.Any()).
+		DoAndReturn(func(image string, params []string, ctx context.Context) ([]byte, *docker.Headers, error) {
+			<-release
+			return []byte("ok"), &docker.Headers{}, nil
+		})
+	next.EXPECT().RunContainer(testImage, nil, gomock.Any()).Return([]byte("replayed"), &docker.Headers{}, nil)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		service.RunContainer(testImage, nil, context.Background())
+	}()
+	waitInFlight(t, group)
+	// the replay does not join the identical execution in flight
+	joined := false
+	ctx := docker.WithExecutionJoined(docker.WithReplay(context.Background()), func(string) { joined = true })
+	out, _, err := service.RunContainer(testImage, nil, ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "replayed", string(out))
+	assert.False(t, joined)
+	close(release)
+	<-done
+}
+
+func TestService_NotCoalesced(t *testing.T) {
+	next, _, service := newTestService(t, Rule{Image: "other/*"})
+	next.EXPECT().RunContainer(testImage, nil, gomock.Any()).Return([]byte("ok"), &docker.Headers{}, nil).Times(2)
+	service.RunContainer(testImage, nil, context.Background())
+	service.RunContainer(testImage, nil, context.Background())
+}
+
+func TestService_Cancel(t *testing.T) {
+	next, group, service := newTestService(t, Rule{Image: "tools/*"})
+	started := make(chan struct{}, 2)
+	next.EXPECT().RunContainer(testImage, nil, gomock.Any()).
+		DoAndReturn(func(image string, params []string, ctx context.Context) ([]byte, *docker.Headers, error) {
+			started <- struct{}{}
+			<-ctx.Done()
+			return nil, nil, docker.CancelledError
+		})
+	next.EXPECT().RunContainer(testImage, nil, gomock.Any()).Return([]byte("ok"), &docker.Headers{}, nil)
+
+	leader, cancelLeader := context.WithCancel(context.Background())
+	leaderErr := make(chan error)
+	go func() {
+		_, _, err := service.RunContainer(testImage, nil, leader)
+		leaderErr <- err
+	}()
+	<-started
+	waitInFlight(t, group)
+
+	// a waiter that leaves gets cancelled, the run goes on
+	waiter, cancelWaiter := context.WithCancel(context.Background())
+	cancelWaiter()
+	_, _, err := service.RunContainer(testImage, nil, waiter)
+	assert.ErrorIs(t, err, docker.CancelledError)
+
+	// a waiter of a run cancelled for its caller runs the image again
+	result := make(chan string)
+	go func() {
+		out, _, err := service.RunContainer(testImage, nil, context.Background())
+		assert.NoError(t, err)
+		result <- string(out)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancelLeader()
+	assert.ErrorIs(t, <-leaderErr, docker.CancelledError)
+	assert.Equal(t, "ok", <-result)
+}
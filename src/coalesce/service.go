@@ -0,0 +1,73 @@
+package coalesce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"docker-operator/log"
+	"docker-operator/src/docker"
+)
+
+// Service shares one run between the identical executions in flight of the
+// coalesced images, matched on the digest of the image, the params and the method.
+type Service struct {
+	docker.ServiceInterface
+	Group *Group
+}
+
+func (s *Service) RunContainer(image string, params []string, ctx context.Context) ([]byte, *docker.Headers, error) {
+	return s.run("GET", image, params, ctx, s.ServiceInterface.RunContainer)
+}
+
+func (s *Service) RunContainerPost(image string, reqBody []string, ctx context.Context) ([]byte, *docker.Headers, error) {
+	return s.run("POST", image, reqBody, ctx, s.ServiceInterface.RunContainerPost)
+}
+
+func (s *Service) run(method, image string, params []string, ctx context.Context,
+	run func(string, []string, context.Context) ([]byte, *docker.Headers, error)) ([]byte, *docker.Headers, error) {
+	// a replay runs its own container, its output is compared with the recorded one
+	if !s.Group.Enabled(image) || docker.IsReplay(ctx) {
+		return run(image, params, ctx)
+	}
+	digest, digestErr := s.digest(image, ctx)
+	if digestErr != nil {
+		// not on the docker host yet, the executions share the pull of the reference
+		digest = fmt.Sprintf("reference %s", image)
+	}
+	key := method + "\n" + digest + "\n" + strings.Join(params, "\n")
+	for {
+		out, headers, leader, shared, err := s.Group.Do(ctx, key, func() ([]byte, *docker.Headers, error) {
+			return run(image, params, ctx)
+		})
+		// the run was cancelled for the caller that started it, not for this one
+		if shared && errors.Is(err, docker.CancelledError) && ctx.Err() == nil {
+			continue
+		}
+		if shared {
+			log.FromContext(ctx).Debugw("joined identical execution in flight", "image", image, "method", method, "leader", leader)
+			docker.ExecutionJoined(ctx, leader)
+			if err == nil && digestErr == nil {
+				docker.ImageDigestResolved(ctx, digest)
+			}
+		}
+		return out, headers, err
+	}
+}
+
+// digest returns the digest the image is pinned to, or the one of the local image.
+func (s *Service) digest(image string, ctx context.Context) (string, error) {
+	if i := strings.Index(image, "@"); i >= 0 {
+		return image[i+1:], nil
+	}
+	return s.ServiceInterface.ImageDigest(image, ctx)
+}
+
+// NewService wraps a service so the identical executions of the coalesced images share one run.
+func NewService(service docker.ServiceInterface, group *Group) docker.ServiceInterface {
+	return &Service{
+		ServiceInterface: service,
+		Group:            group,
+	}
+}
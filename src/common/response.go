@@ -0,0 +1,17 @@
+package common
+
+import (
+	"docker-operator/log"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ErrorJSON answers with a JSON error body, e.g. {"error":true,"msg":"..."},
+// holding the given fields and the id of the request.
+func ErrorJSON(c *fiber.Ctx, status int, body fiber.Map) error {
+	body["error"] = true
+	if id := log.RequestID(c.UserContext()); id != "" {
+		body["request_id"] = id
+	}
+	return c.Status(status).JSON(body)
+}
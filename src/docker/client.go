@@ -25,6 +25,9 @@ type ClientInterface interface {
 	ContainerLogs(ctx context.Context, container string, options types.ContainerLogsOptions) (io.ReadCloser, error)
 	ContainerRemove(ctx context.Context, containerID string, options types.ContainerRemoveOptions) error
 	ImageInspectWithRaw(ctx context.Context, imageID string) (types.ImageInspect, []byte, error)
+	ImageRemove(ctx context.Context, imageID string, options types.ImageRemoveOptions) ([]types.ImageDeleteResponseItem, error)
+	ImageList(ctx context.Context, options types.ImageListOptions) ([]types.ImageSummary, error)
+	ContainerKill(ctx context.Context, containerID, signal string) error
 }
 
 func (s *Client) ImagePull(ctx context.Context, refStr string, options types.ImagePullOptions) (io.ReadCloser, error) {
@@ -55,6 +58,18 @@ func (s *Client) ImageInspectWithRaw(ctx context.Context, imageID string) (types
 	return s.Client.ImageInspectWithRaw(ctx, imageID)
 }
 
+func (s *Client) ImageRemove(ctx context.Context, imageID string, options types.ImageRemoveOptions) ([]types.ImageDeleteResponseItem, error) {
+	return s.Client.ImageRemove(ctx, imageID, options)
+}
+
+func (s *Client) ImageList(ctx context.Context, options types.ImageListOptions) ([]types.ImageSummary, error) {
+	return s.Client.ImageList(ctx, options)
+}
+
+func (s *Client) ContainerKill(ctx context.Context, containerID, signal string) error {
+	return s.Client.ContainerKill(ctx, containerID, signal)
+}
+
 func NewClient() (ClientInterface, error) {
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
@@ -54,6 +54,20 @@ func (mr *MockClientInterfaceMockRecorder) ContainerCreate(ctx, config, hostConf
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ContainerCreate", reflect.TypeOf((*MockClientInterface)(nil).ContainerCreate), ctx, config, hostConfig, networkingConfig, platform, containerName)
 }
 
+// ContainerKill mocks base method.
+func (m *MockClientInterface) ContainerKill(ctx context.Context, containerID, signal string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ContainerKill", ctx, containerID, signal)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ContainerKill indicates an expected call of ContainerKill.
+func (mr *MockClientInterfaceMockRecorder) ContainerKill(ctx, containerID, signal interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ContainerKill", reflect.TypeOf((*MockClientInterface)(nil).ContainerKill), ctx, containerID, signal)
+}
+
 // ContainerLogs mocks base method.
 func (m *MockClientInterface) ContainerLogs(ctx context.Context, container string, options types.ContainerLogsOptions) (io.ReadCloser, error) {
 	m.ctrl.T.Helper()
@@ -128,6 +142,21 @@ func (mr *MockClientInterfaceMockRecorder) ImageInspectWithRaw(ctx, imageID inte
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImageInspectWithRaw", reflect.TypeOf((*MockClientInterface)(nil).ImageInspectWithRaw), ctx, imageID)
 }
 
+// ImageList mocks base method.
+func (m *MockClientInterface) ImageList(ctx context.Context, options types.ImageListOptions) ([]types.ImageSummary, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ImageList", ctx, options)
+	ret0, _ := ret[0].([]types.ImageSummary)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ImageList indicates an expected call of ImageList.
+func (mr *MockClientInterfaceMockRecorder) ImageList(ctx, options interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImageList", reflect.TypeOf((*MockClientInterface)(nil).ImageList), ctx, options)
+}
+
 // ImagePull mocks base method.
 func (m *MockClientInterface) ImagePull(ctx context.Context, refStr string, options types.ImagePullOptions) (io.ReadCloser, error) {
 	m.ctrl.T.Helper()
@@ -142,3 +171,18 @@ func (mr *MockClientInterfaceMockRecorder) ImagePull(ctx, refStr, options interf
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImagePull", reflect.TypeOf((*MockClientInterface)(nil).ImagePull), ctx, refStr, options)
 }
+
+// ImageRemove mocks base method.
+func (m *MockClientInterface) ImageRemove(ctx context.Context, imageID string, options types.ImageRemoveOptions) ([]types.ImageDeleteResponseItem, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ImageRemove", ctx, imageID, options)
+	ret0, _ := ret[0].([]types.ImageDeleteResponseItem)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ImageRemove indicates an expected call of ImageRemove.
+func (mr *MockClientInterfaceMockRecorder) ImageRemove(ctx, imageID, options interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImageRemove", reflect.TypeOf((*MockClientInterface)(nil).ImageRemove), ctx, imageID, options)
+}
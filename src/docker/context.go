@@ -0,0 +1,201 @@
+package docker
+
+import (
+	"context"
+
+	"docker-operator/log"
+	"docker-operator/src/tracing"
+)
+
+type contextKey int
+
+const (
+	containerCreatedKey contextKey = iota
+	containerExitedKey
+	containerOutputKey
+	requestHeaderKey
+	imagePulledKey
+	imageDigestKey
+	executionIDKey
+	executionJoinedKey
+	servedStaleKey
+	replayKey
+)
+
+// WithContainerCreated returns a context that reports the id of the container
+// created for an execution to fn.
+func WithContainerCreated(ctx context.Context, fn func(containerID string)) context.Context {
+	return context.WithValue(ctx, containerCreatedKey, fn)
+}
+
+func containerCreated(ctx context.Context, containerID string) {
+	if fn, ok := ctx.Value(containerCreatedKey).(func(string)); ok {
+		fn(containerID)
+	}
+}
+
+// WithContainerExited returns a context that reports the exit code of the
+// container of an execution to fn.
+func WithContainerExited(ctx context.Context, fn func(exitCode int64)) context.Context {
+	return context.WithValue(ctx, containerExitedKey, fn)
+}
+
+func containerExited(ctx context.Context, exitCode int64) {
+	if fn, ok := ctx.Value(containerExitedKey).(func(int64)); ok {
+		fn(exitCode)
+	}
+}
+
+// WithContainerOutput returns a context that reports the stdout and stderr of
+// the container of an execution to fn.
+func WithContainerOutput(ctx context.Context, fn func(stdout, stderr []byte)) context.Context {
+	return context.WithValue(ctx, containerOutputKey, fn)
+}
+
+func containerOutput(ctx context.Context, stdout, stderr []byte) {
+	if fn, ok := ctx.Value(containerOutputKey).(func([]byte, []byte)); ok {
+		fn(stdout, stderr)
+	}
+}
+
+// WithImagePulled returns a context that reports to fn whether the image of an
+// execution had to be downloaded, or was already up to date on the docker host.
+func WithImagePulled(ctx context.Context, fn func(downloaded bool)) context.Context {
+	return context.WithValue(ctx, imagePulledKey, fn)
+}
+
+func imagePulled(ctx context.Context, downloaded bool) {
+	if fn, ok := ctx.Value(imagePulledKey).(func(bool)); ok {
+		fn(downloaded)
+	}
+}
+
+// WithImageDigest returns a context that reports to fn the digest of the image
+// an execution runs, once it is pulled and reviewed. The hooks registered on the
+// context before keep being reported to.
+func WithImageDigest(ctx context.Context, fn func(digest string)) context.Context {
+	if outer, ok := ctx.Value(imageDigestKey).(func(string)); ok {
+		inner := fn
+		fn = func(digest string) {
+			inner(digest)
+			outer(digest)
+		}
+	}
+	return context.WithValue(ctx, imageDigestKey, fn)
+}
+
+// ImageDigestResolved reports the digest of the image the execution of the
+// context ran, or whose output it was answered with.
+func ImageDigestResolved(ctx context.Context, digest string) {
+	if fn, ok := ctx.Value(imageDigestKey).(func(string)); ok {
+		fn(digest)
+	}
+}
+
+// imageDigest inspects the image about to run, only when a hook wants its digest.
+func imageDigest(ctx context.Context, s *Service, image string) {
+	if _, ok := ctx.Value(imageDigestKey).(func(string)); !ok {
+		return
+	}
+	digest, err := s.ImageDigest(image, ctx)
+	if err != nil {
+		log.FromContext(ctx).Warnw("could not inspect the digest of the image", "image", image, "error", err)
+		return
+	}
+	ImageDigestResolved(ctx, digest)
+}
+
+// WithExecutionID returns a context carrying the id of the execution it runs.
+func WithExecutionID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, executionIDKey, id)
+}
+
+// ExecutionID returns the id of the execution the context runs, empty when there is none.
+func ExecutionID(ctx context.Context) string {
+	id, _ := ctx.Value(executionIDKey).(string)
+	return id
+}
+
+// WithExecutionJoined returns a context that reports to fn the id of the
+// execution whose run an execution joined instead of running its own container.
+func WithExecutionJoined(ctx context.Context, fn func(leader string)) context.Context {
+	return context.WithValue(ctx, executionJoinedKey, fn)
+}
+
+// ExecutionJoined reports that the execution of the context got its output
+// from the run of the leader execution.
+func ExecutionJoined(ctx context.Context, leader string) {
+	if fn, ok := ctx.Value(executionJoinedKey).(func(string)); ok {
+		fn(leader)
+	}
+}
+
+// WithServedStale returns a context that reports to fn the error of an execution
+// that was answered with an earlier output instead.
+func WithServedStale(ctx context.Context, fn func(err error)) context.Context {
+	return context.WithValue(ctx, servedStaleKey, fn)
+}
+
+// ServedStale reports that the execution of the context failed with err and
+// was answered with an earlier output.
+func ServedStale(ctx context.Context, err error) {
+	if fn, ok := ctx.Value(servedStaleKey).(func(error)); ok {
+		fn(err)
+	}
+}
+
+// WithReplay returns a context marking its execution as the replay of a recorded
+// one, which has to run its container instead of being answered with the output
+// of another execution.
+func WithReplay(ctx context.Context) context.Context {
+	return context.WithValue(ctx, replayKey, true)
+}
+
+// IsReplay reports whether the execution of the context replays a recorded one.
+func IsReplay(ctx context.Context) bool {
+	replay, _ := ctx.Value(replayKey).(bool)
+	return replay
+}
+
+// WithRequestHeader returns a context giving access to the headers of the
+// request an execution runs for through get.
+func WithRequestHeader(ctx context.Context, get func(name string, defaultValue ...string) string) context.Context {
+	return context.WithValue(ctx, requestHeaderKey, get)
+}
+
+// RequestHeader returns a header of the request the execution runs for, empty
+// when it was not sent or the execution does not run for a request.
+func RequestHeader(ctx context.Context, name string) string {
+	if get, ok := ctx.Value(requestHeaderKey).(func(string, ...string) string); ok {
+		return get(name)
+	}
+	return ""
+}
+
+// RequestIDLabel is the label of a container holding the id of the request it runs for.
+const RequestIDLabel = "docker-operator.request-id"
+
+// containerEnv adds the trace context and the request id to the environment of
+// the container, so the image can continue the trace and log the request.
+func containerEnv(ctx context.Context, env []string) []string {
+	var extra []string
+	if traceparent := tracing.TraceParent(ctx); traceparent != "" {
+		extra = append(extra, "TRACEPARENT="+traceparent)
+	}
+	if requestID := log.RequestID(ctx); requestID != "" {
+		extra = append(extra, "REQUEST_ID="+requestID)
+	}
+	if len(extra) == 0 {
+		return env
+	}
+	return append(append([]string{}, env...), extra...)
+}
+
+// containerLabels labels the container with the request id, if any.
+func containerLabels(ctx context.Context) map[string]string {
+	requestID := log.RequestID(ctx)
+	if requestID == "" {
+		return nil
+	}
+	return map[string]string{RequestIDLabel: requestID}
+}
@@ -3,11 +3,16 @@ package docker
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"strings"
 
+	"docker-operator/log"
+	"docker-operator/src/redact"
+	"docker-operator/src/registry"
+
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/pkg/stdcopy"
@@ -16,109 +21,292 @@ import (
 
 var NotFoundError = fmt.Errorf("image not found")
 var ContainerRunError = fmt.Errorf("error occured while running the image")
+var CancelledError = fmt.Errorf("execution cancelled")
+
+// DaemonError is a failed call to the docker daemon, rather than a failure of the image it runs.
+type DaemonError struct {
+	Err error
+}
+
+func (e *DaemonError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DaemonError) Unwrap() error {
+	return e.Err
+}
 
 type Service struct {
-	Client *Client
+	Client     *Client
+	Registries *registry.Registries
+	Reviewers  []ImageReviewer
+}
+
+// ImageReviewer decides whether a pulled image may be run, based on its inspected config.
+type ImageReviewer interface {
+	Review(image string, inspect types.ImageInspect) error
 }
 
 type Headers struct {
 	Header map[string]string
 }
 
+// CopyHeaders copies the headers of an output, so a decorator can add its own
+// without changing the ones kept by another.
+func CopyHeaders(headers map[string]string) map[string]string {
+	copied := make(map[string]string, len(headers)+2)
+	for name, value := range headers {
+		copied[name] = value
+	}
+	return copied
+}
+
 //go:generate mockgen -source=src/docker/service.go -package docker -destination src/docker/service_mock.go
 type ServiceInterface interface {
 	RunContainer(image string, params []string, ctx context.Context) ([]byte, *Headers, error)
 	RunContainerPost(image string, reqBody []string, ctx context.Context) ([]byte, *Headers, error)
 	ImageExists(image string, ctx context.Context) bool
+	ImageDigest(image string, ctx context.Context) (string, error)
+	PullImage(image string, ctx context.Context) error
 }
 
 func (s *Service) RunContainer(image string, params []string, ctx context.Context) ([]byte, *Headers, error) {
-	reader, err := s.Client.ImagePull(ctx, image, types.ImagePullOptions{})
-	if err != nil {
-		zap.S().Error(NotFoundError)
-		return nil, nil, fmt.Errorf("%w, %v", NotFoundError, err)
+	if err := s.prepareImage(image, ctx); err != nil {
+		return nil, nil, err
 	}
-	defer reader.Close()
-	io.Copy(ioutil.Discard, reader)
 	containerConfig := &container.Config{
-		Image: image,
-		Cmd:   params,
-		Tty:   false,
+		Image:  image,
+		Cmd:    params,
+		Env:    containerEnv(ctx, nil),
+		Labels: containerLabels(ctx),
+		Tty:    false,
 	}
 	return runImage(containerConfig, ctx, s)
 }
 
 func (s *Service) RunContainerPost(image string, reqBody []string, ctx context.Context) ([]byte, *Headers, error) {
-	reader, err := s.Client.ImagePull(ctx, image, types.ImagePullOptions{})
+	if err := s.prepareImage(image, ctx); err != nil {
+		return nil, nil, err
+	}
+	containerConfig := &container.Config{
+		Image:  image,
+		Env:    containerEnv(ctx, reqBody),
+		Labels: containerLabels(ctx),
+		Tty:    false,
+	}
+	return runImage(containerConfig, ctx, s)
+}
+
+// prepareImage pulls and reviews the image before it is run.
+func (s *Service) prepareImage(image string, ctx context.Context) error {
+	span := step(ctx, "pull", image)
+	err := s.pullImage(image, ctx)
+	span.End(err)
+	if err != nil {
+		return err
+	}
+	span = step(ctx, "review", image)
+	err = s.reviewImage(image, ctx)
+	span.End(err)
 	if err != nil {
-		zap.S().Error(NotFoundError)
-		return nil, nil, fmt.Errorf("%w, %v", NotFoundError, err)
+		return err
+	}
+	imageDigest(ctx, s, image)
+	return nil
+}
+
+// pullImage pulls the image according to the pull policy and credentials of
+// the registry it belongs to. Images of unknown registries are always pulled
+// without credentials.
+func (s *Service) pullImage(image string, ctx context.Context) error {
+	options, pullPolicy, err := s.pullOptions(image)
+	if err != nil {
+		return err
+	}
+	switch pullPolicy {
+	case registry.PullNever:
+		if !s.ImageExists(image, ctx) {
+			log.FromContext(ctx).Error(NotFoundError)
+			return fmt.Errorf("%w, %s is not available locally and its registry never pulls", NotFoundError, image)
+		}
+		imagePulled(ctx, false)
+		return nil
+	case registry.PullIfNotPresent:
+		if s.ImageExists(image, ctx) {
+			imagePulled(ctx, false)
+			return nil
+		}
+	}
+	return s.pull(image, options, ctx)
+}
+
+// PullImage pulls the image with the credentials of its registry, regardless of its pull policy.
+func (s *Service) PullImage(image string, ctx context.Context) error {
+	options, _, err := s.pullOptions(image)
+	if err != nil {
+		return err
+	}
+	return s.pull(image, options, ctx)
+}
+
+func (s *Service) pullOptions(image string) (types.ImagePullOptions, registry.PullPolicy, error) {
+	if s.Registries == nil {
+		return types.ImagePullOptions{}, registry.PullAlways, nil
+	}
+	reg, ok := s.Registries.ForReference(image)
+	if !ok {
+		return types.ImagePullOptions{}, registry.PullAlways, nil
+	}
+	auth, err := reg.RegistryAuth()
+	if err != nil {
+		zap.S().Error(err.Error())
+		return types.ImagePullOptions{}, "", err
+	}
+	return types.ImagePullOptions{RegistryAuth: auth}, reg.PullPolicy, nil
+}
+
+func (s *Service) pull(image string, options types.ImagePullOptions, ctx context.Context) error {
+	reader, err := s.Client.ImagePull(ctx, image, options)
+	if err != nil {
+		log.FromContext(ctx).Error(NotFoundError)
+		return fmt.Errorf("%w, %v", NotFoundError, err)
 	}
 	defer reader.Close()
+	imagePulled(ctx, downloaded(reader))
+	return nil
+}
+
+// downloaded reads the progress stream of a pull to the end and reports whether
+// the image was downloaded, rather than already up to date on the docker host.
+func downloaded(reader io.Reader) bool {
+	var found bool
+	decoder := json.NewDecoder(reader)
+	for {
+		var message struct {
+			Status string `json:"status"`
+		}
+		if err := decoder.Decode(&message); err != nil {
+			break
+		}
+		if strings.HasPrefix(message.Status, "Status: Downloaded newer image") {
+			found = true
+		}
+	}
+	// the pull goes on until the stream is read to the end
 	io.Copy(ioutil.Discard, reader)
-	containerConfig := &container.Config{
-		Image: image,
-		Env:   reqBody,
-		Tty:   false,
+	return found
+}
+
+// reviewImage runs the inspected image through every reviewer of the service.
+func (s *Service) reviewImage(image string, ctx context.Context) error {
+	if len(s.Reviewers) == 0 {
+		return nil
 	}
-	return runImage(containerConfig, ctx, s)
+	inspect, _, err := s.Client.ImageInspectWithRaw(ctx, image)
+	if err != nil {
+		errMessage := fmt.Errorf("could not inspect image %s: %w", image, err)
+		log.FromContext(ctx).Error(errMessage.Error())
+		return &DaemonError{Err: errMessage}
+	}
+	for _, reviewer := range s.Reviewers {
+		if err := reviewer.Review(image, inspect); err != nil {
+			return err
+		}
+	}
+	return nil
 }
+
 func runImage(config *container.Config, ctx context.Context, s *Service) ([]byte, *Headers, error) {
+	span := step(ctx, "create", config.Image)
 	resp, err := s.Client.ContainerCreate(ctx, config, nil, nil,nil, "")
+	span.End(err)
 	if err != nil {
 		errMessage := fmt.Errorf("could not create a new container for image %s because: %w", config.Image, err)
-		zap.S().Error(errMessage.Error())
-		return nil, nil, errMessage
+		log.FromContext(ctx).Error(errMessage.Error())
+		return nil, nil, &DaemonError{Err: errMessage}
 	}
+	containerCreated(ctx, resp.ID)
 
-	if err := s.Client.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+	span = step(ctx, "start", config.Image)
+	err = s.Client.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{})
+	span.End(err)
+	if err != nil {
 		errMessage := fmt.Errorf("could not start container with: %w", err)
-		zap.S().Error(errMessage.Error())
-		return nil, nil, errMessage
+		log.FromContext(ctx).Error(errMessage.Error())
+		forceRemove(ctx, s, resp.ID)
+		return nil, nil, &DaemonError{Err: errMessage}
 	}
 
+	span = step(ctx, "wait", config.Image)
 	statusCh, errCh := s.Client.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
 	select {
 	case err := <-errCh:
-		if err != nil {
+		if err != nil && ctx.Err() == nil {
 			errMessage := fmt.Errorf("cannot wait for container to complete with: %w", err)
-			zap.S().Error(errMessage.Error())
-			return nil, nil, errMessage
+			log.FromContext(ctx).Error(errMessage.Error())
+			span.End(errMessage)
+			return nil, nil, &DaemonError{Err: errMessage}
 		}
-	case <-statusCh:
+	case status := <-statusCh:
+		span.SetAttribute("container.exit_code", status.StatusCode)
+		containerExited(ctx, status.StatusCode)
+	}
+	span.End(ctx.Err())
+	if ctx.Err() != nil {
+		// the execution was cancelled, the container is gone or being killed
+		forceRemove(ctx, s, resp.ID)
+		log.FromContext(ctx).Warnw("execution cancelled", "container", resp.ID)
+		return nil, nil, fmt.Errorf("%w: %v", CancelledError, ctx.Err())
 	}
 
+	span = step(ctx, "logs", config.Image)
 	out, err := s.Client.ContainerLogs(ctx, resp.ID, types.ContainerLogsOptions{ShowStderr: true,
 		ShowStdout: true,
 		Follow:     true})
 	if err != nil {
+		span.End(err)
 		errMessage := fmt.Errorf("cannot get container logs with: %w", err)
-		zap.S().Error(errMessage.Error())
-		return nil, nil, errMessage
-	}
-
-	if err = s.Client.ContainerRemove(ctx, resp.ID, types.ContainerRemoveOptions{}); err != nil {
-		errMessage := fmt.Errorf("cannot stop containers: %w", err)
-		zap.S().Error(errMessage.Error())
-		return nil, nil, errMessage
+		log.FromContext(ctx).Error(errMessage.Error())
+		forceRemove(ctx, s, resp.ID)
+		return nil, nil, &DaemonError{Err: errMessage}
 	}
-
 	buffer := &bytes.Buffer{}
 	errorWriter := &bytes.Buffer{}
 	_, err = stdcopy.StdCopy(buffer, errorWriter, out)
+	out.Close()
+	span.End(err)
 	if err != nil {
 		errMessage := fmt.Errorf("cannot read logs from the container: %w", err)
-		zap.S().Error(errMessage.Error())
-		return nil, nil, errMessage
+		log.FromContext(ctx).Error(errMessage.Error())
+		forceRemove(ctx, s, resp.ID)
+		return nil, nil, &DaemonError{Err: errMessage}
 	}
+
+	span = step(ctx, "remove", config.Image)
+	err = s.Client.ContainerRemove(ctx, resp.ID, types.ContainerRemoveOptions{})
+	span.End(err)
+	if err != nil {
+		errMessage := fmt.Errorf("cannot stop containers: %w", err)
+		log.FromContext(ctx).Error(errMessage.Error())
+		return nil, nil, &DaemonError{Err: errMessage}
+	}
+	containerOutput(ctx, buffer.Bytes(), errorWriter.Bytes())
 	errorString := errorWriter.String()
 	if len(errorString) > 0 {
-		zap.L().Error(errorString)
+		log.FromContext(ctx).Error(redact.FromContext(ctx).String(errorString))
 		return nil, nil, ContainerRunError
 	}
 	return processContainerLogs(buffer.String())
 }
 
+// forceRemove removes a container the execution gave up on. The context of the
+// execution may be cancelled already, the removal does not depend on it.
+func forceRemove(ctx context.Context, s *Service, id string) {
+	if err := s.Client.ContainerRemove(context.Background(), id, types.ContainerRemoveOptions{Force: true}); err != nil {
+		log.FromContext(ctx).Errorw("cannot remove container", "container", id, "error", err)
+	}
+}
+
 func processContainerLogs(out string) ([]byte, *Headers, error) {
 	logsSplit := strings.SplitN(out, "\n\n", 2)
 	headerMap, hasContentType := processHeader(logsSplit[0])
@@ -154,10 +342,43 @@ func (s *Service) ImageExists(image string, ctx context.Context) bool {
 	return true
 }
 
+// ImageDigest returns the repository digest of a local image. Images that were
+// never pushed to their repository have no repository digest, their id is returned instead.
+func (s *Service) ImageDigest(image string, ctx context.Context) (string, error) {
+	inspect, _, err := s.Client.ImageInspectWithRaw(ctx, image)
+	if err != nil {
+		return "", err
+	}
+	if digest, ok := RepoDigest(image, inspect); ok {
+		return digest, nil
+	}
+	return inspect.ID, nil
+}
+
+// RepoDigest returns the digest of the inspected image in the repository of the
+// reference, or the digest the reference is pinned to. The digests of the image
+// in other repositories are ignored.
+func RepoDigest(image string, inspect types.ImageInspect) (string, bool) {
+	if i := strings.Index(image, "@"); i >= 0 {
+		return image[i+1:], true
+	}
+	repository := image
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		repository = image[:i]
+	}
+	for _, repoDigest := range inspect.RepoDigests {
+		if strings.HasPrefix(repoDigest, repository+"@") {
+			return strings.TrimPrefix(repoDigest, repository+"@"), true
+		}
+	}
+	return "", false
+}
 
-func NewService(clientInterface ClientInterface) ServiceInterface {
+func NewService(clientInterface ClientInterface, registries *registry.Registries, reviewers ...ImageReviewer) ServiceInterface {
 	return &Service{
-		Client: &Client{clientInterface},
+		Client:     &Client{clientInterface},
+		Registries: registries,
+		Reviewers:  reviewers,
 	}
 }
 
@@ -8,9 +8,47 @@ import (
 	context "context"
 	reflect "reflect"
 
+	types "github.com/docker/docker/api/types"
 	gomock "github.com/golang/mock/gomock"
 )
 
+// MockImageReviewer is a mock of ImageReviewer interface.
+type MockImageReviewer struct {
+	ctrl     *gomock.Controller
+	recorder *MockImageReviewerMockRecorder
+}
+
+// MockImageReviewerMockRecorder is the mock recorder for MockImageReviewer.
+type MockImageReviewerMockRecorder struct {
+	mock *MockImageReviewer
+}
+
+// NewMockImageReviewer creates a new mock instance.
+func NewMockImageReviewer(ctrl *gomock.Controller) *MockImageReviewer {
+	mock := &MockImageReviewer{ctrl: ctrl}
+	mock.recorder = &MockImageReviewerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockImageReviewer) EXPECT() *MockImageReviewerMockRecorder {
+	return m.recorder
+}
+
+// Review mocks base method.
+func (m *MockImageReviewer) Review(image string, inspect types.ImageInspect) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Review", image, inspect)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Review indicates an expected call of Review.
+func (mr *MockImageReviewerMockRecorder) Review(image, inspect interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Review", reflect.TypeOf((*MockImageReviewer)(nil).Review), image, inspect)
+}
+
 // MockServiceInterface is a mock of ServiceInterface interface.
 type MockServiceInterface struct {
 	ctrl     *gomock.Controller
@@ -34,6 +72,21 @@ func (m *MockServiceInterface) EXPECT() *MockServiceInterfaceMockRecorder {
 	return m.recorder
 }
 
+// ImageDigest mocks base method.
+func (m *MockServiceInterface) ImageDigest(image string, ctx context.Context) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ImageDigest", image, ctx)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ImageDigest indicates an expected call of ImageDigest.
+func (mr *MockServiceInterfaceMockRecorder) ImageDigest(image, ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImageDigest", reflect.TypeOf((*MockServiceInterface)(nil).ImageDigest), image, ctx)
+}
+
 // ImageExists mocks base method.
 func (m *MockServiceInterface) ImageExists(image string, ctx context.Context) bool {
 	m.ctrl.T.Helper()
@@ -48,6 +101,20 @@ func (mr *MockServiceInterfaceMockRecorder) ImageExists(image, ctx interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImageExists", reflect.TypeOf((*MockServiceInterface)(nil).ImageExists), image, ctx)
 }
 
+// PullImage mocks base method.
+func (m *MockServiceInterface) PullImage(image string, ctx context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PullImage", image, ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PullImage indicates an expected call of PullImage.
+func (mr *MockServiceInterfaceMockRecorder) PullImage(image, ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PullImage", reflect.TypeOf((*MockServiceInterface)(nil).PullImage), image, ctx)
+}
+
 // RunContainer mocks base method.
 func (m *MockServiceInterface) RunContainer(image string, params []string, ctx context.Context) ([]byte, *Headers, error) {
 	m.ctrl.T.Helper()
@@ -1,6 +1,7 @@
 package docker
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -9,9 +10,16 @@ import (
 	"strings"
 	"testing"
 
+	"docker-operator/log"
+	"docker-operator/src/registry"
+	"docker-operator/src/tracing"
+
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/strslice"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestService_RunContainer(t *testing.T) {
@@ -53,7 +61,7 @@ func TestService_RunContainer(t *testing.T) {
 			defer ctrl.Finish()
 			mockInterface := NewMockClientInterface(ctrl)
 			client := tt.mock(mockInterface, tt.image)
-			service := NewService(client)
+			service := NewService(client, nil)
 			_, _, err := service.RunContainer(tt.image, []string{"json"}, context.Background())
 			if err != nil {
 				if !reflect.DeepEqual(err.Error(), tt.err.Error()) {
@@ -65,6 +73,103 @@ func TestService_RunContainer(t *testing.T) {
 	}
 }
 
+func TestService_PullPolicy(t *testing.T) {
+	tests := []struct {
+		name       string
+		pullPolicy registry.PullPolicy
+		mock       func(mc *MockClientInterface, image string)
+		err        error
+	}{
+		{
+			name:       "never pull an image that is missing locally",
+			pullPolicy: registry.PullNever,
+			mock: func(mc *MockClientInterface, image string) {
+				mc.EXPECT().ImageInspectWithRaw(context.Background(), image).Return(types.ImageInspect{}, nil, fmt.Errorf("no such image"))
+			},
+			err: fmt.Errorf("image not found, registry.local/alpine:3.13 is not available locally and its registry never pulls"),
+		},
+		{
+			name:       "skip the pull when the image is present",
+			pullPolicy: registry.PullIfNotPresent,
+			mock: func(mc *MockClientInterface, image string) {
+				mc.EXPECT().ImageInspectWithRaw(context.Background(), image).Return(types.ImageInspect{}, nil, nil)
+				mc.EXPECT().ContainerCreate(context.Background(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+					Return(container.ContainerCreateCreatedBody{}, fmt.Errorf(`/bin/sh executable not found`))
+			},
+			err: fmt.Errorf(`could not create a new container for image registry.local/alpine:3.13 because: /bin/sh executable not found`),
+		},
+		{
+			name:       "pull with the registry credentials",
+			pullPolicy: registry.PullAlways,
+			mock: func(mc *MockClientInterface, image string) {
+				mc.EXPECT().ImagePull(context.Background(), image, gomock.Not(types.ImagePullOptions{})).Return(nil, fmt.Errorf("unauthorized"))
+			},
+			err: fmt.Errorf("image not found, unauthorized"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			image := "registry.local/alpine:3.13"
+			registries, err := registry.New(&registry.Registry{
+				Name:       "local",
+				Host:       "registry.local",
+				Username:   "operator",
+				Password:   "secret",
+				PullPolicy: tt.pullPolicy,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			mockInterface := NewMockClientInterface(ctrl)
+			tt.mock(mockInterface, image)
+			service := NewService(mockInterface, registries)
+			_, _, err = service.RunContainer(image, nil, context.Background())
+			if !reflect.DeepEqual(err.Error(), tt.err.Error()) {
+				t.Errorf("RunContainer() gotError = %v, want = %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestService_ReviewImage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	image := "alpine"
+	inspect := types.ImageInspect{ID: "sha256:1"}
+	mockInterface := NewMockClientInterface(ctrl)
+	mockInterface.EXPECT().ImagePull(context.Background(), image, types.ImagePullOptions{}).Return(stringToIOReader("pulled image successfully \n"), nil)
+	mockInterface.EXPECT().ImageInspectWithRaw(context.Background(), image).Return(inspect, nil, nil)
+	reviewer := NewMockImageReviewer(ctrl)
+	reviewer.EXPECT().Review(image, inspect).Return(fmt.Errorf("image runs as root"))
+
+	service := NewService(mockInterface, nil, reviewer)
+	_, _, err := service.RunContainerPost(image, nil, context.Background())
+	if err == nil || err.Error() != "image runs as root" {
+		t.Errorf("RunContainerPost() gotError = %v, want = image runs as root", err)
+	}
+}
+
+func TestService_ImageDigest(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockInterface := NewMockClientInterface(ctrl)
+	mockInterface.EXPECT().ImageInspectWithRaw(gomock.Any(), "registry.local/tool:1").
+		Return(types.ImageInspect{ID: "sha256:id", RepoDigests: []string{"mirror.local/tool@sha256:mirror", "registry.local/tool@sha256:local"}}, nil, nil)
+	mockInterface.EXPECT().ImageInspectWithRaw(gomock.Any(), "registry.local/copy:1").
+		Return(types.ImageInspect{ID: "sha256:id", RepoDigests: []string{"registry.local/tool@sha256:local"}}, nil, nil)
+
+	service := NewService(mockInterface, nil)
+	digest, err := service.ImageDigest("registry.local/tool:1", context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "sha256:local", digest)
+	// the digests of other repositories are never returned
+	digest, err = service.ImageDigest("registry.local/copy:1", context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "sha256:id", digest)
+}
+
 func TestProcessContainerLogs(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -155,3 +260,93 @@ Unusual-Header: 945
 func stringToIOReader(str string) io.ReadCloser {
 	return ioutil.NopCloser(strings.NewReader(str))
 }
+
+func TestService_TraceEnv(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mc := NewMockClientInterface(ctrl)
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	ctx := tracing.ContextWithTraceParent(context.Background(), traceparent)
+	mc.EXPECT().ImagePull(ctx, "alpine", types.ImagePullOptions{}).Return(stringToIOReader("pulled image successfully \n"), nil)
+	mc.EXPECT().ContainerCreate(ctx, gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, config *container.Config, _, _, _, _ interface{}) (container.ContainerCreateCreatedBody, error) {
+			assert.Equal(t, []string{"POST_DATA={}", "TRACEPARENT=" + traceparent}, config.Env)
+			return container.ContainerCreateCreatedBody{}, fmt.Errorf("stop here")
+		})
+	service := NewService(mc, nil)
+	_, _, err := service.RunContainerPost("alpine", []string{"POST_DATA={}"}, ctx)
+	assert.Error(t, err)
+}
+
+func TestService_RequestID(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mc := NewMockClientInterface(ctrl)
+	ctx := log.WithRequestID(context.Background(), "req-1")
+	mc.EXPECT().ImagePull(ctx, "alpine", types.ImagePullOptions{}).Return(stringToIOReader("pulled image successfully \n"), nil)
+	mc.EXPECT().ContainerCreate(ctx, gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, config *container.Config, _, _, _, _ interface{}) (container.ContainerCreateCreatedBody, error) {
+			assert.Equal(t, strslice.StrSlice{"a=b"}, config.Cmd)
+			assert.Equal(t, []string{"REQUEST_ID=req-1"}, config.Env)
+			assert.Equal(t, map[string]string{RequestIDLabel: "req-1"}, config.Labels)
+			return container.ContainerCreateCreatedBody{}, fmt.Errorf("stop here")
+		})
+	service := NewService(mc, nil)
+	_, _, err := service.RunContainer("alpine", []string{"a=b"}, ctx)
+	assert.Error(t, err)
+}
+
+func TestService_ContainerOutput(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mc := NewMockClientInterface(ctrl)
+	logs := &bytes.Buffer{}
+	stdcopy.NewStdWriter(logs, stdcopy.Stdout).Write([]byte("Content-Type: text/plain\n\nok"))
+	stdcopy.NewStdWriter(logs, stdcopy.Stderr).Write([]byte("warning"))
+	statusCh := make(chan container.ContainerWaitOKBody, 1)
+	statusCh <- container.ContainerWaitOKBody{StatusCode: 3}
+	mc.EXPECT().ImagePull(gomock.Any(), "alpine", types.ImagePullOptions{}).Return(stringToIOReader("pulled image successfully \n"), nil)
+	mc.EXPECT().ImageInspectWithRaw(gomock.Any(), "alpine").Return(types.ImageInspect{ID: "sha256:pulled"}, nil, nil)
+	mc.EXPECT().ContainerCreate(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(container.ContainerCreateCreatedBody{ID: "c1"}, nil)
+	mc.EXPECT().ContainerStart(gomock.Any(), "c1", types.ContainerStartOptions{}).Return(nil)
+	mc.EXPECT().ContainerWait(gomock.Any(), "c1", container.WaitConditionNotRunning).Return(statusCh, make(chan error))
+	mc.EXPECT().ContainerLogs(gomock.Any(), "c1", gomock.Any()).Return(ioutil.NopCloser(logs), nil)
+	mc.EXPECT().ContainerRemove(gomock.Any(), "c1", types.ContainerRemoveOptions{}).Return(nil)
+
+	var exitCode int64
+	var stdout, stderr, digest, outerDigest string
+	ctx := WithContainerExited(context.Background(), func(code int64) { exitCode = code })
+	ctx = WithContainerOutput(ctx, func(out, errOut []byte) { stdout, stderr = string(out), string(errOut) })
+	ctx = WithImageDigest(ctx, func(d string) { outerDigest = d })
+	ctx = WithImageDigest(ctx, func(d string) { digest = d })
+	service := NewService(mc, nil)
+	_, _, err := service.RunContainer("alpine", nil, ctx)
+	assert.Equal(t, ContainerRunError, err)
+	assert.Equal(t, "sha256:pulled", digest)
+	assert.Equal(t, "sha256:pulled", outerDigest)
+	assert.Equal(t, int64(3), exitCode)
+	assert.Equal(t, "Content-Type: text/plain\n\nok", stdout)
+	assert.Equal(t, "warning", stderr)
+}
+
+func TestService_StartFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mc := NewMockClientInterface(ctrl)
+	ctx, cancel := context.WithCancel(context.Background())
+	mc.EXPECT().ImagePull(gomock.Any(), "alpine", types.ImagePullOptions{}).Return(stringToIOReader("pulled image successfully \n"), nil)
+	mc.EXPECT().ContainerCreate(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(container.ContainerCreateCreatedBody{ID: "c1"}, nil)
+	mc.EXPECT().ContainerStart(gomock.Any(), "c1", types.ContainerStartOptions{}).
+		DoAndReturn(func(ctx context.Context, _ string, _ types.ContainerStartOptions) error {
+			// the execution is cancelled between the creation and the start of the container
+			cancel()
+			return ctx.Err()
+		})
+	mc.EXPECT().ContainerRemove(context.Background(), "c1", types.ContainerRemoveOptions{Force: true}).Return(nil)
+
+	service := NewService(mc, nil)
+	_, _, err := service.RunContainer("alpine", nil, ctx)
+	assert.Error(t, err)
+}
@@ -0,0 +1,14 @@
+package docker
+
+import (
+	"context"
+
+	"docker-operator/src/tracing"
+)
+
+// step starts the span of a step of an execution, a child of the span of the context.
+func step(ctx context.Context, name, image string) *tracing.Span {
+	_, span := tracing.Start(ctx, name, tracing.KindInternal)
+	span.SetAttribute("container.image.name", image)
+	return span
+}
@@ -0,0 +1,165 @@
+package execution
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"docker-operator/src/docker"
+
+	"go.uber.org/zap"
+)
+
+// NotFoundError is returned when cancelling an execution that is not running.
+var NotFoundError = fmt.Errorf("execution not found")
+
+// validID are the ids that can name an execution, they end up in URLs and file names.
+var validID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:-]{0,127}$`)
+
+// Execution is a container run in flight.
+type Execution struct {
+	ID          string    `json:"id"`
+	RequestID   string    `json:"request_id,omitempty"`
+	Image       string    `json:"image"`
+	Params      []string  `json:"params"`
+	Method      string    `json:"method"`
+	Client      string    `json:"client"`
+	StartTime   time.Time `json:"start_time"`
+	ContainerID string    `json:"container_id,omitempty"`
+	Cancelled   bool      `json:"cancelled"`
+
+	cancel context.CancelFunc
+}
+
+// Registry keeps the executions in flight so they can be listed and cancelled.
+type Registry struct {
+	client docker.ClientInterface
+
+	mu         sync.Mutex
+	executions map[string]*Execution
+}
+
+// NewRegistry builds a registry killing the containers of cancelled executions with the client.
+func NewRegistry(client docker.ClientInterface) *Registry {
+	return &Registry{
+		client:     client,
+		executions: make(map[string]*Execution),
+	}
+}
+
+// Start registers an execution under the given id, usually the id of the
+// request it runs for, along with that request id. A generated id is used
+// instead when the id is empty, invalid or names an execution in flight. The
+// returned context derives from ctx and has to be passed to the docker service,
+// it is cancelled when the execution is. The returned function removes the
+// execution from the registry once it is done.
+func (r *Registry) Start(ctx context.Context, id, requestID, image string, params []string, method, client string) (context.Context, *Execution, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	execution := &Execution{
+		RequestID: requestID,
+		Image:     image,
+		Params:    redactParams(params),
+		Method:    method,
+		Client:    client,
+		StartTime: time.Now(),
+		cancel:    cancel,
+	}
+	r.mu.Lock()
+	if _, ok := r.executions[id]; ok || !validID.MatchString(id) {
+		id = newID()
+	}
+	execution.ID = id
+	r.executions[execution.ID] = execution
+	r.mu.Unlock()
+
+	ctx = docker.WithExecutionID(ctx, execution.ID)
+	ctx = docker.WithContainerCreated(ctx, func(containerID string) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		execution.ContainerID = containerID
+	})
+
+	return ctx, execution, func() {
+		r.mu.Lock()
+		delete(r.executions, execution.ID)
+		r.mu.Unlock()
+		cancel()
+	}
+}
+
+// List returns the executions in flight, oldest first.
+func (r *Registry) List() []Execution {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	executions := make([]Execution, 0, len(r.executions))
+	for _, execution := range r.executions {
+		executions = append(executions, *execution)
+	}
+	sort.Slice(executions, func(i, j int) bool {
+		return executions[i].StartTime.Before(executions[j].StartTime)
+	})
+	return executions
+}
+
+// Cancel fails the execution and kills its container if it was already created.
+func (r *Registry) Cancel(id string) (Execution, error) {
+	r.mu.Lock()
+	execution, ok := r.executions[id]
+	if !ok {
+		r.mu.Unlock()
+		return Execution{}, NotFoundError
+	}
+	execution.Cancelled = true
+	containerID := execution.ContainerID
+	snapshot := *execution
+	r.mu.Unlock()
+
+	execution.cancel()
+	if containerID != "" {
+		if err := r.client.ContainerKill(context.Background(), containerID, "SIGKILL"); err != nil {
+			zap.S().Errorw("cannot kill container of cancelled execution", "execution", id, "container", containerID, "error", err)
+		}
+	}
+	zap.S().Warnw("execution cancelled", "execution", id, "image", snapshot.Image, "container", containerID)
+	return snapshot, nil
+}
+
+// IsCancelled reports whether the execution was cancelled.
+func (r *Registry) IsCancelled(execution *Execution) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return execution.Cancelled
+}
+
+// redactParams keeps the names of the params but hides their values.
+func redactParams(params []string) []string {
+	redacted := make([]string, 0, len(params))
+	for _, param := range params {
+		if strings.HasPrefix(param, "POST_DATA=") {
+			redacted = append(redacted, fmt.Sprintf("POST_DATA=<%d bytes>", len(param)-len("POST_DATA=")))
+			continue
+		}
+		pairs := strings.Split(param, "&")
+		for i, pair := range pairs {
+			if j := strings.Index(pair, "="); j >= 0 {
+				pairs[i] = pair[:j+1] + "***"
+			}
+		}
+		redacted = append(redacted, strings.Join(pairs, "&"))
+	}
+	return redacted
+}
+
+func newID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,72 @@
+package execution
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"docker-operator/src/docker"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry_Cancel(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	executions := NewRegistry(docker.NewMockClientInterface(ctrl))
+
+	ctx, execution, done := executions.Start(context.Background(), "req-1", "req-1", "registry.local/tool:1", []string{"name=joe&token=secret"}, "GET", "10.0.0.1")
+	assert.Equal(t, "req-1", execution.ID)
+	assert.Equal(t, "req-1", execution.RequestID)
+	assert.Equal(t, execution.ID, docker.ExecutionID(ctx))
+	// the id of an execution in flight is not given twice
+	_, duplicate, doneDuplicate := executions.Start(context.Background(), "req-1", "req-1", "registry.local/tool:1", nil, "GET", "10.0.0.1")
+	assert.Len(t, duplicate.ID, 32)
+	assert.Equal(t, "req-1", duplicate.RequestID)
+	doneDuplicate()
+	for _, id := range []string{"", "..", "../etc", strings.Repeat("a", 129)} {
+		_, invalid, doneInvalid := executions.Start(context.Background(), id, id, "registry.local/tool:1", nil, "GET", "10.0.0.1")
+		assert.Len(t, invalid.ID, 32, id)
+		doneInvalid()
+	}
+	listed := executions.List()
+	assert.Len(t, listed, 1)
+	assert.Equal(t, execution.ID, listed[0].ID)
+	assert.Equal(t, []string{"name=***&token=***"}, listed[0].Params)
+
+	cancelled, err := executions.Cancel(execution.ID)
+	assert.NoError(t, err)
+	assert.True(t, cancelled.Cancelled)
+	assert.True(t, executions.IsCancelled(execution))
+	assert.Error(t, ctx.Err())
+
+	done()
+	assert.Empty(t, executions.List())
+	_, err = executions.Cancel(execution.ID)
+	assert.Equal(t, NotFoundError, err)
+}
+
+func TestRedactParams(t *testing.T) {
+	tests := []struct {
+		description string
+		params      []string
+		want        []string
+	}{
+		{
+			description: "hide the values of a query",
+			params:      []string{"a=1&b=2&flag"},
+			want:        []string{"a=***&b=***&flag"},
+		},
+		{
+			description: "only keep the size of a request body",
+			params:      []string{`POST_DATA={"password":"x"}`},
+			want:        []string{"POST_DATA=<16 bytes>"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			assert.Equal(t, test.want, redactParams(test.params))
+		})
+	}
+}
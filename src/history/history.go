@@ -0,0 +1,382 @@
+package history
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"docker-operator/config"
+
+	bolt "go.etcd.io/bbolt"
+	"go.uber.org/zap"
+)
+
+// Statuses of a finished execution.
+const (
+	StatusSuccess   = "success"
+	StatusError     = "error"
+	StatusCancelled = "cancelled"
+)
+
+// DefaultLimit and MaxLimit bound the number of records of a page.
+const (
+	DefaultLimit = 50
+	MaxLimit     = 500
+)
+
+// DefaultMaxRecords caps the store when no cap is configured.
+const DefaultMaxRecords = 100000
+
+// Config holds where the records are stored and for how long.
+type Config struct {
+	Path string
+	// Retention is how long the records are kept. A zero retention keeps them until the cap is reached.
+	Retention time.Duration
+	// MaxRecords caps the store, the oldest records are removed first.
+	MaxRecords int
+	// ReplayInputs keeps the query or body of the executions, in plaintext, so
+	// they can be replayed. Only their hash is kept otherwise.
+	ReplayInputs bool
+}
+
+// LoadConfig reads the history settings from the environment.
+func LoadConfig() Config {
+	maxRecords := config.DefaultConfig.GetInt("HISTORY_MAX_RECORDS")
+	if maxRecords <= 0 {
+		maxRecords = DefaultMaxRecords
+	}
+	return Config{
+		Path:         config.DefaultConfig.GetString("HISTORY_FILE"),
+		Retention:    config.DefaultConfig.GetDuration("HISTORY_RETENTION"),
+		MaxRecords:   maxRecords,
+		ReplayInputs: config.DefaultConfig.GetBool("HISTORY_REPLAY_INPUTS"),
+	}
+}
+
+// Enabled reports whether a file is set.
+func (c Config) Enabled() bool {
+	return c.Path != ""
+}
+
+// Record is a finished execution. Its params, the query or body the image was
+// run with, are kept to replay it when the store keeps the replay inputs.
+// ReplayOf is the execution it replayed, if any. CoalescedWith is the execution
+// whose run it shared, which holds the exit code and logs of the container.
+// Stale is set when the execution failed and was answered with an earlier output.
+type Record struct {
+	ID            string    `json:"id"`
+	RequestID     string    `json:"request_id,omitempty"`
+	Time          time.Time `json:"time"`
+	Registry      string    `json:"registry"`
+	Image         string    `json:"image"`
+	Tag           string    `json:"tag,omitempty"`
+	Digest        string    `json:"digest,omitempty"`
+	Method        string    `json:"method"`
+	Params        []string  `json:"params,omitempty"`
+	ParamsHash    string    `json:"params_hash"`
+	ReplayOf      string    `json:"replay_of,omitempty"`
+	CoalescedWith string    `json:"coalesced_with,omitempty"`
+	Caller        string    `json:"caller"`
+	Status        string    `json:"status"`
+	Stale         bool      `json:"stale,omitempty"`
+	ExitCode      *int64    `json:"exit_code,omitempty"`
+	DurationMs    int64     `json:"duration_ms"`
+	OutputHash    string    `json:"output_hash,omitempty"`
+	OutputSize    int       `json:"output_size"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// Query selects records, the zero value of a field matches every record.
+type Query struct {
+	Image  string
+	Status string
+	Caller string
+	// Since and Until bound the start time of the executions, Until excluded.
+	Since  time.Time
+	Until  time.Time
+	Offset int
+	Limit  int
+}
+
+func (q Query) matches(record Record) bool {
+	return (q.Image == "" || q.Image == record.Image) &&
+		(q.Status == "" || q.Status == record.Status) &&
+		(q.Caller == "" || q.Caller == record.Caller) &&
+		(q.Since.IsZero() || !record.Time.Before(q.Since)) &&
+		(q.Until.IsZero() || record.Time.Before(q.Until))
+}
+
+// Page is a page of the records matching a query, the last started first.
+type Page struct {
+	Records []Record `json:"records"`
+	Total   int      `json:"total"`
+	Offset  int      `json:"offset"`
+	Limit   int      `json:"limit"`
+}
+
+// Buckets of the database. Records are stored by id and indexed by their start
+// time, and by image, caller and status in a nested bucket per value. The keys
+// of the indexes are the start time of the record followed by a sequence number.
+var (
+	recordsBucket = []byte("records")
+	keysBucket    = []byte("keys")
+	timeBucket    = []byte("time")
+	indexes       = []index{
+		{[]byte("image"), func(r Record) string { return r.Image }, func(q Query) string { return q.Image }},
+		{[]byte("caller"), func(r Record) string { return r.Caller }, func(q Query) string { return q.Caller }},
+		{[]byte("status"), func(r Record) string { return r.Status }, func(q Query) string { return q.Status }},
+	}
+)
+
+type index struct {
+	bucket []byte
+	record func(Record) string
+	query  func(Query) string
+}
+
+// Store keeps the records in a bbolt database, which is safe from torn writes,
+// and removes them past their retention or the cap of the store.
+type Store struct {
+	db     *bolt.DB
+	config Config
+	now    func() time.Time
+	// count is the number of records, only changed in write transactions.
+	count int
+}
+
+// Open opens the database of the store, creating it when missing, and removes
+// the records past their retention.
+func Open(cfg Config) (*Store, error) {
+	db, err := bolt.Open(cfg.Path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("could not open execution history %s: %w", cfg.Path, err)
+	}
+	s := &Store{db: db, config: cfg, now: time.Now}
+	err = db.Update(func(tx *bolt.Tx) error {
+		names := [][]byte{recordsBucket, keysBucket, timeBucket}
+		for _, index := range indexes {
+			names = append(names, index.bucket)
+		}
+		for _, name := range names {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		s.count = tx.Bucket(recordsBucket).Stats().KeyN
+		return s.prune(tx)
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not open execution history %s: %w", cfg.Path, err)
+	}
+	return s, nil
+}
+
+// Add stores the record, replacing the record of the same execution if any.
+// Its params are dropped unless the store keeps the replay inputs.
+func (s *Store) Add(record Record) error {
+	record.Time = record.Time.UTC()
+	if !s.config.ReplayInputs {
+		record.Params = nil
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		if err := s.remove(tx, []byte(record.ID)); err != nil {
+			return err
+		}
+		seq, err := tx.Bucket(timeBucket).NextSequence()
+		if err != nil {
+			return err
+		}
+		id := []byte(record.ID)
+		key := indexKey(record.Time, seq)
+		if err := tx.Bucket(recordsBucket).Put(id, data); err != nil {
+			return err
+		}
+		if err := tx.Bucket(keysBucket).Put(id, key); err != nil {
+			return err
+		}
+		if err := tx.Bucket(timeBucket).Put(key, id); err != nil {
+			return err
+		}
+		for _, index := range indexes {
+			value := index.record(record)
+			if value == "" {
+				continue
+			}
+			bucket, err := tx.Bucket(index.bucket).CreateBucketIfNotExists([]byte(value))
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(key, id); err != nil {
+				return err
+			}
+		}
+		s.count++
+		return s.prune(tx)
+	})
+	if err != nil {
+		// the transaction was rolled back along with the records it counted
+		s.db.Update(func(tx *bolt.Tx) error {
+			s.count = tx.Bucket(recordsBucket).Stats().KeyN
+			return nil
+		})
+		return fmt.Errorf("could not write execution history: %w", err)
+	}
+	return nil
+}
+
+// ReplayInputs reports whether the params of the executions are kept to replay them.
+func (s *Store) ReplayInputs() bool {
+	return s.config.ReplayInputs
+}
+
+// Get returns the record of an execution.
+func (s *Store) Get(id string) (Record, bool) {
+	var record Record
+	var found bool
+	s.db.View(func(tx *bolt.Tx) error {
+		record, found = decode(tx.Bucket(recordsBucket).Get([]byte(id)))
+		return nil
+	})
+	return record, found
+}
+
+// Find returns a page of the records matching the query, the last started first.
+// The records are read from the index of the image, caller or status when the
+// query filters on one, within the time range of the query.
+func (s *Store) Find(query Query) Page {
+	if query.Limit <= 0 {
+		query.Limit = DefaultLimit
+	}
+	if query.Limit > MaxLimit {
+		query.Limit = MaxLimit
+	}
+	page := Page{Records: []Record{}, Offset: query.Offset, Limit: query.Limit}
+	s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(timeBucket)
+		for _, index := range indexes {
+			if value := index.query(query); value != "" {
+				bucket = tx.Bucket(index.bucket).Bucket([]byte(value))
+				break
+			}
+		}
+		if bucket == nil {
+			return nil
+		}
+		records := tx.Bucket(recordsBucket)
+		cursor := bucket.Cursor()
+		k, v := cursor.Last()
+		if !query.Until.IsZero() {
+			// the first key at or past the end of the range, which is excluded
+			if k, _ = cursor.Seek(indexKey(query.Until, 0)); k == nil {
+				k, v = cursor.Last()
+			} else {
+				k, v = cursor.Prev()
+			}
+		}
+		for ; k != nil; k, v = cursor.Prev() {
+			if !query.Since.IsZero() && keyTime(k).Before(query.Since) {
+				break
+			}
+			record, ok := decode(records.Get(v))
+			if !ok || !query.matches(record) {
+				continue
+			}
+			if page.Total >= query.Offset && len(page.Records) < query.Limit {
+				page.Records = append(page.Records, record)
+			}
+			page.Total++
+		}
+		return nil
+	})
+	return page
+}
+
+// Close closes the database of the store.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// prune removes the oldest records while they are past their retention or the
+// store is over its cap.
+func (s *Store) prune(tx *bolt.Tx) error {
+	var expired [][]byte
+	cursor := tx.Bucket(timeBucket).Cursor()
+	for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+		over := s.config.MaxRecords > 0 && s.count-len(expired) > s.config.MaxRecords
+		old := s.config.Retention > 0 && keyTime(k).Before(s.now().Add(-s.config.Retention))
+		if !over && !old {
+			break
+		}
+		expired = append(expired, append([]byte{}, v...))
+	}
+	for _, id := range expired {
+		if err := s.remove(tx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remove deletes a record and its index entries, if it is stored.
+func (s *Store) remove(tx *bolt.Tx, id []byte) error {
+	key := tx.Bucket(keysBucket).Get(id)
+	if key == nil {
+		return nil
+	}
+	key = append([]byte{}, key...)
+	if record, ok := decode(tx.Bucket(recordsBucket).Get(id)); ok {
+		for _, index := range indexes {
+			if bucket := tx.Bucket(index.bucket).Bucket([]byte(index.record(record))); bucket != nil {
+				if err := bucket.Delete(key); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if err := tx.Bucket(timeBucket).Delete(key); err != nil {
+		return err
+	}
+	if err := tx.Bucket(keysBucket).Delete(id); err != nil {
+		return err
+	}
+	if err := tx.Bucket(recordsBucket).Delete(id); err != nil {
+		return err
+	}
+	s.count--
+	return nil
+}
+
+// decode reads a stored record. Undecodable records are skipped, their index
+// entries lead nowhere.
+func decode(data []byte) (Record, bool) {
+	if data == nil {
+		return Record{}, false
+	}
+	var record Record
+	if err := json.Unmarshal(data, &record); err != nil {
+		zap.S().Errorw("could not read execution history record", "error", err)
+		return Record{}, false
+	}
+	return record, true
+}
+
+func indexKey(t time.Time, seq uint64) []byte {
+	key := make([]byte, 16)
+	nanos := t.UnixNano()
+	if nanos < 0 {
+		nanos = 0
+	}
+	binary.BigEndian.PutUint64(key, uint64(nanos))
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
+
+func keyTime(key []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(key))).UTC()
+}
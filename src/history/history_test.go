@@ -0,0 +1,98 @@
+package history
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore_Find(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	store, err := Open(Config{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	exitCode := int64(0)
+	assert.NoError(t, store.Add(Record{ID: "1", Time: start, Image: "alpine", Caller: "ci", Status: StatusSuccess, ExitCode: &exitCode}))
+	assert.NoError(t, store.Add(Record{ID: "2", Time: start.Add(time.Hour), Image: "tools/report", Caller: "ci", Status: StatusError}))
+	assert.NoError(t, store.Close())
+
+	// reopening keeps the records
+	store, err = Open(Config{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	assert.NoError(t, store.Add(Record{ID: "3", Time: start.Add(2 * time.Hour), Image: "alpine", Caller: "alice", Status: StatusSuccess}))
+
+	ids := func(page Page) []string {
+		ids := []string{}
+		for _, record := range page.Records {
+			ids = append(ids, record.ID)
+		}
+		return ids
+	}
+	tests := []struct {
+		description string
+		query       Query
+		wantIDs     []string
+		wantTotal   int
+	}{
+		{"return every record, the last first", Query{}, []string{"3", "2", "1"}, 3},
+		{"filter by image", Query{Image: "alpine"}, []string{"3", "1"}, 2},
+		{"filter by status", Query{Status: StatusError}, []string{"2"}, 1},
+		{"filter by caller", Query{Caller: "ci"}, []string{"2", "1"}, 2},
+		{"filter by time range", Query{Since: start.Add(time.Hour), Until: start.Add(2 * time.Hour)}, []string{"2"}, 1},
+		{"paginate", Query{Offset: 1, Limit: 1}, []string{"2"}, 3},
+		{"return an empty page past the end", Query{Offset: 5}, []string{}, 3},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			page := store.Find(test.query)
+			assert.Equal(t, test.wantIDs, ids(page))
+			assert.Equal(t, test.wantTotal, page.Total)
+		})
+	}
+
+	record, ok := store.Get("1")
+	assert.True(t, ok)
+	assert.Equal(t, &exitCode, record.ExitCode)
+	_, ok = store.Get("4")
+	assert.False(t, ok)
+}
+
+func TestStore_Prune(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	store, err := Open(Config{Path: path, MaxRecords: 2, Retention: 24 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2021, 6, 2, 0, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+	assert.NoError(t, store.Add(Record{ID: "expired", Time: now.Add(-25 * time.Hour), Image: "alpine", Caller: "ci", Status: StatusSuccess}))
+	assert.Equal(t, 0, store.Find(Query{}).Total)
+	for _, id := range []string{"1", "2", "3"} {
+		assert.NoError(t, store.Add(Record{ID: id, Time: now, Image: "alpine", Caller: "ci", Status: StatusSuccess}))
+	}
+	_, ok := store.Get("1")
+	assert.False(t, ok, "the oldest record is removed past the cap")
+	page := store.Find(Query{Image: "alpine", Caller: "ci"})
+	assert.Equal(t, 2, page.Total)
+
+	// replacing a record keeps a single entry in every index
+	assert.NoError(t, store.Add(Record{ID: "3", Time: now, Image: "tools/report", Caller: "ci", Status: StatusError}))
+	assert.Equal(t, 1, store.Find(Query{Image: "alpine"}).Total)
+	assert.Equal(t, 1, store.Find(Query{Status: StatusError}).Total)
+	assert.NoError(t, store.Close())
+
+	// records expire while the store is closed
+	store, err = Open(Config{Path: path, Retention: time.Nanosecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	assert.Equal(t, 0, store.Find(Query{}).Total)
+}
@@ -0,0 +1,158 @@
+package idempotency
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"docker-operator/config"
+)
+
+// Config holds how long and how many results are kept.
+type Config struct {
+	// Window is how long the result of the first request with a key is kept.
+	Window time.Duration
+	// MaxEntries caps the kept results, the oldest are dropped first. A zero cap is not enforced.
+	// The keys of the requests in progress are not dropped.
+	MaxEntries int
+}
+
+// LoadConfig reads the idempotency settings from the environment.
+func LoadConfig() Config {
+	return Config{
+		Window:     config.DefaultConfig.GetDuration("IDEMPOTENCY_WINDOW"),
+		MaxEntries: config.DefaultConfig.GetInt("IDEMPOTENCY_MAX_ENTRIES"),
+	}
+}
+
+// Enabled reports whether a window is set.
+func (c Config) Enabled() bool {
+	return c.Window > 0
+}
+
+// State of a key when a request carrying it begins.
+type State int
+
+const (
+	// Reserved keys were unused, the request must finish or abort.
+	Reserved State = iota
+	// Done keys hold the result of the first request.
+	Done
+	// InProgress keys are still reserved for the first request.
+	InProgress
+	// Mismatch keys were first used for another request.
+	Mismatch
+)
+
+// Result is the stored response of the first request with a key.
+type Result struct {
+	Status  int
+	Headers map[string]string
+	Body    []byte
+}
+
+type entry struct {
+	key         string
+	fingerprint string
+	done        bool
+	result      Result
+	expires     time.Time
+}
+
+// Store keeps the results of the requests with an idempotency key in memory.
+type Store struct {
+	config Config
+	now    func() time.Time
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+// New builds a store.
+func New(cfg Config) *Store {
+	return &Store{
+		config:  cfg,
+		now:     time.Now,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Begin looks the key up for a request identified by its fingerprint. A new
+// key is reserved until the request finishes or aborts, the stored result is
+// returned for a done key.
+func (s *Store) Begin(key, fingerprint string) (State, Result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.expire(now)
+	if element, ok := s.entries[key]; ok {
+		e := element.Value.(*entry)
+		switch {
+		case e.fingerprint != fingerprint:
+			return Mismatch, Result{}
+		case !e.done:
+			return InProgress, Result{}
+		default:
+			return Done, e.result
+		}
+	}
+	s.entries[key] = s.order.PushFront(&entry{key: key, fingerprint: fingerprint, expires: now.Add(s.config.Window)})
+	s.evict()
+	return Reserved, Result{}
+}
+
+// Finish stores the result of the request that reserved the key for the window.
+func (s *Store) Finish(key string, result Result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if element, ok := s.entries[key]; ok {
+		e := element.Value.(*entry)
+		e.done = true
+		e.result = result
+		e.expires = s.now().Add(s.config.Window)
+		// the done entries are kept in the order they expire
+		s.order.MoveToFront(element)
+	}
+}
+
+// Abort releases the key without a result, so the request can be retried.
+func (s *Store) Abort(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if element, ok := s.entries[key]; ok && !element.Value.(*entry).done {
+		s.remove(element)
+	}
+}
+
+// expire drops the done entries past their window, the oldest first.
+func (s *Store) expire(now time.Time) {
+	for element := s.order.Back(); element != nil; {
+		prev := element.Prev()
+		e := element.Value.(*entry)
+		if e.done {
+			if !now.After(e.expires) {
+				return
+			}
+			s.remove(element)
+		}
+		element = prev
+	}
+}
+
+// evict drops the oldest done entries past the cap. The keys reserved by the
+// requests in progress are kept, so a repeat cannot run while they do.
+func (s *Store) evict() {
+	for element := s.order.Back(); element != nil && s.config.MaxEntries > 0 && s.order.Len() > s.config.MaxEntries; {
+		prev := element.Prev()
+		if element.Value.(*entry).done {
+			s.remove(element)
+		}
+		element = prev
+	}
+}
+
+func (s *Store) remove(element *list.Element) {
+	delete(s.entries, s.order.Remove(element).(*entry).key)
+}
@@ -0,0 +1,64 @@
+package idempotency
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(t *testing.T) {
+	store := New(Config{Window: time.Minute})
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+	result := Result{Status: 200, Headers: map[string]string{"Content-Type": "text/plain"}, Body: []byte("ok")}
+
+	state, _ := store.Begin("a", "body-1")
+	assert.Equal(t, Reserved, state)
+	state, _ = store.Begin("a", "body-1")
+	assert.Equal(t, InProgress, state)
+	store.Finish("a", result)
+	state, stored := store.Begin("a", "body-1")
+	assert.Equal(t, Done, state)
+	assert.Equal(t, result, stored)
+	state, _ = store.Begin("a", "body-2")
+	assert.Equal(t, Mismatch, state)
+
+	// aborted keys can be used again
+	state, _ = store.Begin("b", "body-1")
+	assert.Equal(t, Reserved, state)
+	store.Abort("b")
+	state, _ = store.Begin("b", "body-2")
+	assert.Equal(t, Reserved, state)
+
+	// results are kept for the window
+	now = now.Add(2 * time.Minute)
+	state, _ = store.Begin("a", "body-2")
+	assert.Equal(t, Reserved, state)
+	state, _ = store.Begin("b", "body-2")
+	assert.Equal(t, InProgress, state)
+}
+
+func TestStore_MaxEntries(t *testing.T) {
+	store := New(Config{Window: time.Minute, MaxEntries: 2})
+	for _, key := range []string{"a", "b", "c"} {
+		store.Begin(key, "body")
+		store.Finish(key, Result{Status: 200})
+	}
+	state, _ := store.Begin("a", "other")
+	assert.Equal(t, Reserved, state, "oldest result is dropped")
+	state, _ = store.Begin("c", "other")
+	assert.Equal(t, Mismatch, state)
+}
+
+func TestStore_MaxEntriesInProgress(t *testing.T) {
+	store := New(Config{Window: time.Minute, MaxEntries: 2})
+	store.Begin("a", "body")
+	store.Begin("b", "body")
+	store.Finish("b", Result{Status: 200})
+	store.Begin("c", "body")
+	state, _ := store.Begin("a", "body")
+	assert.Equal(t, InProgress, state, "key in progress is kept")
+	state, _ = store.Begin("b", "other")
+	assert.Equal(t, Reserved, state, "oldest result is dropped")
+}
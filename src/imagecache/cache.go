@@ -0,0 +1,327 @@
+package imagecache
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"docker-operator/config"
+	"docker-operator/src/docker"
+
+	"github.com/docker/docker/api/types"
+	"go.uber.org/zap"
+)
+
+// Entry is an image the operator pulled or ran.
+type Entry struct {
+	Image    string    `json:"image"`
+	ID       string    `json:"id"`
+	Digest   string    `json:"digest,omitempty"`
+	Size     int64     `json:"size"`
+	LastUsed time.Time `json:"last_used"`
+}
+
+// Config holds the budget of the image cache. A zero limit is not enforced.
+type Config struct {
+	MaxImages int
+	MaxSize   int64
+	// Pinned are globs of image references that are never evicted.
+	Pinned []string
+	// StateFile keeps the last use of the images across restarts when set.
+	StateFile string
+	// Interval between two budget checks, besides the checks after every use.
+	Interval time.Duration
+}
+
+// LoadConfig reads the cache budget from the environment.
+func LoadConfig() Config {
+	interval := config.DefaultConfig.GetDuration("IMAGE_CACHE_INTERVAL")
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return Config{
+		MaxImages: config.DefaultConfig.GetInt("IMAGE_CACHE_MAX_IMAGES"),
+		MaxSize:   int64(config.DefaultConfig.GetSizeInBytes("IMAGE_CACHE_MAX_SIZE")),
+		Pinned:    config.DefaultConfig.GetStringSlice("IMAGE_CACHE_PINNED"),
+		StateFile: config.DefaultConfig.GetString("IMAGE_CACHE_STATE_FILE"),
+		Interval:  interval,
+	}
+}
+
+// Enabled reports whether any budget is set.
+func (c Config) Enabled() bool {
+	return c.MaxImages > 0 || c.MaxSize > 0
+}
+
+// Cache tracks the last use of the images the operator manages and removes the
+// least recently used ones when the number or total size of images passes the budget.
+type Cache struct {
+	client docker.ClientInterface
+	config Config
+
+	mu      sync.Mutex
+	entries map[string]*Entry
+	inUse   map[string]int
+	// evicting holds the images being removed, Acquire waits on removed for them.
+	evicting map[string]bool
+	removed  *sync.Cond
+	pinned   []string
+	trigger  chan struct{}
+}
+
+// New builds a cache and restores the last use of the images from the state file.
+func New(client docker.ClientInterface, cfg Config) (*Cache, error) {
+	c := &Cache{
+		client:   client,
+		config:   cfg,
+		entries:  make(map[string]*Entry),
+		inUse:    make(map[string]int),
+		evicting: make(map[string]bool),
+		pinned:   cfg.Pinned,
+		trigger:  make(chan struct{}, 1),
+	}
+	c.removed = sync.NewCond(&c.mu)
+	if cfg.StateFile == "" {
+		return c, nil
+	}
+	data, err := ioutil.ReadFile(cfg.StateFile)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []*Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		c.entries[entry.Image] = entry
+	}
+	return c, nil
+}
+
+// Pin protects images matching the given globs from eviction.
+func (c *Cache) Pin(patterns ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pinned = append(c.pinned, patterns...)
+}
+
+// Acquire marks an image as used by a running execution, which protects it from eviction.
+// An image being evicted is acquired once it is removed, the execution pulls it again.
+func (c *Cache) Acquire(image string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.evicting[image] {
+		c.removed.Wait()
+	}
+	c.inUse[image]++
+}
+
+// Release ends a use of the image started with Acquire and records it.
+func (c *Cache) Release(image string, ctx context.Context) {
+	c.mu.Lock()
+	c.inUse[image]--
+	if c.inUse[image] <= 0 {
+		delete(c.inUse, image)
+	}
+	c.mu.Unlock()
+	c.Touch(image, ctx)
+}
+
+// Touch records a use of the image and checks the budget.
+func (c *Cache) Touch(image string, ctx context.Context) {
+	inspect, _, err := c.client.ImageInspectWithRaw(ctx, image)
+	if err != nil {
+		// the image never made it to the docker host
+		return
+	}
+	entry := &Entry{
+		Image:    image,
+		ID:       inspect.ID,
+		Size:     inspect.Size,
+		LastUsed: time.Now(),
+	}
+	// the image may have been pulled from other repositories too
+	entry.Digest, _ = docker.RepoDigest(image, inspect)
+	c.mu.Lock()
+	c.entries[image] = entry
+	c.mu.Unlock()
+
+	select {
+	case c.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Entries returns every tracked image, most recently used first.
+func (c *Cache) Entries() []Entry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entries := make([]Entry, 0, len(c.entries))
+	for _, entry := range c.entries {
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastUsed.After(entries[j].LastUsed)
+	})
+	return entries
+}
+
+// Start checks the budget on every use and on the configured interval until the context is done.
+func (c *Cache) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(c.config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-c.trigger:
+			}
+			c.Evict(ctx)
+			c.save()
+		}
+	}()
+}
+
+// Evict removes the least recently used images until the cache is within its budget.
+// Pinned images and images of running executions are never removed.
+func (c *Cache) Evict(ctx context.Context) []string {
+	var evicted []string
+	for _, entry := range c.candidates() {
+		if c.withinBudget() {
+			break
+		}
+		if !c.startEviction(entry.Image) {
+			continue
+		}
+		_, err := c.client.ImageRemove(ctx, entry.Image, types.ImageRemoveOptions{PruneChildren: true})
+		c.endEviction(entry.Image, err == nil)
+		if err != nil {
+			zap.S().Errorw("could not evict image", "image", entry.Image, "error", err)
+			continue
+		}
+		evicted = append(evicted, entry.Image)
+		zap.S().Infow("evicted image", "image", entry.Image, "size", entry.Size, "last_used", entry.LastUsed)
+	}
+	return evicted
+}
+
+// Forget stops tracking an image, e.g. after it was removed from the docker host.
+func (c *Cache) Forget(image string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, image)
+}
+
+// startEviction marks the image as being evicted unless an execution acquired
+// it or it was pinned since the candidates were listed.
+func (c *Cache) startEviction(image string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.inUse[image] > 0 || c.isPinned(image) {
+		return false
+	}
+	c.evicting[image] = true
+	return true
+}
+
+// endEviction forgets the image when it was removed and wakes up the executions waiting for it.
+func (c *Cache) endEviction(image string, removed bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.evicting, image)
+	if removed {
+		delete(c.entries, image)
+	}
+	c.removed.Broadcast()
+}
+
+// candidates returns the images that may be evicted, least recently used first.
+func (c *Cache) candidates() []Entry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var candidates []Entry
+	for _, entry := range c.entries {
+		if c.inUse[entry.Image] > 0 || c.isPinned(entry.Image) {
+			continue
+		}
+		candidates = append(candidates, *entry)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].LastUsed.Before(candidates[j].LastUsed)
+	})
+	return candidates
+}
+
+// withinBudget compares the tracked images to the budget. Images sharing an id
+// are only counted once towards the size.
+func (c *Cache) withinBudget() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.config.MaxImages > 0 && len(c.entries) > c.config.MaxImages {
+		return false
+	}
+	if c.config.MaxSize > 0 {
+		var size int64
+		seen := make(map[string]bool, len(c.entries))
+		for _, entry := range c.entries {
+			if !seen[entry.ID] {
+				seen[entry.ID] = true
+				size += entry.Size
+			}
+		}
+		if size > c.config.MaxSize {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Cache) isPinned(image string) bool {
+	for _, pattern := range c.pinned {
+		if matched, err := path.Match(pattern, image); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// save writes the tracked images to the state file, replacing it atomically.
+func (c *Cache) save() {
+	if c.config.StateFile == "" {
+		return
+	}
+	data, err := json.Marshal(c.Entries())
+	if err != nil {
+		zap.S().Errorw("could not encode image cache state", "error", err)
+		return
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(c.config.StateFile), ".image-cache-*")
+	if err != nil {
+		zap.S().Errorw("could not write image cache state", "error", err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		zap.S().Errorw("could not write image cache state", "error", err)
+		return
+	}
+	if err := tmp.Close(); err != nil {
+		zap.S().Errorw("could not write image cache state", "error", err)
+		return
+	}
+	if err := os.Rename(tmp.Name(), c.config.StateFile); err != nil {
+		zap.S().Errorw("could not write image cache state", "error", err)
+	}
+}
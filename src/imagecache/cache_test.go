@@ -0,0 +1,129 @@
+package imagecache
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"docker-operator/src/docker"
+
+	"github.com/docker/docker/api/types"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache_Evict(t *testing.T) {
+	tests := []struct {
+		description string
+		config      Config
+		wantEvicted []string
+	}{
+		{
+			description: "evict the least recently used images over the count",
+			config:      Config{MaxImages: 2},
+			wantEvicted: []string{"registry.internal/old:1", "registry.internal/mid:1"},
+		},
+		{
+			description: "evict until the total size fits",
+			config:      Config{MaxSize: 800},
+			wantEvicted: []string{"registry.internal/old:1", "registry.internal/mid:1"},
+		},
+		{
+			description: "never evict pinned images",
+			config:      Config{MaxImages: 2, Pinned: []string{"registry.internal/old:*"}},
+			wantEvicted: []string{"registry.internal/mid:1", "registry.internal/new:1"},
+		},
+		{
+			description: "stay within the budget",
+			config:      Config{MaxImages: 4, MaxSize: 1000},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			client := docker.NewMockClientInterface(ctrl)
+			cache, err := New(client, test.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			images := []string{"registry.internal/old:1", "registry.internal/mid:1", "registry.internal/new:1", "registry.internal/running:1"}
+			for i, image := range images {
+				client.EXPECT().ImageInspectWithRaw(gomock.Any(), image).Return(types.ImageInspect{ID: image, Size: int64(100 * (i + 1))}, nil, nil)
+			}
+			cache.Touch(images[0], context.Background())
+			cache.Touch(images[1], context.Background())
+			cache.Touch(images[2], context.Background())
+			cache.Touch(images[3], context.Background())
+			cache.Acquire(images[3])
+
+			for _, image := range test.wantEvicted {
+				client.EXPECT().ImageRemove(gomock.Any(), image, types.ImageRemoveOptions{PruneChildren: true}).Return(nil, nil)
+			}
+			assert.Equal(t, test.wantEvicted, cache.Evict(context.Background()))
+			assert.Len(t, cache.Entries(), len(images)-len(test.wantEvicted))
+		})
+	}
+}
+
+func TestCache_EvictAcquired(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	client := docker.NewMockClientInterface(ctrl)
+	cache, err := New(client, Config{MaxImages: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	images := []string{"registry.internal/old:1", "registry.internal/mid:1", "registry.internal/new:1"}
+	for _, image := range images {
+		client.EXPECT().ImageInspectWithRaw(gomock.Any(), image).Return(types.ImageInspect{ID: image}, nil, nil)
+		cache.Touch(image, context.Background())
+	}
+
+	acquired := make(chan struct{})
+	client.EXPECT().ImageRemove(gomock.Any(), images[0], gomock.Any()).
+		DoAndReturn(func(context.Context, string, types.ImageRemoveOptions) ([]types.ImageDeleteResponseItem, error) {
+			// an execution starts while the old image is removed and after the candidates were listed
+			cache.Acquire(images[1])
+			go func() {
+				cache.Acquire(images[0])
+				close(acquired)
+			}()
+			select {
+			case <-acquired:
+				t.Error("an image being evicted was acquired")
+			case <-time.After(10 * time.Millisecond):
+			}
+			return nil, nil
+		})
+	client.EXPECT().ImageRemove(gomock.Any(), images[2], gomock.Any()).Return(nil, nil)
+
+	assert.Equal(t, []string{images[0], images[2]}, cache.Evict(context.Background()))
+	<-acquired
+}
+
+func TestCache_StateFile(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	client := docker.NewMockClientInterface(ctrl)
+	stateFile := filepath.Join(t.TempDir(), "image-cache.json")
+	cache, err := New(client, Config{MaxImages: 10, StateFile: stateFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.EXPECT().ImageInspectWithRaw(gomock.Any(), "registry.internal/tool:1").
+		Return(types.ImageInspect{ID: "sha256:1", Size: 42, RepoDigests: []string{"mirror.internal/tool@sha256:3", "registry.internal/tool@sha256:2"}}, nil, nil)
+	cache.Touch("registry.internal/tool:1", context.Background())
+	cache.save()
+
+	restored, err := New(client, Config{MaxImages: 10, StateFile: stateFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := restored.Entries()
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "registry.internal/tool:1", entries[0].Image)
+	assert.Equal(t, "sha256:2", entries[0].Digest)
+	assert.Equal(t, int64(42), entries[0].Size)
+}
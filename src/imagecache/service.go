@@ -0,0 +1,41 @@
+package imagecache
+
+import (
+	"context"
+
+	"docker-operator/src/docker"
+)
+
+// Service records every image run or pulled through the wrapped service in the cache.
+type Service struct {
+	docker.ServiceInterface
+	Cache *Cache
+}
+
+func (s *Service) RunContainer(image string, params []string, ctx context.Context) ([]byte, *docker.Headers, error) {
+	s.Cache.Acquire(image)
+	defer s.Cache.Release(image, context.Background())
+	return s.ServiceInterface.RunContainer(image, params, ctx)
+}
+
+func (s *Service) RunContainerPost(image string, reqBody []string, ctx context.Context) ([]byte, *docker.Headers, error) {
+	s.Cache.Acquire(image)
+	defer s.Cache.Release(image, context.Background())
+	return s.ServiceInterface.RunContainerPost(image, reqBody, ctx)
+}
+
+func (s *Service) PullImage(image string, ctx context.Context) error {
+	if err := s.ServiceInterface.PullImage(image, ctx); err != nil {
+		return err
+	}
+	s.Cache.Touch(image, ctx)
+	return nil
+}
+
+// NewService wraps a service so the cache tracks the images it uses.
+func NewService(service docker.ServiceInterface, cache *Cache) docker.ServiceInterface {
+	return &Service{
+		ServiceInterface: service,
+		Cache:            cache,
+	}
+}
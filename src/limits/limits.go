@@ -0,0 +1,145 @@
+package limits
+
+import (
+	"fmt"
+	"time"
+
+	"docker-operator/config"
+	"docker-operator/src/registry"
+)
+
+// Limit is a token bucket holding Burst tokens, refilled with Rate tokens every
+// Period, and a number of executions per caller and UTC day. Zero values are not enforced.
+type Limit struct {
+	Rate       int           `mapstructure:"rate"`
+	Period     time.Duration `mapstructure:"period"`
+	Burst      int           `mapstructure:"burst"`
+	DailyQuota int           `mapstructure:"daily_quota"`
+}
+
+// Override applies its own limit to the images matching its pattern.
+type Override struct {
+	// Image is a glob matched against the image path and the full name, e.g. "tools/*".
+	Image string `mapstructure:"image"`
+	Limit `mapstructure:",squash"`
+}
+
+// Config holds the default limit and the per image overrides, the first matching override wins.
+type Config struct {
+	Default Limit      `mapstructure:"default"`
+	Images  []Override `mapstructure:"images"`
+}
+
+// Decision is the outcome of a request against the limits.
+type Decision struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration
+	Reason     string
+}
+
+// Limiter counts the executions of each caller in its store.
+type Limiter struct {
+	config Config
+	store  Store
+	now    func() time.Time
+}
+
+// Load reads the limits from the given file and counts in memory.
+func Load(path string) (*Limiter, error) {
+	cfg := Config{}
+	if err := config.LoadFile(path, &cfg); err != nil {
+		return nil, err
+	}
+	return New(cfg, NewMemoryStore())
+}
+
+// New validates the limits and builds a limiter counting in the store.
+func New(cfg Config, store Store) (*Limiter, error) {
+	if err := cfg.Default.validate(); err != nil {
+		return nil, fmt.Errorf("default limit: %w", err)
+	}
+	for i := range cfg.Images {
+		if cfg.Images[i].Image == "" {
+			return nil, fmt.Errorf("image limit %d has no image", i)
+		}
+		if err := cfg.Images[i].validate(); err != nil {
+			return nil, fmt.Errorf("limit of %s: %w", cfg.Images[i].Image, err)
+		}
+	}
+	return &Limiter{config: cfg, store: store, now: time.Now}, nil
+}
+
+func (l *Limit) validate() error {
+	if l.Rate < 0 || l.Burst < 0 || l.DailyQuota < 0 {
+		return fmt.Errorf("limits cannot be negative")
+	}
+	if l.Rate > 0 && l.Period <= 0 {
+		l.Period = time.Second
+	}
+	if l.Burst == 0 {
+		l.Burst = l.Rate
+	}
+	return nil
+}
+
+// Allow counts an execution of the image by the caller, e.g. "identity:ci" or "ip:10.0.0.1".
+func (l *Limiter) Allow(caller, image string) (Decision, error) {
+	limit, bucket := l.limitOf(image)
+	now := l.now()
+	decision := Decision{Allowed: true}
+	if limit.Rate > 0 {
+		remaining, retryAfter, err := l.store.Take(caller+"|"+bucket, limit, now)
+		if err != nil {
+			return Decision{}, err
+		}
+		decision.Limit = limit.Burst
+		decision.Remaining = remaining
+		if retryAfter > 0 {
+			decision.Allowed = false
+			decision.RetryAfter = retryAfter
+			decision.Reason = fmt.Sprintf("rate limit of %d executions per %s exceeded", limit.Rate, limit.Period)
+			return decision, nil
+		}
+	}
+	if limit.DailyQuota > 0 {
+		day := now.UTC().Truncate(24 * time.Hour)
+		count, err := l.store.Increment(caller+"|"+bucket+"|"+day.Format("2006-01-02"), day.Add(24*time.Hour))
+		if err != nil {
+			return Decision{}, err
+		}
+		if limit.Rate == 0 {
+			decision.Limit = limit.DailyQuota
+			decision.Remaining = limit.DailyQuota - count
+			if decision.Remaining < 0 {
+				decision.Remaining = 0
+			}
+		}
+		if count > limit.DailyQuota {
+			// the execution does not run, it does not use up the rate limit either
+			if limit.Rate > 0 {
+				if err := l.store.Refund(caller+"|"+bucket, limit); err != nil {
+					return Decision{}, err
+				}
+				if decision.Remaining < limit.Burst {
+					decision.Remaining++
+				}
+			}
+			decision.Allowed = false
+			decision.RetryAfter = day.Add(24 * time.Hour).Sub(now)
+			decision.Reason = fmt.Sprintf("daily quota of %d executions exceeded", limit.DailyQuota)
+		}
+	}
+	return decision, nil
+}
+
+// limitOf returns the limit of the image and the name of its bucket.
+func (l *Limiter) limitOf(image string) (Limit, string) {
+	for _, override := range l.config.Images {
+		if registry.MatchImage(override.Image, image) {
+			return override.Limit, override.Image
+		}
+	}
+	return l.config.Default, "default"
+}
@@ -0,0 +1,57 @@
+package limits
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	limiter, err := New(Config{
+		Default: Limit{Rate: 2, Period: time.Minute},
+		Images: []Override{
+			{Image: "tools/heavy", Limit: Limit{DailyQuota: 2}},
+			{Image: "tools/report", Limit: Limit{Rate: 2, Period: time.Minute, DailyQuota: 1}},
+		},
+	}, NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2021, 6, 1, 23, 0, 0, 0, time.UTC)
+	limiter.now = func() time.Time { return now }
+
+	type outcome struct {
+		allowed    bool
+		remaining  int
+		retryAfter time.Duration
+	}
+	allow := func(caller, image string) outcome {
+		decision, err := limiter.Allow(caller, image)
+		assert.NoError(t, err)
+		return outcome{decision.Allowed, decision.Remaining, decision.RetryAfter}
+	}
+
+	// the bucket holds two tokens and gets one back every 30s
+	assert.Equal(t, outcome{true, 1, 0}, allow("identity:ci", "registry.local/alpine:3.13"))
+	assert.Equal(t, outcome{true, 0, 0}, allow("identity:ci", "registry.local/alpine:3.13"))
+	assert.Equal(t, outcome{false, 0, 30 * time.Second}, allow("identity:ci", "registry.local/alpine:3.13"))
+	// other callers have their own bucket
+	assert.Equal(t, outcome{true, 1, 0}, allow("ip:10.0.0.1", "registry.local/alpine:3.13"))
+	now = now.Add(30 * time.Second)
+	assert.Equal(t, outcome{true, 0, 0}, allow("identity:ci", "registry.local/alpine:3.13"))
+
+	// the override only counts executions per day
+	assert.Equal(t, outcome{true, 1, 0}, allow("identity:ci", "registry.local/tools/heavy:1"))
+	assert.Equal(t, outcome{true, 0, 0}, allow("identity:ci", "registry.local/tools/heavy:1"))
+	assert.Equal(t, outcome{false, 0, 59*time.Minute + 30*time.Second}, allow("identity:ci", "registry.local/tools/heavy:1"))
+	now = now.Add(time.Hour)
+	assert.Equal(t, outcome{true, 1, 0}, allow("identity:ci", "registry.local/tools/heavy:1"))
+
+	// the executions over the quota give their token back
+	assert.Equal(t, outcome{true, 1, 0}, allow("identity:ci", "registry.local/tools/report:1"))
+	assert.Equal(t, outcome{false, 1, 23*time.Hour + 59*time.Minute + 30*time.Second}, allow("identity:ci", "registry.local/tools/report:1"))
+	assert.Equal(t, outcome{false, 1, 23*time.Hour + 59*time.Minute + 30*time.Second}, allow("identity:ci", "registry.local/tools/report:1"))
+	now = now.Add(24 * time.Hour)
+	assert.Equal(t, outcome{true, 1, 0}, allow("identity:ci", "registry.local/tools/report:1"))
+}
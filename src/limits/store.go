@@ -0,0 +1,110 @@
+package limits
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Store keeps the counters of the limiter, e.g. in memory or shared between instances.
+type Store interface {
+	// Take removes a token from the bucket of the key. It returns the tokens left,
+	// or how long to wait for the next token when the bucket is empty.
+	Take(key string, limit Limit, now time.Time) (remaining int, retryAfter time.Duration, err error)
+	// Refund puts back the token taken from the bucket of the key for an
+	// execution that was denied for another reason.
+	Refund(key string, limit Limit) error
+	// Increment adds one to the counter of the key and returns its new value.
+	// The counter starts again from zero after the expiry.
+	Increment(key string, expiry time.Time) (int, error)
+}
+
+// MemoryStore keeps the counters of a single operator instance.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	counters  map[string]*counter
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	full   time.Time
+}
+
+type counter struct {
+	count  int
+	expiry time.Time
+}
+
+// NewMemoryStore builds an empty store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets:  make(map[string]*bucket),
+		counters: make(map[string]*counter),
+	}
+}
+
+func (s *MemoryStore) Take(key string, limit Limit, now time.Time) (int, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(now)
+	perToken := limit.Period / time.Duration(limit.Rate)
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+float64(now.Sub(b.last))/float64(perToken))
+	b.last = now
+	if b.tokens < 1 {
+		return 0, time.Duration((1 - b.tokens) * float64(perToken)), nil
+	}
+	b.tokens--
+	b.full = now.Add(time.Duration((float64(limit.Burst) - b.tokens) * float64(perToken)))
+	return int(b.tokens), 0, nil
+}
+
+func (s *MemoryStore) Refund(key string, limit Limit) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[key]
+	if !ok {
+		return nil
+	}
+	perToken := limit.Period / time.Duration(limit.Rate)
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+1)
+	b.full = b.last.Add(time.Duration((float64(limit.Burst) - b.tokens) * float64(perToken)))
+	return nil
+}
+
+func (s *MemoryStore) Increment(key string, expiry time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.counters[key]
+	if !ok {
+		c = &counter{expiry: expiry}
+		s.counters[key] = c
+	}
+	c.count++
+	return c.count, nil
+}
+
+// sweep drops the full buckets and expired counters once a minute.
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for key, b := range s.buckets {
+		if !b.full.After(now) {
+			delete(s.buckets, key)
+		}
+	}
+	for key, c := range s.counters {
+		if !c.expiry.After(now) {
+			delete(s.counters, key)
+		}
+	}
+}
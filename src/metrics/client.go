@@ -0,0 +1,169 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"docker-operator/src/docker"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	specs "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Client times the steps of the executions and counts the failed calls of the wrapped client.
+type Client struct {
+	docker.ClientInterface
+	Metrics *Metrics
+
+	mu      sync.Mutex
+	started map[string]time.Time
+}
+
+// NewClient wraps a docker client so its calls are measured.
+func NewClient(client docker.ClientInterface, metrics *Metrics) docker.ClientInterface {
+	return &Client{
+		ClientInterface: client,
+		Metrics:         metrics,
+		started:         make(map[string]time.Time),
+	}
+}
+
+func (c *Client) ImagePull(ctx context.Context, refStr string, options types.ImagePullOptions) (io.ReadCloser, error) {
+	start := time.Now()
+	body, err := c.ClientInterface.ImagePull(ctx, refStr, options)
+	if err != nil {
+		c.failed("image_pull", err)
+		return nil, err
+	}
+	// the pull goes on until the progress stream is read to the end
+	return &timedReadCloser{ReadCloser: body, done: func() {
+		c.Metrics.ObserveStep(StepPull, time.Since(start))
+	}}, nil
+}
+
+func (c *Client) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *specs.Platform, containerName string) (container.ContainerCreateCreatedBody, error) {
+	start := time.Now()
+	body, err := c.ClientInterface.ContainerCreate(ctx, config, hostConfig, networkingConfig, platform, containerName)
+	if err != nil {
+		c.failed("container_create", err)
+		return body, err
+	}
+	c.Metrics.ObserveStep(StepCreate, time.Since(start))
+	return body, nil
+}
+
+func (c *Client) ContainerStart(ctx context.Context, containerID string, options types.ContainerStartOptions) error {
+	start := time.Now()
+	if err := c.ClientInterface.ContainerStart(ctx, containerID, options); err != nil {
+		c.failed("container_start", err)
+		return err
+	}
+	c.mu.Lock()
+	c.started[containerID] = start
+	c.mu.Unlock()
+	return nil
+}
+
+// ContainerWait observes the run of the container from its start until it stops.
+func (c *Client) ContainerWait(ctx context.Context, containerID string, condition container.WaitCondition) (<-chan container.ContainerWaitOKBody, <-chan error) {
+	statusCh, errCh := c.ClientInterface.ContainerWait(ctx, containerID, condition)
+	c.mu.Lock()
+	start, ok := c.started[containerID]
+	delete(c.started, containerID)
+	c.mu.Unlock()
+	if !ok {
+		return statusCh, errCh
+	}
+	status := make(chan container.ContainerWaitOKBody, 1)
+	errs := make(chan error, 1)
+	go func() {
+		select {
+		case s := <-statusCh:
+			c.Metrics.ObserveStep(StepRun, time.Since(start))
+			status <- s
+		case err := <-errCh:
+			if err != nil {
+				c.failed("container_wait", err)
+			}
+			errs <- err
+		}
+	}()
+	return status, errs
+}
+
+func (c *Client) ContainerLogs(ctx context.Context, containerID string, options types.ContainerLogsOptions) (io.ReadCloser, error) {
+	logs, err := c.ClientInterface.ContainerLogs(ctx, containerID, options)
+	if err != nil {
+		c.failed("container_logs", err)
+	}
+	return logs, err
+}
+
+func (c *Client) ContainerRemove(ctx context.Context, containerID string, options types.ContainerRemoveOptions) error {
+	// the container may be removed without being waited for, e.g. when its execution was cancelled
+	c.mu.Lock()
+	delete(c.started, containerID)
+	c.mu.Unlock()
+	err := c.ClientInterface.ContainerRemove(ctx, containerID, options)
+	if err != nil {
+		c.failed("container_remove", err)
+	}
+	return err
+}
+
+func (c *Client) ImageInspectWithRaw(ctx context.Context, imageID string) (types.ImageInspect, []byte, error) {
+	inspect, raw, err := c.ClientInterface.ImageInspectWithRaw(ctx, imageID)
+	if err != nil {
+		c.failed("image_inspect", err)
+	}
+	return inspect, raw, err
+}
+
+func (c *Client) ImageRemove(ctx context.Context, imageID string, options types.ImageRemoveOptions) ([]types.ImageDeleteResponseItem, error) {
+	deleted, err := c.ClientInterface.ImageRemove(ctx, imageID, options)
+	if err != nil {
+		c.failed("image_remove", err)
+	}
+	return deleted, err
+}
+
+func (c *Client) ImageList(ctx context.Context, options types.ImageListOptions) ([]types.ImageSummary, error) {
+	images, err := c.ClientInterface.ImageList(ctx, options)
+	if err != nil {
+		c.failed("image_list", err)
+	}
+	return images, err
+}
+
+func (c *Client) ContainerKill(ctx context.Context, containerID, signal string) error {
+	err := c.ClientInterface.ContainerKill(ctx, containerID, signal)
+	if err != nil {
+		c.failed("container_kill", err)
+	}
+	return err
+}
+
+// failed counts an error of the docker API. Missing images and containers are
+// expected, e.g. when checking whether an image is local, and are not counted.
+func (c *Client) failed(operation string, err error) {
+	if client.IsErrNotFound(err) || err == context.Canceled {
+		return
+	}
+	c.Metrics.DockerAPIError(operation)
+}
+
+type timedReadCloser struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (t *timedReadCloser) Close() error {
+	t.once.Do(t.done)
+	return t.ReadCloser.Close()
+}
@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+var (
+	durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+	sizeBuckets     = []float64{256, 1024, 4096, 16384, 65536, 262144, 1048576, 4194304, 16777216}
+)
+
+// Steps of an execution measured by the instrumented client.
+const (
+	StepPull   = "pull"
+	StepCreate = "create"
+	StepRun    = "run"
+	StepTotal  = "total"
+)
+
+// Metrics are the metrics of the operator.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	executions  *prometheus.CounterVec
+	imageCache  *prometheus.CounterVec
+	durations   *prometheus.HistogramVec
+	outputBytes prometheus.Histogram
+	apiErrors   *prometheus.CounterVec
+	inFlight    prometheus.Gauge
+}
+
+// New registers the metrics of the operator.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		executions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "docker_operator_executions_total",
+			Help: "Executions by image path and outcome.",
+		}, []string{"image", "outcome"}),
+		imageCache: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "docker_operator_image_cache_total",
+			Help: "Executions whose image was already up to date on the docker host (warm) or had to be downloaded (cold).",
+		}, []string{"state"}),
+		durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "docker_operator_step_duration_seconds",
+			Help:    "Duration of the steps of an execution.",
+			Buckets: durationBuckets,
+		}, []string{"step"}),
+		outputBytes: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "docker_operator_output_bytes",
+			Help:    "Size of the output of successful executions.",
+			Buckets: sizeBuckets,
+		}),
+		apiErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "docker_operator_docker_api_errors_total",
+			Help: "Failed calls to the docker API by operation.",
+		}, []string{"operation"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "docker_operator_executions_in_flight",
+			Help: "Executions currently running.",
+		}),
+	}
+	m.registry.MustRegister(m.executions, m.imageCache, m.durations, m.outputBytes, m.apiErrors, m.inFlight)
+	return m
+}
+
+// ObserveStep records the duration of a step of an execution.
+func (m *Metrics) ObserveStep(step string, duration time.Duration) {
+	m.durations.WithLabelValues(step).Observe(duration.Seconds())
+}
+
+// DockerAPIError counts a failed call to the docker API.
+func (m *Metrics) DockerAPIError(operation string) {
+	m.apiErrors.WithLabelValues(operation).Inc()
+}
+
+// Handler serves the metrics in the Prometheus text format.
+func (m *Metrics) Handler() func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		families, err := m.registry.Gather()
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		encoder := expfmt.NewEncoder(&buf, expfmt.FmtText)
+		for _, family := range families {
+			if err := encoder.Encode(family); err != nil {
+				return err
+			}
+		}
+		c.Set(fiber.HeaderContentType, string(expfmt.FmtText))
+		return c.Send(buf.Bytes())
+	}
+}
@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"docker-operator/src/docker"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := New()
+
+	mockService := docker.NewMockServiceInterface(ctrl)
+	mockService.EXPECT().RunContainer("registry.local/tools/report:1.0", nil, gomock.Any()).Return([]byte("hello"), &docker.Headers{}, nil)
+	mockService.EXPECT().RunContainer("registry.local/missing:1", nil, gomock.Any()).Return(nil, nil, docker.NotFoundError)
+	service := NewService(mockService, m)
+	service.RunContainer("registry.local/tools/report:1.0", nil, context.Background())
+	service.RunContainer("registry.local/missing:1", nil, context.Background())
+
+	mockClient := docker.NewMockClientInterface(ctrl)
+	mockClient.EXPECT().ContainerCreate(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(container.ContainerCreateCreatedBody{}, fmt.Errorf("no space left on device"))
+	client := NewClient(mockClient, m)
+	client.ContainerCreate(context.Background(), &container.Config{}, nil, nil, nil, "")
+
+	app := fiber.New()
+	app.Get("/metrics", m.Handler())
+	resp, err := app.Test(httptest.NewRequest("GET", "/metrics", nil), -1)
+	assert.NoError(t, err)
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", resp.Header.Get("Content-Type"))
+	data, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	body := string(data)
+
+	for _, line := range []string{
+		"# TYPE docker_operator_executions_total counter",
+		`docker_operator_executions_total{image="tools/report",outcome="success"} 1`,
+		`docker_operator_executions_total{image="missing",outcome="not_found"} 1`,
+		"# TYPE docker_operator_step_duration_seconds histogram",
+		`docker_operator_step_duration_seconds_count{step="total"} 2`,
+		`docker_operator_output_bytes_bucket{le="256"} 1`,
+		`docker_operator_output_bytes_sum 5`,
+		`docker_operator_docker_api_errors_total{operation="container_create"} 1`,
+		"docker_operator_executions_in_flight 0",
+	} {
+		assert.Contains(t, body, line+"\n")
+	}
+}
+
+func TestService_ImageCache(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	m := New()
+	mockClient := docker.NewMockClientInterface(ctrl)
+	pull := func(image, status string) {
+		mockClient.EXPECT().ImagePull(gomock.Any(), image, gomock.Any()).
+			Return(ioutil.NopCloser(strings.NewReader(`{"status":"Pulling from tools"}`+"\n"+`{"status":"`+status+`"}`+"\n")), nil)
+	}
+	pull("registry.local/tools/report:1.0", "Status: Image is up to date for registry.local/tools/report:1.0")
+	pull("registry.local/tools/new:1.0", "Status: Downloaded newer image for registry.local/tools/new:1.0")
+	mockClient.EXPECT().ImagePull(gomock.Any(), "registry.local/missing:1", gomock.Any()).Return(nil, fmt.Errorf("manifest unknown"))
+	mockClient.EXPECT().ContainerCreate(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+		Return(container.ContainerCreateCreatedBody{}, fmt.Errorf("no space left on device")).Times(2)
+	service := NewService(docker.NewService(mockClient, nil), m)
+	service.RunContainer("registry.local/tools/report:1.0", nil, context.Background())
+	service.RunContainer("registry.local/tools/new:1.0", nil, context.Background())
+	service.RunContainer("registry.local/missing:1", nil, context.Background())
+
+	app := fiber.New()
+	app.Get("/metrics", m.Handler())
+	resp, err := app.Test(httptest.NewRequest("GET", "/metrics", nil), -1)
+	assert.NoError(t, err)
+	data, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	body := string(data)
+
+	// the image that could not be pulled is neither warm nor cold
+	assert.Contains(t, body, `docker_operator_image_cache_total{state="cold"} 1`+"\n")
+	assert.Contains(t, body, `docker_operator_image_cache_total{state="warm"} 1`+"\n")
+}
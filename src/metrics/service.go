@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"docker-operator/src/admission"
+	"docker-operator/src/docker"
+	"docker-operator/src/policy"
+)
+
+// Service measures the executions of the wrapped service.
+type Service struct {
+	docker.ServiceInterface
+	Metrics *Metrics
+}
+
+func (s *Service) RunContainer(image string, params []string, ctx context.Context) ([]byte, *docker.Headers, error) {
+	ctx, done := s.start(image, ctx)
+	out, headers, err := s.ServiceInterface.RunContainer(image, params, ctx)
+	done(out, err)
+	return out, headers, err
+}
+
+func (s *Service) RunContainerPost(image string, reqBody []string, ctx context.Context) ([]byte, *docker.Headers, error) {
+	ctx, done := s.start(image, ctx)
+	out, headers, err := s.ServiceInterface.RunContainerPost(image, reqBody, ctx)
+	done(out, err)
+	return out, headers, err
+}
+
+// start counts an execution in flight and returns the function recording its
+// outcome. The image is counted as warm or cold once the execution pulled it.
+func (s *Service) start(image string, ctx context.Context) (context.Context, func(out []byte, err error)) {
+	ctx = docker.WithImagePulled(ctx, func(downloaded bool) {
+		state := "warm"
+		if downloaded {
+			state = "cold"
+		}
+		s.Metrics.imageCache.WithLabelValues(state).Inc()
+	})
+	s.Metrics.inFlight.Inc()
+	start := time.Now()
+	return ctx, func(out []byte, err error) {
+		s.Metrics.inFlight.Dec()
+		s.Metrics.ObserveStep(StepTotal, time.Since(start))
+		// the tag and the digest are left out of the labels, every new version of an
+		// image would otherwise add a series
+		name := ""
+		if parsed, parseErr := policy.ParseImage(image); parseErr == nil {
+			name = parsed.Path
+		}
+		s.Metrics.executions.WithLabelValues(name, outcome(err)).Inc()
+		if err == nil {
+			s.Metrics.outputBytes.Observe(float64(len(out)))
+		}
+	}
+}
+
+func outcome(err error) string {
+	var denied *policy.DeniedError
+	var rejected *admission.RejectedError
+	switch {
+	case err == nil:
+		return "success"
+	case errors.Is(err, docker.NotFoundError):
+		return "not_found"
+	case errors.Is(err, docker.CancelledError):
+		return "cancelled"
+	case errors.As(err, &denied):
+		return "denied"
+	case errors.As(err, &rejected):
+		return "rejected"
+	default:
+		return "error"
+	}
+}
+
+// NewService wraps a service so its executions are measured.
+func NewService(service docker.ServiceInterface, metrics *Metrics) docker.ServiceInterface {
+	return &Service{
+		ServiceInterface: service,
+		Metrics:          metrics,
+	}
+}
@@ -0,0 +1,160 @@
+package policy
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+)
+
+// Rule matches images by name glob, tag glob and digest. Empty fields match
+// every image, a rule has to match on all of its set fields.
+type Rule struct {
+	// Name is a glob matched against the repository path and the full name
+	// including the registry host, e.g. "tools/*" or "registry.internal/*".
+	Name string `mapstructure:"name"`
+	// Tag is a glob matched against the tag, e.g. "v1.*".
+	Tag string `mapstructure:"tag"`
+	// Digest matches the image digest exactly, e.g. "sha256:...".
+	Digest string `mapstructure:"digest"`
+	// Reason is returned to the caller when the rule denies an image.
+	Reason string `mapstructure:"reason"`
+}
+
+// Policy decides which images may be run. Deny rules win over allow rules,
+// and when allow rules are set an image has to match one of them.
+type Policy struct {
+	Allow []Rule `mapstructure:"allow"`
+	Deny  []Rule `mapstructure:"deny"`
+}
+
+// Image is the part of an image reference the rules are matched against.
+type Image struct {
+	Domain string
+	Path   string
+	Tag    string
+	Digest string
+}
+
+// DeniedError is returned when the policy denies an image.
+type DeniedError struct {
+	Image  string
+	Reason string
+}
+
+func (e *DeniedError) Error() string {
+	return fmt.Sprintf("image %s denied by policy: %s", e.Image, e.Reason)
+}
+
+// ParseImage splits a full image reference into the parts the rules match on.
+func ParseImage(ref string) (Image, error) {
+	parsed, err := reference.Parse(ref)
+	if err != nil {
+		return Image{}, fmt.Errorf("invalid image reference %s: %w", ref, err)
+	}
+	named, ok := parsed.(reference.Named)
+	if !ok {
+		return Image{}, fmt.Errorf("image reference %s has no name", ref)
+	}
+	image := Image{
+		Domain: reference.Domain(named),
+		Path:   reference.Path(named),
+	}
+	if tagged, ok := named.(reference.Tagged); ok {
+		image.Tag = tagged.Tag()
+	}
+	if digested, ok := named.(reference.Digested); ok {
+		image.Digest = digested.Digest().String()
+	}
+	return image, nil
+}
+
+// NeedsDigest reports whether any rule matches on the image digest.
+func (p *Policy) NeedsDigest() bool {
+	for _, rules := range [][]Rule{p.Allow, p.Deny} {
+		for _, rule := range rules {
+			if rule.Digest != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Check returns the reason the image is denied, or an empty string when it is allowed.
+func (p *Policy) Check(image Image) string {
+	for _, rule := range p.Deny {
+		if rule.matches(image) {
+			if rule.Reason != "" {
+				return rule.Reason
+			}
+			return fmt.Sprintf("matches deny rule %s", rule)
+		}
+	}
+	if len(p.Allow) == 0 {
+		return ""
+	}
+	for _, rule := range p.Allow {
+		if rule.matches(image) {
+			return ""
+		}
+	}
+	return "not on the allowlist"
+}
+
+// CheckName returns the reason the image is denied on its name and tag alone,
+// before its digest is known. Deny rules on a digest are left to Check, and allow
+// rules on a digest match on their name and tag.
+func (p *Policy) CheckName(image Image) string {
+	for _, rule := range p.Deny {
+		if rule.Digest == "" && rule.matches(image) {
+			if rule.Reason != "" {
+				return rule.Reason
+			}
+			return fmt.Sprintf("matches deny rule %s", rule)
+		}
+	}
+	if len(p.Allow) == 0 {
+		return ""
+	}
+	for _, rule := range p.Allow {
+		rule.Digest = ""
+		if rule.matches(image) {
+			return ""
+		}
+	}
+	return "not on the allowlist"
+}
+
+func (r Rule) matches(image Image) bool {
+	if r.Name != "" && !glob(r.Name, image.Path) && !glob(r.Name, strings.TrimPrefix(image.Domain+"/"+image.Path, "/")) {
+		return false
+	}
+	if r.Tag != "" && !glob(r.Tag, image.Tag) {
+		return false
+	}
+	if r.Digest != "" && r.Digest != image.Digest {
+		return false
+	}
+	return true
+}
+
+func (r Rule) String() string {
+	var parts []string
+	if r.Name != "" {
+		parts = append(parts, "name="+r.Name)
+	}
+	if r.Tag != "" {
+		parts = append(parts, "tag="+r.Tag)
+	}
+	if r.Digest != "" {
+		parts = append(parts, "digest="+r.Digest)
+	}
+	return strings.Join(parts, ",")
+}
+
+func glob(pattern, value string) bool {
+	matched, err := path.Match(pattern, value)
+	return err == nil && matched
+}
@@ -0,0 +1,168 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"docker-operator/src/docker"
+
+	"github.com/docker/docker/api/types"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	digest     = "sha256:0000000000000000000000000000000000000000000000000000000000000001"
+	vulnerable = "sha256:0000000000000000000000000000000000000000000000000000000000000002"
+)
+
+func TestPolicy_Check(t *testing.T) {
+	policy := &Policy{
+		Allow: []Rule{
+			{Name: "tools/*"},
+			{Name: "registry.internal/*", Tag: "v1.*"},
+		},
+		Deny: []Rule{
+			{Name: "tools/legacy", Reason: "legacy tool is retired"},
+			{Tag: "*-rc*"},
+			{Digest: digest, Reason: "known vulnerable build"},
+		},
+	}
+	tests := []struct {
+		description string
+		image       Image
+		wantReason  string
+	}{
+		{
+			description: "allow an image matching the allowlist",
+			image:       Image{Domain: "registry.internal", Path: "tools/report", Tag: "latest"},
+		},
+		{
+			description: "allow by full name and tag pattern",
+			image:       Image{Domain: "registry.internal", Path: "report", Tag: "v1.2"},
+		},
+		{
+			description: "deny an image missing from the allowlist",
+			image:       Image{Domain: "registry.internal", Path: "report", Tag: "v2.0"},
+			wantReason:  "not on the allowlist",
+		},
+		{
+			description: "deny by name with the rule reason",
+			image:       Image{Domain: "registry.internal", Path: "tools/legacy", Tag: "latest"},
+			wantReason:  "legacy tool is retired",
+		},
+		{
+			description: "deny by tag without a reason",
+			image:       Image{Domain: "registry.internal", Path: "tools/report", Tag: "1.0-rc1"},
+			wantReason:  "matches deny rule tag=*-rc*",
+		},
+		{
+			description: "deny by digest",
+			image:       Image{Domain: "registry.internal", Path: "tools/report", Digest: digest},
+			wantReason:  "known vulnerable build",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			assert.Equal(t, test.wantReason, policy.Check(test.image))
+		})
+	}
+}
+
+func TestParseImage(t *testing.T) {
+	image, err := ParseImage("registry.internal:5000/tools/report:v1.2")
+	assert.NoError(t, err)
+	assert.Equal(t, Image{Domain: "registry.internal:5000", Path: "tools/report", Tag: "v1.2"}, image)
+
+	image, err = ParseImage("report@" + digest)
+	assert.NoError(t, err)
+	assert.Equal(t, Image{Path: "report", Digest: digest}, image)
+
+	_, err = ParseImage("Report:latest")
+	assert.Error(t, err)
+}
+
+func TestService_RunContainer(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	next := docker.NewMockServiceInterface(ctrl)
+	service := NewService(next, NewStaticStore(&Policy{
+		Allow: []Rule{{Name: "report", Digest: digest}},
+		Deny:  []Rule{{Name: "legacy/*"}, {Digest: vulnerable, Reason: "known vulnerable build"}},
+	}))
+
+	// the digest rules of unpinned references are left to the review of the pulled image
+	next.EXPECT().RunContainer("registry.internal/report:v1", nil, gomock.Any()).Return([]byte("ok"), &docker.Headers{}, nil)
+	out, _, err := service.RunContainer("registry.internal/report:v1", nil, context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("ok"), out)
+
+	var denied *DeniedError
+	_, _, err = service.RunContainer("registry.internal/legacy/report:v1", nil, context.Background())
+	assert.True(t, errors.As(err, &denied))
+	_, _, err = service.RunContainer("registry.internal/report@"+vulnerable, nil, context.Background())
+	assert.True(t, errors.As(err, &denied))
+	assert.Equal(t, "known vulnerable build", denied.Reason)
+}
+
+func TestService_PullImage(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	next := docker.NewMockServiceInterface(ctrl)
+	service := NewService(next, NewStaticStore(&Policy{
+		Deny: []Rule{{Digest: digest, Reason: "known vulnerable build"}},
+	}))
+
+	next.EXPECT().PullImage("registry.internal/report:v1", gomock.Any()).Return(nil)
+	next.EXPECT().ImageDigest("registry.internal/report:v1", gomock.Any()).Return(digest, nil)
+	var denied *DeniedError
+	assert.True(t, errors.As(service.PullImage("registry.internal/report:v1", context.Background()), &denied))
+	assert.Equal(t, "known vulnerable build", denied.Reason)
+
+	next.EXPECT().PullImage("registry.internal/report:v2", gomock.Any()).Return(nil)
+	next.EXPECT().ImageDigest("registry.internal/report:v2", gomock.Any()).Return("", errors.New("no such image"))
+	assert.True(t, errors.As(service.PullImage("registry.internal/report:v2", context.Background()), &denied))
+}
+
+func TestStore_Review(t *testing.T) {
+	store := NewStaticStore(&Policy{
+		Deny: []Rule{{Digest: digest, Reason: "known vulnerable build"}},
+	})
+	tests := []struct {
+		description string
+		image       string
+		repoDigests []string
+		wantReason  string
+	}{
+		{
+			description: "deny the digest of the pulled image",
+			image:       "registry.internal/report:v1",
+			repoDigests: []string{"registry.internal/report@" + digest},
+			wantReason:  "known vulnerable build",
+		},
+		{
+			description: "allow another digest",
+			image:       "registry.internal/report:v2",
+			repoDigests: []string{"registry.internal/report@sha256:other"},
+		},
+		{
+			description: "deny when the image has no digest in its repository",
+			image:       "registry.internal/report:v1",
+			repoDigests: []string{"registry.internal/copy@sha256:other"},
+			wantReason:  "digest could not be resolved",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			err := store.Review(test.image, types.ImageInspect{ID: "sha256:id", RepoDigests: test.repoDigests})
+			if test.wantReason == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var denied *DeniedError
+			assert.True(t, errors.As(err, &denied))
+			assert.Equal(t, test.wantReason, denied.Reason)
+		})
+	}
+}
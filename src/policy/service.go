@@ -0,0 +1,111 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+
+	"docker-operator/src/docker"
+
+	"github.com/docker/docker/api/types"
+	"go.uber.org/zap"
+)
+
+// Service checks every image against the policy before handing it to the wrapped service.
+type Service struct {
+	docker.ServiceInterface
+	Store *Store
+}
+
+func (s *Service) RunContainer(image string, params []string, ctx context.Context) ([]byte, *docker.Headers, error) {
+	if err := s.check(image); err != nil {
+		return nil, nil, err
+	}
+	return s.ServiceInterface.RunContainer(image, params, ctx)
+}
+
+func (s *Service) RunContainerPost(image string, reqBody []string, ctx context.Context) ([]byte, *docker.Headers, error) {
+	if err := s.check(image); err != nil {
+		return nil, nil, err
+	}
+	return s.ServiceInterface.RunContainerPost(image, reqBody, ctx)
+}
+
+// PullImage checks the digest of the pulled image, which is left on the docker
+// host but denied on every run when the policy denies it.
+func (s *Service) PullImage(image string, ctx context.Context) error {
+	if err := s.check(image); err != nil {
+		return err
+	}
+	if err := s.ServiceInterface.PullImage(image, ctx); err != nil {
+		return err
+	}
+	policy := s.Store.Policy()
+	parsed, _ := ParseImage(image)
+	if parsed.Digest != "" || !policy.NeedsDigest() {
+		return nil
+	}
+	digest, err := s.ServiceInterface.ImageDigest(image, ctx)
+	if err != nil {
+		return deny(image, fmt.Sprintf("digest could not be resolved: %v", err))
+	}
+	parsed.Digest = digest
+	if reason := policy.Check(parsed); reason != "" {
+		return deny(image, reason)
+	}
+	return nil
+}
+
+// check denies the image when the policy does. Unless the reference is pinned
+// to a digest, the rules on the digest are checked by the store once the image
+// is pulled, see Review.
+func (s *Service) check(image string) error {
+	policy := s.Store.Policy()
+	parsed, err := ParseImage(image)
+	if err != nil {
+		return deny(image, err.Error())
+	}
+	reason := policy.Check(parsed)
+	if parsed.Digest == "" && policy.NeedsDigest() {
+		reason = policy.CheckName(parsed)
+	}
+	if reason != "" {
+		return deny(image, reason)
+	}
+	return nil
+}
+
+// Review checks the pulled image against the policy with its digest in the
+// repository it was pulled from. The image is denied when a rule needs its
+// digest and it has none there.
+func (s *Store) Review(image string, inspect types.ImageInspect) error {
+	policy := s.Policy()
+	if !policy.NeedsDigest() {
+		return nil
+	}
+	parsed, err := ParseImage(image)
+	if err != nil {
+		return deny(image, err.Error())
+	}
+	digest, ok := docker.RepoDigest(image, inspect)
+	if !ok {
+		return deny(image, "digest could not be resolved")
+	}
+	parsed.Digest = digest
+	if reason := policy.Check(parsed); reason != "" {
+		return deny(image, reason)
+	}
+	return nil
+}
+
+func deny(image, reason string) error {
+	zap.S().Warnw("image denied by policy", "image", image, "reason", reason)
+	return &DeniedError{Image: image, Reason: reason}
+}
+
+// NewService wraps a service with the policy held by the store.
+func NewService(service docker.ServiceInterface, store *Store) docker.ServiceInterface {
+	return &Service{
+		ServiceInterface: service,
+		Store:            store,
+	}
+}
@@ -0,0 +1,53 @@
+package policy
+
+import (
+	"sync"
+
+	"docker-operator/config"
+
+	"go.uber.org/zap"
+)
+
+// Store holds the current policy and reloads it from its file on demand.
+type Store struct {
+	path   string
+	mu     sync.RWMutex
+	policy *Policy
+}
+
+// NewStore loads the policy from the given file.
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewStaticStore returns a store holding a fixed policy.
+func NewStaticStore(policy *Policy) *Store {
+	return &Store{policy: policy}
+}
+
+// Reload reads the policy file again. The current policy is kept when the file is invalid.
+func (s *Store) Reload() error {
+	if s.path == "" {
+		return nil
+	}
+	policy := &Policy{}
+	if err := config.LoadFile(s.path, policy); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.policy = policy
+	s.mu.Unlock()
+	zap.S().Infow("image policy loaded", "path", s.path, "allow", len(policy.Allow), "deny", len(policy.Deny))
+	return nil
+}
+
+// Policy returns the current policy.
+func (s *Store) Policy() *Policy {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.policy
+}
@@ -0,0 +1,194 @@
+package prewarm
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"docker-operator/config"
+	"docker-operator/src/docker"
+	"docker-operator/src/registry"
+
+	"go.uber.org/zap"
+)
+
+// Image is an image whose tags are pulled ahead of traffic.
+type Image struct {
+	// Registry names the registry, the image is routed by prefix when empty.
+	Registry string   `mapstructure:"registry"`
+	Name     string   `mapstructure:"name"`
+	Tags     []string `mapstructure:"tags"`
+}
+
+// Config lists the images to prewarm and how often they are refreshed.
+type Config struct {
+	// Interval between two refreshes. Zero only prewarms once at startup.
+	Interval time.Duration `mapstructure:"interval"`
+	Images   []Image       `mapstructure:"images"`
+}
+
+// ImageStatus is the outcome of the last pulls of one image reference.
+type ImageStatus struct {
+	Image       string    `json:"image"`
+	ResolvedTag string    `json:"resolved_tag,omitempty"`
+	LastPull    time.Time `json:"last_pull,omitempty"`
+	LastError   string    `json:"last_error,omitempty"`
+	// Failures counts the pulls that failed since the last successful one.
+	Failures int `json:"failures"`
+}
+
+// Status is reported by the health check.
+type Status struct {
+	LastRun time.Time     `json:"last_run,omitempty"`
+	Running bool          `json:"running"`
+	Images  []ImageStatus `json:"images"`
+}
+
+// Prewarmer pulls the configured images at startup and refreshes them on a schedule.
+type Prewarmer struct {
+	service    docker.ServiceInterface
+	registries *registry.Registries
+	config     Config
+
+	mu      sync.RWMutex
+	lastRun time.Time
+	running bool
+	status  map[string]*ImageStatus
+}
+
+// Load reads the prewarm config from the given file.
+func Load(path string, service docker.ServiceInterface, registries *registry.Registries) (*Prewarmer, error) {
+	cfg := Config{}
+	if err := config.LoadFile(path, &cfg); err != nil {
+		return nil, err
+	}
+	return New(cfg, service, registries)
+}
+
+// New validates the config and builds a prewarmer.
+func New(cfg Config, service docker.ServiceInterface, registries *registry.Registries) (*Prewarmer, error) {
+	p := &Prewarmer{
+		service:    service,
+		registries: registries,
+		config:     cfg,
+		status:     make(map[string]*ImageStatus),
+	}
+	for _, image := range cfg.Images {
+		if image.Registry != "" {
+			if _, ok := registries.Get(image.Registry); !ok {
+				return nil, fmt.Errorf("prewarm image %s uses unknown registry %s", image.Name, image.Registry)
+			}
+		}
+		if len(image.Tags) == 0 {
+			return nil, fmt.Errorf("prewarm image %s has no tags", image.Name)
+		}
+	}
+	for _, ref := range p.References() {
+		p.status[ref] = &ImageStatus{Image: ref}
+	}
+	return p, nil
+}
+
+// References returns the full references of every prewarmed image.
+func (p *Prewarmer) References() []string {
+	var refs []string
+	for _, image := range p.config.Images {
+		reg, name := p.route(image)
+		for _, tag := range image.Tags {
+			refs = append(refs, reg.Reference(name, tag, ""))
+		}
+	}
+	return refs
+}
+
+// Start prewarms every image and keeps refreshing them until the context is done.
+func (p *Prewarmer) Start(ctx context.Context) {
+	go func() {
+		p.Run(ctx)
+		if p.config.Interval <= 0 {
+			return
+		}
+		ticker := time.NewTicker(p.config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				p.Run(ctx)
+			}
+		}
+	}()
+}
+
+// Run pulls every configured image once.
+func (p *Prewarmer) Run(ctx context.Context) {
+	p.mu.Lock()
+	p.running = true
+	p.mu.Unlock()
+
+	for _, image := range p.config.Images {
+		reg, name := p.route(image)
+		for _, tag := range image.Tags {
+			ref := reg.Reference(name, tag, "")
+			var resolvedTag string
+			if tag == "latest" {
+				resolvedTag, _ = reg.LatestTag(name)
+			}
+			err := p.service.PullImage(ref, ctx)
+			p.record(ref, resolvedTag, err)
+		}
+	}
+
+	p.mu.Lock()
+	p.running = false
+	p.lastRun = time.Now()
+	p.mu.Unlock()
+}
+
+// Status returns the outcome of the last pull of every image.
+func (p *Prewarmer) Status() Status {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	status := Status{
+		LastRun: p.lastRun,
+		Running: p.running,
+		Images:  make([]ImageStatus, 0, len(p.status)),
+	}
+	for _, imageStatus := range p.status {
+		status.Images = append(status.Images, *imageStatus)
+	}
+	sort.Slice(status.Images, func(i, j int) bool {
+		return status.Images[i].Image < status.Images[j].Image
+	})
+	return status
+}
+
+func (p *Prewarmer) record(ref, resolvedTag string, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	imageStatus := p.status[ref]
+	if err != nil {
+		zap.S().Errorw("could not prewarm image", "image", ref, "error", err)
+		imageStatus.LastError = err.Error()
+		imageStatus.Failures++
+		return
+	}
+	zap.S().Debugw("prewarmed image", "image", ref, "resolved_tag", resolvedTag)
+	imageStatus.LastPull = time.Now()
+	imageStatus.LastError = ""
+	imageStatus.Failures = 0
+	if resolvedTag != "" {
+		imageStatus.ResolvedTag = resolvedTag
+	}
+}
+
+func (p *Prewarmer) route(image Image) (*registry.Registry, string) {
+	if image.Registry != "" {
+		reg, _ := p.registries.Get(image.Registry)
+		return reg, image.Name
+	}
+	return p.registries.Route(image.Name)
+}
@@ -0,0 +1,71 @@
+package prewarm
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"docker-operator/src/docker"
+	"docker-operator/src/registry"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrewarmer_Run(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	registries, err := registry.New(
+		&registry.Registry{Name: "internal", Host: "registry.internal", Default: true},
+		&registry.Registry{Name: "mirror", Host: "mirror.internal"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := docker.NewMockServiceInterface(ctrl)
+	prewarmer, err := New(Config{
+		Images: []Image{
+			{Name: "tools/report", Tags: []string{"1.0", "1.1"}},
+			{Registry: "mirror", Name: "alpine", Tags: []string{"3.13"}},
+		},
+	}, service, registries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{
+		"registry.internal/tools/report:1.0",
+		"registry.internal/tools/report:1.1",
+		"mirror.internal/alpine:3.13",
+	}, prewarmer.References())
+
+	service.EXPECT().PullImage("registry.internal/tools/report:1.0", gomock.Any()).Return(nil).Times(2)
+	service.EXPECT().PullImage("registry.internal/tools/report:1.1", gomock.Any()).Return(nil).Times(2)
+	service.EXPECT().PullImage("mirror.internal/alpine:3.13", gomock.Any()).Return(fmt.Errorf("registry unavailable")).Times(2)
+	prewarmer.Run(context.Background())
+	prewarmer.Run(context.Background())
+
+	status := prewarmer.Status()
+	assert.False(t, status.Running)
+	assert.False(t, status.LastRun.IsZero())
+	assert.Len(t, status.Images, 3)
+	failed := status.Images[0]
+	assert.Equal(t, "mirror.internal/alpine:3.13", failed.Image)
+	assert.Equal(t, "registry unavailable", failed.LastError)
+	assert.Equal(t, 2, failed.Failures)
+	assert.True(t, failed.LastPull.IsZero())
+	pulled := status.Images[1]
+	assert.Equal(t, "registry.internal/tools/report:1.0", pulled.Image)
+	assert.Empty(t, pulled.LastError)
+	assert.False(t, pulled.LastPull.IsZero())
+}
+
+func TestNew(t *testing.T) {
+	registries, err := registry.New(&registry.Registry{Name: "internal", Host: "registry.internal"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = New(Config{Images: []Image{{Registry: "mirror", Name: "alpine", Tags: []string{"3.13"}}}}, nil, registries)
+	assert.EqualError(t, err, "prewarm image alpine uses unknown registry mirror")
+	_, err = New(Config{Images: []Image{{Name: "alpine"}}}, nil, registries)
+	assert.EqualError(t, err, "prewarm image alpine has no tags")
+}
@@ -0,0 +1,206 @@
+package rbac
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"docker-operator/config"
+	"docker-operator/src/auth"
+	"docker-operator/src/policy"
+)
+
+// Admin capabilities.
+const (
+	ImagesRead       = "images:read"
+	ImagesWrite      = "images:write"
+	ExecutionsRead   = "executions:read"
+	ExecutionsCancel = "executions:cancel"
+	ExecutionsReplay = "executions:replay"
+	ResponsesPurge   = "responses:purge"
+	AuthzExplain     = "authz:explain"
+)
+
+// Rule grants callers matching its identities or groups to run images matching
+// its patterns with its methods, and its admin capabilities. A rule without
+// identities and groups applies to every caller.
+type Rule struct {
+	Name string `mapstructure:"name"`
+	// Identities are globs matched against the name of the caller.
+	Identities []string `mapstructure:"identities"`
+	Groups     []string `mapstructure:"groups"`
+	// Images are globs matched against the image path and the full name, with
+	// and without tag, e.g. "tools/*", "registry.internal/*" or "tools/report:v1.*".
+	Images []string `mapstructure:"images"`
+	// Methods are the HTTP methods the images may be run with, every method when empty.
+	Methods []string `mapstructure:"methods"`
+	// Capabilities are globs of the admin capabilities granted, e.g. "images:*".
+	Capabilities []string `mapstructure:"capabilities"`
+}
+
+// Policy grants access through its rules, everything else is denied.
+type Policy struct {
+	Rules []Rule `mapstructure:"rules"`
+}
+
+// Request is what a caller attempts: running an image with a method, or using an admin capability.
+type Request struct {
+	Identity   *auth.Identity `json:"identity"`
+	Method     string         `json:"method,omitempty"`
+	Image      string         `json:"image,omitempty"`
+	Capability string         `json:"capability,omitempty"`
+}
+
+// Check is the outcome of a single rule.
+type Check struct {
+	Rule    string `json:"rule"`
+	Matched bool   `json:"matched"`
+	Reason  string `json:"reason"`
+}
+
+// Decision explains whether a request is allowed.
+type Decision struct {
+	Allowed bool    `json:"allowed"`
+	Rule    string  `json:"rule,omitempty"`
+	Reason  string  `json:"reason"`
+	Checks  []Check `json:"checks"`
+}
+
+// DeniedError is returned when the policy denies a request.
+type DeniedError struct {
+	Request Request
+	Reason  string
+}
+
+func (e *DeniedError) Error() string {
+	return fmt.Sprintf("request denied by authorization policy: %s", e.Reason)
+}
+
+func (r Request) String() string {
+	caller := "anonymous"
+	if r.Identity != nil {
+		caller = r.Identity.Name
+	}
+	if r.Capability != "" {
+		return fmt.Sprintf("%s of %s", r.Capability, caller)
+	}
+	return fmt.Sprintf("%s %s of %s", r.Method, r.Image, caller)
+}
+
+// Load reads the authorization policy from the given file.
+func Load(path string) (*Policy, error) {
+	policy := &Policy{}
+	if err := config.LoadFile(path, policy); err != nil {
+		return nil, err
+	}
+	for i, rule := range policy.Rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("authorization rule %d has no name", i)
+		}
+	}
+	return policy, nil
+}
+
+// Evaluate checks the request against every rule, the first matching rule allows it.
+func (p *Policy) Evaluate(req Request) Decision {
+	var image policy.Image
+	if req.Image != "" {
+		parsed, err := policy.ParseImage(req.Image)
+		if err != nil {
+			return Decision{Reason: err.Error()}
+		}
+		image = parsed
+	}
+	decision := Decision{Checks: make([]Check, 0, len(p.Rules))}
+	for _, rule := range p.Rules {
+		reason := rule.mismatch(req, image)
+		decision.Checks = append(decision.Checks, Check{Rule: rule.Name, Matched: reason == "", Reason: reason})
+		if reason == "" && !decision.Allowed {
+			decision.Allowed = true
+			decision.Rule = rule.Name
+			decision.Reason = fmt.Sprintf("allowed by rule %s", rule.Name)
+		}
+	}
+	if !decision.Allowed {
+		decision.Reason = "no rule allows " + req.String()
+	}
+	return decision
+}
+
+// Authorize returns a DeniedError when the request is not allowed.
+func (p *Policy) Authorize(req Request) error {
+	decision := p.Evaluate(req)
+	if !decision.Allowed {
+		return &DeniedError{Request: req, Reason: decision.Reason}
+	}
+	return nil
+}
+
+// mismatch returns why the rule does not match the request, or an empty string when it does.
+func (r Rule) mismatch(req Request, image policy.Image) string {
+	if !r.appliesTo(req.Identity) {
+		return "does not apply to the caller"
+	}
+	if req.Capability != "" {
+		if !matchAny(r.Capabilities, req.Capability) {
+			return fmt.Sprintf("does not grant %s", req.Capability)
+		}
+		return ""
+	}
+	if len(r.Methods) > 0 && !containsFold(r.Methods, req.Method) {
+		return fmt.Sprintf("does not allow method %s", req.Method)
+	}
+	if !r.matchesImage(image) {
+		return "does not match the image"
+	}
+	return ""
+}
+
+func (r Rule) appliesTo(identity *auth.Identity) bool {
+	if len(r.Identities) == 0 && len(r.Groups) == 0 {
+		return true
+	}
+	if identity == nil {
+		return false
+	}
+	if matchAny(r.Identities, identity.Name) {
+		return true
+	}
+	for _, group := range identity.Groups {
+		if matchAny(r.Groups, group) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) matchesImage(image policy.Image) bool {
+	names := []string{image.Path, strings.TrimPrefix(image.Domain+"/"+image.Path, "/")}
+	if image.Tag != "" {
+		names = append(names, names[0]+":"+image.Tag, names[1]+":"+image.Tag)
+	}
+	for _, name := range names {
+		if matchAny(r.Images, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matched, err := path.Match(pattern, value); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
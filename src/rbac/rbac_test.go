@@ -0,0 +1,81 @@
+package rbac
+
+import (
+	"testing"
+
+	"docker-operator/src/auth"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicy_Evaluate(t *testing.T) {
+	policy := &Policy{Rules: []Rule{
+		{Name: "ci-tools", Identities: []string{"ci"}, Images: []string{"tools/*"}, Methods: []string{"GET"}},
+		{Name: "builders", Groups: []string{"builders"}, Images: []string{"registry.internal/*:v1.*"}},
+		{Name: "operators", Groups: []string{"oncall"}, Capabilities: []string{"images:*", ExecutionsRead}},
+		{Name: "everyone", Images: []string{"public/*"}, Methods: []string{"GET"}},
+	}}
+	ci := &auth.Identity{Name: "ci"}
+	alice := &auth.Identity{Name: "alice", Groups: []string{"builders", "oncall"}}
+
+	tests := []struct {
+		description string
+		request     Request
+		wantAllowed bool
+		wantRule    string
+		wantReason  string
+	}{
+		{
+			description: "allow an identity on its images",
+			request:     Request{Identity: ci, Method: "GET", Image: "registry.internal/tools/report:1.0"},
+			wantAllowed: true,
+			wantRule:    "ci-tools",
+			wantReason:  "allowed by rule ci-tools",
+		},
+		{
+			description: "deny a method the rule does not allow",
+			request:     Request{Identity: ci, Method: "POST", Image: "registry.internal/tools/report:1.0"},
+			wantReason:  "no rule allows POST registry.internal/tools/report:1.0 of ci",
+		},
+		{
+			description: "allow a group on tags matching the pattern",
+			request:     Request{Identity: alice, Method: "POST", Image: "registry.internal/billing:v1.2"},
+			wantAllowed: true,
+			wantRule:    "builders",
+			wantReason:  "allowed by rule builders",
+		},
+		{
+			description: "deny a group on other tags",
+			request:     Request{Identity: alice, Method: "POST", Image: "registry.internal/billing:v2.0"},
+			wantReason:  "no rule allows POST registry.internal/billing:v2.0 of alice",
+		},
+		{
+			description: "allow everyone on rules without identities",
+			request:     Request{Method: "GET", Image: "registry.internal/public/hello:1"},
+			wantAllowed: true,
+			wantRule:    "everyone",
+			wantReason:  "allowed by rule everyone",
+		},
+		{
+			description: "grant admin capabilities by glob",
+			request:     Request{Identity: alice, Capability: ImagesWrite},
+			wantAllowed: true,
+			wantRule:    "operators",
+			wantReason:  "allowed by rule operators",
+		},
+		{
+			description: "deny capabilities not granted",
+			request:     Request{Identity: alice, Capability: ExecutionsCancel},
+			wantReason:  "no rule allows executions:cancel of alice",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			decision := policy.Evaluate(test.request)
+			assert.Equal(t, test.wantAllowed, decision.Allowed)
+			assert.Equal(t, test.wantRule, decision.Rule)
+			assert.Equal(t, test.wantReason, decision.Reason)
+			assert.Len(t, decision.Checks, len(policy.Rules))
+		})
+	}
+}
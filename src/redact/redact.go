@@ -0,0 +1,210 @@
+package redact
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"docker-operator/config"
+	"docker-operator/src/registry"
+
+	"github.com/docker/distribution/reference"
+)
+
+// Mask replaces the redacted values.
+const Mask = "***"
+
+const postDataPrefix = "POST_DATA="
+
+// ContentRule switches the logging of the output of the images it matches.
+type ContentRule struct {
+	// Image is a glob matched against the repository path and the full name
+	// including the registry host, e.g. "billing/*" or "registry.internal/*".
+	// An empty glob matches every image.
+	Image string `mapstructure:"image"`
+	Log   bool   `mapstructure:"log"`
+}
+
+// Config holds the redaction rules of the logged requests.
+type Config struct {
+	// Keys are the names of the query params and JSON body fields whose values are hidden.
+	Keys []string `mapstructure:"keys"`
+	// Patterns are regular expressions whose matches are hidden in the params,
+	// content, errors and stderr excerpts.
+	Patterns []string `mapstructure:"patterns"`
+	// Headers are the names of the output headers whose values are hidden.
+	Headers []string `mapstructure:"headers"`
+	// Content switches the logging of the output per image, the first matching
+	// rule wins. The output is logged when no rule matches.
+	Content []ContentRule `mapstructure:"content"`
+}
+
+// Redactor hides secrets and personal data from the logs. A nil redactor
+// leaves everything as is.
+type Redactor struct {
+	keys     map[string]bool
+	patterns []*regexp.Regexp
+	headers  map[string]bool
+	content  []ContentRule
+}
+
+// Load reads the redaction rules from a file.
+func Load(path string) (*Redactor, error) {
+	var cfg Config
+	if err := config.LoadFile(path, &cfg); err != nil {
+		return nil, err
+	}
+	return New(cfg)
+}
+
+// New compiles the redaction rules.
+func New(cfg Config) (*Redactor, error) {
+	r := &Redactor{
+		keys:    make(map[string]bool),
+		headers: make(map[string]bool),
+		content: cfg.Content,
+	}
+	for _, key := range cfg.Keys {
+		r.keys[strings.ToLower(key)] = true
+	}
+	for _, header := range cfg.Headers {
+		r.headers[strings.ToLower(header)] = true
+	}
+	for _, pattern := range cfg.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %w", pattern, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// String hides the matches of the patterns.
+func (r *Redactor) String(s string) string {
+	if r == nil {
+		return s
+	}
+	for _, re := range r.patterns {
+		s = re.ReplaceAllString(s, Mask)
+	}
+	return s
+}
+
+// Params hides the values of the keys in query strings and JSON bodies, then
+// the matches of the patterns.
+func (r *Redactor) Params(params []string) []string {
+	if r == nil || params == nil {
+		return params
+	}
+	redacted := make([]string, 0, len(params))
+	for _, param := range params {
+		if strings.HasPrefix(param, postDataPrefix) {
+			param = postDataPrefix + r.body(strings.TrimPrefix(param, postDataPrefix))
+		} else {
+			param = r.query(param)
+		}
+		redacted = append(redacted, r.String(param))
+	}
+	return redacted
+}
+
+func (r *Redactor) query(query string) string {
+	pairs := strings.Split(query, "&")
+	for i, pair := range pairs {
+		j := strings.Index(pair, "=")
+		if j < 0 {
+			continue
+		}
+		key, err := url.QueryUnescape(pair[:j])
+		if err != nil {
+			key = pair[:j]
+		}
+		if r.keys[strings.ToLower(key)] {
+			pairs[i] = pair[:j+1] + Mask
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func (r *Redactor) body(body string) string {
+	var value interface{}
+	if len(r.keys) == 0 || json.Unmarshal([]byte(body), &value) != nil {
+		return body
+	}
+	redacted, err := json.Marshal(r.value(value))
+	if err != nil {
+		return body
+	}
+	return string(redacted)
+}
+
+func (r *Redactor) value(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if r.keys[strings.ToLower(key)] {
+				v[key] = Mask
+			} else {
+				v[key] = r.value(field)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = r.value(item)
+		}
+	}
+	return value
+}
+
+// Headers hides the values of the headers, then the matches of the patterns.
+func (r *Redactor) Headers(headers map[string]string) map[string]string {
+	if r == nil || headers == nil {
+		return headers
+	}
+	redacted := make(map[string]string, len(headers))
+	for name, value := range headers {
+		if r.headers[strings.ToLower(name)] {
+			redacted[name] = Mask
+		} else {
+			redacted[name] = r.String(value)
+		}
+	}
+	return redacted
+}
+
+// LogContent reports whether the output of the image may be logged.
+func (r *Redactor) LogContent(image string) bool {
+	if r == nil || len(r.content) == 0 {
+		return true
+	}
+	parsed, err := reference.Parse(image)
+	if err != nil {
+		return false
+	}
+	if _, ok := parsed.(reference.Named); !ok {
+		return false
+	}
+	for _, rule := range r.content {
+		if rule.Image == "" || registry.MatchImage(rule.Image, image) {
+			return rule.Log
+		}
+	}
+	return true
+}
+
+type contextKey struct{}
+
+// WithRedactor returns a context carrying the redactor.
+func WithRedactor(ctx context.Context, r *Redactor) context.Context {
+	return context.WithValue(ctx, contextKey{}, r)
+}
+
+// FromContext returns the redactor of the context, nil when there is none.
+func FromContext(ctx context.Context) *Redactor {
+	r, _ := ctx.Value(contextKey{}).(*Redactor)
+	return r
+}
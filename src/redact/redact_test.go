@@ -0,0 +1,42 @@
+package redact
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactor(t *testing.T) {
+	r, err := New(Config{
+		Keys:     []string{"token", "Password"},
+		Patterns: []string{`\b[0-9]{16}\b`},
+		Headers:  []string{"set-cookie"},
+		Content: []ContentRule{
+			{Image: "billing/public", Log: true},
+			{Image: "billing/*", Log: false},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, []string{"a=1&token=***&card=***"}, r.Params([]string{"a=1&token=secret&card=4111111111111111"}))
+	assert.Equal(t, []string{`POST_DATA={"items":[{"password":"***"}],"user":"bob"}`},
+		r.Params([]string{`POST_DATA={"user":"bob","items":[{"password":"secret"}]}`}))
+	assert.Equal(t, []string{"POST_DATA=not json, card ***"}, r.Params([]string{"POST_DATA=not json, card 4111111111111111"}))
+	assert.Equal(t, map[string]string{"Set-Cookie": Mask, "Content-Type": "text/plain"},
+		r.Headers(map[string]string{"Set-Cookie": "session=1", "Content-Type": "text/plain"}))
+	assert.Equal(t, "card *** declined", r.String("card 4111111111111111 declined"))
+
+	assert.True(t, r.LogContent("registry.local/tools/report:1.0"))
+	assert.False(t, r.LogContent("registry.local/billing/invoices:1.0"))
+	assert.True(t, r.LogContent("registry.local/billing/public:1.0"))
+
+	var none *Redactor
+	assert.Equal(t, "token=secret", none.String("token=secret"))
+	assert.Equal(t, []string{"token=secret"}, none.Params([]string{"token=secret"}))
+	assert.True(t, none.LogContent("registry.local/billing/invoices:1.0"))
+
+	_, err = New(Config{Patterns: []string{"("}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,27 @@
+package registry
+
+import (
+	"path"
+
+	"github.com/docker/distribution/reference"
+)
+
+// MatchImage reports whether the glob matches the repository path of the image
+// reference or its full name including the registry host, e.g. "tools/*" or
+// "registry.internal/*". Invalid references match no glob.
+func MatchImage(pattern, image string) bool {
+	parsed, err := reference.Parse(image)
+	if err != nil {
+		return false
+	}
+	named, ok := parsed.(reference.Named)
+	if !ok {
+		return false
+	}
+	for _, name := range []string{reference.Path(named), named.Name()} {
+		if matched, err := path.Match(pattern, name); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,27 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchImage(t *testing.T) {
+	tests := []struct {
+		pattern string
+		image   string
+		matched bool
+	}{
+		{pattern: "tools/*", image: "registry.local/tools/report:1.0", matched: true},
+		{pattern: "registry.local/*/*", image: "registry.local/tools/report@sha256:" + digest64, matched: true},
+		{pattern: "tools/*", image: "tools/report", matched: true},
+		{pattern: "tools/*", image: "registry.local/tools/team/report:1.0", matched: false},
+		{pattern: "other.local/*/*", image: "registry.local/tools/report:1.0", matched: false},
+		{pattern: "tools/*", image: "Invalid/Reference", matched: false},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.matched, MatchImage(test.pattern, test.image), test.pattern+" "+test.image)
+	}
+}
+
+const digest64 = "6a92cd1fcdc8d8cdec60f33dda4db2cb1fcdcacf3410a8e05b3741f44a9b5998"
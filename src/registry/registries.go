@@ -0,0 +1,140 @@
+package registry
+
+import (
+	"fmt"
+	"strings"
+
+	"docker-operator/config"
+)
+
+// Registries is the set of registries configured for the operator.
+type Registries struct {
+	registries []*Registry
+	byName     map[string]*Registry
+	fallback   *Registry
+}
+
+type registriesFile struct {
+	Registries []*Registry `mapstructure:"registries"`
+}
+
+// Load reads the registries from the file set in REGISTRIES_FILE.
+// Without a file a single registry named default is built from REGISTRY.
+func Load() (*Registries, error) {
+	path := config.DefaultConfig.GetString("REGISTRIES_FILE")
+	if path == "" {
+		return FromEnv()
+	}
+	file := &registriesFile{}
+	if err := config.LoadFile(path, file); err != nil {
+		return nil, err
+	}
+	return New(file.Registries...)
+}
+
+// FromEnv builds the registries from the single REGISTRY setting.
+func FromEnv() (*Registries, error) {
+	return New(&Registry{
+		Name:    "default",
+		Host:    config.DefaultConfig.GetString("REGISTRY"),
+		Default: true,
+	})
+}
+
+// New validates the given registries and builds the routing table.
+func New(registries ...*Registry) (*Registries, error) {
+	if len(registries) == 0 {
+		return nil, fmt.Errorf("at least one registry has to be configured")
+	}
+	r := &Registries{
+		registries: registries,
+		byName:     make(map[string]*Registry, len(registries)),
+	}
+	for _, reg := range registries {
+		if reg.Name == "" {
+			return nil, fmt.Errorf("registry with host %s has no name", reg.Host)
+		}
+		if _, ok := r.byName[reg.Name]; ok {
+			return nil, fmt.Errorf("registry %s is configured twice", reg.Name)
+		}
+		if err := reg.init(); err != nil {
+			return nil, err
+		}
+		r.byName[reg.Name] = reg
+		if reg.Default {
+			if r.fallback != nil {
+				return nil, fmt.Errorf("registries %s and %s are both marked as default", r.fallback.Name, reg.Name)
+			}
+			r.fallback = reg
+		}
+	}
+	if r.fallback == nil {
+		r.fallback = registries[0]
+	}
+	// the references pulled from a host have to tell the registries apart
+	claimed := make(map[string]*Registry)
+	for _, reg := range registries {
+		for _, prefix := range reg.referencePrefixes(reg == r.fallback) {
+			key := reg.Host + "/" + prefix
+			if other, ok := claimed[key]; ok {
+				return nil, fmt.Errorf("registries %s and %s both pull the images of host %q with prefix %q", other.Name, reg.Name, reg.Host, prefix)
+			}
+			claimed[key] = reg
+		}
+	}
+	return r, nil
+}
+
+// Get returns the registry with the given name.
+func (r *Registries) Get(name string) (*Registry, bool) {
+	reg, ok := r.byName[name]
+	return reg, ok
+}
+
+// All returns every configured registry.
+func (r *Registries) All() []*Registry {
+	return r.registries
+}
+
+// Route picks the registry for an image name by the longest matching prefix,
+// falling back to the default registry. It returns the image name to use in
+// that registry, which has the prefix removed when the registry strips it.
+func (r *Registries) Route(imageName string) (*Registry, string) {
+	var (
+		match  *Registry
+		prefix string
+	)
+	for _, reg := range r.registries {
+		if p := reg.matchPrefix(imageName); p != "" && len(p) > len(prefix) {
+			match, prefix = reg, p
+		}
+	}
+	if match == nil {
+		return r.fallback, imageName
+	}
+	if match.StripPrefix {
+		return match, strings.TrimPrefix(imageName, prefix)
+	}
+	return match, imageName
+}
+
+// ForReference returns the registry a full image reference points to, matched
+// on its host and then on the longest prefix of the image name it routes.
+func (r *Registries) ForReference(ref string) (*Registry, bool) {
+	var (
+		match   *Registry
+		longest = -1
+	)
+	for _, reg := range r.registries {
+		name, ok := reg.imageName(ref)
+		if !ok {
+			continue
+		}
+		for _, prefix := range reg.referencePrefixes(reg == r.fallback) {
+			if strings.HasPrefix(name, prefix) && len(prefix) > longest {
+				match, longest = reg, len(prefix)
+			}
+		}
+	}
+	return match, match != nil
+}
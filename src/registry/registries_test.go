@@ -0,0 +1,154 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistries_Route(t *testing.T) {
+	registries, err := New(
+		&Registry{Name: "internal", Host: "registry.internal", Default: true},
+		&Registry{Name: "dockerhub", Host: "docker.io", Prefixes: []string{"library/"}},
+		&Registry{Name: "mirror", Host: "mirror.internal", Prefixes: []string{"mirror/", "mirror/library/"}, StripPrefix: true},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		description  string
+		imageName    string
+		wantRegistry string
+		wantName     string
+	}{
+		{
+			description:  "fall back to the default registry",
+			imageName:    "hello_world",
+			wantRegistry: "internal",
+			wantName:     "hello_world",
+		},
+		{
+			description:  "route by prefix and keep it",
+			imageName:    "library/alpine",
+			wantRegistry: "dockerhub",
+			wantName:     "library/alpine",
+		},
+		{
+			description:  "route by the longest prefix and strip it",
+			imageName:    "mirror/library/alpine",
+			wantRegistry: "mirror",
+			wantName:     "alpine",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			reg, name := registries.Route(test.imageName)
+			assert.Equal(t, test.wantRegistry, reg.Name)
+			assert.Equal(t, test.wantName, name)
+		})
+	}
+}
+
+func TestRegistries_ForReference(t *testing.T) {
+	registries, err := New(
+		&Registry{Name: "internal", Host: "registry.internal"},
+		&Registry{Name: "internal-tls", Host: "registry.internal:5000", PullPolicy: PullIfNotPresent},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, ok := registries.ForReference("registry.internal:5000/tool:1.0")
+	assert.True(t, ok)
+	assert.Equal(t, "internal-tls", reg.Name)
+	assert.Equal(t, PullIfNotPresent, reg.PullPolicy)
+
+	reg, ok = registries.ForReference("registry.internal/tool:1.0")
+	assert.True(t, ok)
+	assert.Equal(t, PullAlways, reg.PullPolicy)
+
+	_, ok = registries.ForReference("docker.io/tool:1.0")
+	assert.False(t, ok)
+}
+
+func TestRegistries_ForReferencePrefix(t *testing.T) {
+	registries, err := New(
+		&Registry{Name: "internal", Host: "registry.internal", Default: true},
+		&Registry{Name: "team", Host: "registry.internal", Prefixes: []string{"team/"}, PullPolicy: PullIfNotPresent},
+		&Registry{Name: "hub", Prefixes: []string{"hub_"}, StripPrefix: true, PullPolicy: PullNever},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ref          string
+		wantRegistry string
+	}{
+		{ref: "registry.internal/tool:1.0", wantRegistry: "internal"},
+		{ref: "registry.internal/team/tool:1.0", wantRegistry: "team"},
+		{ref: "alpine:3.13", wantRegistry: "hub"},
+		{ref: "library/alpine:3.13", wantRegistry: "hub"},
+	}
+	for _, test := range tests {
+		reg, ok := registries.ForReference(test.ref)
+		assert.True(t, ok, test.ref)
+		assert.Equal(t, test.wantRegistry, reg.Name, test.ref)
+	}
+	_, ok := registries.ForReference("mirror.internal/tool:1.0")
+	assert.False(t, ok)
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		description string
+		registries  []*Registry
+		err         string
+	}{
+		{
+			description: "reject missing registries",
+			err:         "at least one registry has to be configured",
+		},
+		{
+			description: "reject duplicate names",
+			registries:  []*Registry{{Name: "a"}, {Name: "a"}},
+			err:         "registry a is configured twice",
+		},
+		{
+			description: "reject two default registries",
+			registries:  []*Registry{{Name: "a", Default: true}, {Name: "b", Default: true}},
+			err:         "registries a and b are both marked as default",
+		},
+		{
+			description: "reject registries of a host that cannot be told apart",
+			registries:  []*Registry{{Name: "a", Host: "registry.internal"}, {Name: "b", Host: "registry.internal"}},
+			err:         `registries a and b both pull the images of host "registry.internal" with prefix ""`,
+		},
+		{
+			description: "reject registries of a host routing the same prefix",
+			registries: []*Registry{
+				{Name: "a", Host: "registry.internal", Default: true},
+				{Name: "b", Host: "registry.internal", Prefixes: []string{"team/"}},
+				{Name: "c", Host: "registry.internal", Prefixes: []string{"team/"}},
+			},
+			err: `registries b and c both pull the images of host "registry.internal" with prefix "team/"`,
+		},
+		{
+			description: "reject registries of a host stripping their prefixes",
+			registries: []*Registry{
+				{Name: "a", Host: "registry.internal", Default: true},
+				{Name: "b", Host: "registry.internal", Prefixes: []string{"team_"}, StripPrefix: true},
+			},
+			err: `registries a and b both pull the images of host "registry.internal" with prefix ""`,
+		},
+		{
+			description: "reject unknown pull policies",
+			registries:  []*Registry{{Name: "a", PullPolicy: "sometimes"}},
+			err:         "registry a has unknown pull policy sometimes",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			_, err := New(test.registries...)
+			assert.EqualError(t, err, test.err)
+		})
+	}
+}
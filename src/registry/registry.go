@@ -0,0 +1,189 @@
+package registry
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+type PullPolicy string
+
+const (
+	// PullAlways pulls the image before every run.
+	PullAlways PullPolicy = "always"
+	// PullIfNotPresent only pulls the image when it is missing on the docker host.
+	PullIfNotPresent PullPolicy = "if-not-present"
+	// PullNever never pulls, the image has to exist on the docker host.
+	PullNever PullPolicy = "never"
+)
+
+// TLS holds the settings used when talking to the registry API directly.
+type TLS struct {
+	// Insecure talks plain http to the registry.
+	Insecure bool `mapstructure:"insecure"`
+	// SkipVerify disables verification of the registry certificate.
+	SkipVerify bool `mapstructure:"skip_verify"`
+	// CAFile is a PEM bundle used to verify the registry certificate.
+	CAFile string `mapstructure:"ca_file"`
+}
+
+// Registry is a named docker registry the operator can run images from.
+type Registry struct {
+	Name string `mapstructure:"name"`
+	Host string `mapstructure:"host"`
+	// Prefixes routes image names starting with one of them to this registry.
+	Prefixes []string `mapstructure:"prefixes"`
+	// StripPrefix removes the matched prefix from the image name before pulling.
+	StripPrefix bool       `mapstructure:"strip_prefix"`
+	Username    string     `mapstructure:"username"`
+	Password    string     `mapstructure:"password"`
+	TLS         TLS        `mapstructure:"tls"`
+	PullPolicy  PullPolicy `mapstructure:"pull_policy"`
+	// Default receives every image that does not match a prefix.
+	Default bool `mapstructure:"default"`
+
+	httpClient *http.Client
+}
+
+type imageTag struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+// Reference builds the full image reference for an image name in this registry.
+// The reference is pinned to the digest when one is given, otherwise it points to the tag.
+func (r *Registry) Reference(imageName, tag, digest string) string {
+	name := imageName
+	if r.Host != "" {
+		name = fmt.Sprintf("%s/%s", r.Host, imageName)
+	}
+	if digest != "" {
+		return fmt.Sprintf("%s@%s", name, digest)
+	}
+	return fmt.Sprintf("%s:%s", name, tag)
+}
+
+// RegistryAuth returns the encoded credentials expected by ImagePullOptions.
+// It returns an empty string when the registry has no credentials.
+func (r *Registry) RegistryAuth() (string, error) {
+	if r.Username == "" && r.Password == "" {
+		return "", nil
+	}
+	authConfig := types.AuthConfig{
+		Username:      r.Username,
+		Password:      r.Password,
+		ServerAddress: r.Host,
+	}
+	encoded, err := json.Marshal(authConfig)
+	if err != nil {
+		return "", fmt.Errorf("could not encode credentials of registry %s: %w", r.Name, err)
+	}
+	return base64.URLEncoding.EncodeToString(encoded), nil
+}
+
+// LatestTag resolves the tag the latest tag currently points to by listing the tags of the image.
+func (r *Registry) LatestTag(imageName string) (string, error) {
+	scheme := "https"
+	if r.TLS.Insecure {
+		scheme = "http"
+	}
+	url := fmt.Sprintf(`%s://%s/v2/%s/tags/list`, scheme, r.Host, imageName)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	if r.Username != "" || r.Password != "" {
+		req.SetBasicAuth(r.Username, r.Password)
+	}
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	imageTagResponse := &imageTag{}
+	err = json.NewDecoder(resp.Body).Decode(imageTagResponse)
+	if err != nil {
+		return "", err
+	}
+	tags := imageTagResponse.Tags
+	if len(tags) < 2 {
+		return "", fmt.Errorf("not enough tags to resolve latest for image %s", imageName)
+	}
+	sort.Strings(tags)
+	return tags[len(tags)-2], nil
+}
+
+func (r *Registry) init() error {
+	if r.PullPolicy == "" {
+		r.PullPolicy = PullAlways
+	}
+	switch r.PullPolicy {
+	case PullAlways, PullIfNotPresent, PullNever:
+	default:
+		return fmt.Errorf("registry %s has unknown pull policy %s", r.Name, r.PullPolicy)
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: r.TLS.SkipVerify}
+	if r.TLS.CAFile != "" {
+		pem, err := ioutil.ReadFile(r.TLS.CAFile)
+		if err != nil {
+			return fmt.Errorf("could not read ca file of registry %s: %w", r.Name, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in ca file of registry %s", r.Name)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	r.httpClient = &http.Client{
+		Timeout:   30 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+	return nil
+}
+
+func (r *Registry) matchPrefix(imageName string) string {
+	var longest string
+	for _, prefix := range r.Prefixes {
+		if strings.HasPrefix(imageName, prefix) && len(prefix) > len(longest) {
+			longest = prefix
+		}
+	}
+	return longest
+}
+
+// referencePrefixes returns the prefixes of the image names in the references
+// pulled from this registry, an empty one when it pulls any name: the prefixes
+// are gone once stripped, and the fallback registry gets the unmatched names.
+func (r *Registry) referencePrefixes(fallback bool) []string {
+	var prefixes []string
+	if fallback || r.StripPrefix || len(r.Prefixes) == 0 {
+		prefixes = append(prefixes, "")
+	}
+	if !r.StripPrefix {
+		prefixes = append(prefixes, r.Prefixes...)
+	}
+	return prefixes
+}
+
+// imageName returns the image name of a full reference pulled from this registry.
+func (r *Registry) imageName(ref string) (string, bool) {
+	if r.Host != "" {
+		return strings.TrimPrefix(ref, r.Host+"/"), strings.HasPrefix(ref, r.Host+"/")
+	}
+	// a reference without a registry host, its first component is no domain
+	if i := strings.Index(ref, "/"); i >= 0 {
+		if domain := ref[:i]; strings.ContainsAny(domain, ".:") || domain == "localhost" {
+			return "", false
+		}
+	}
+	return ref, true
+}
@@ -0,0 +1,224 @@
+package responsecache
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// MemoryBackend keeps the entries in memory.
+type MemoryBackend struct {
+	maxEntries int
+	maxSize    int64
+
+	mu      sync.Mutex
+	size    int64
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key   string
+	entry Entry
+}
+
+// NewMemoryBackend builds a memory backend holding at most maxEntries entries of maxSize bytes in total.
+func NewMemoryBackend(maxEntries int, maxSize int64) *MemoryBackend {
+	return &MemoryBackend{
+		maxEntries: maxEntries,
+		maxSize:    maxSize,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}
+}
+
+func (b *MemoryBackend) Get(key string) (Entry, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	element, ok := b.entries[key]
+	if !ok {
+		return Entry{}, false
+	}
+	b.order.MoveToFront(element)
+	return element.Value.(*memoryEntry).entry, true
+}
+
+func (b *MemoryBackend) Set(key string, entry Entry) error {
+	if b.maxSize > 0 && entry.size() > b.maxSize {
+		return fmt.Errorf("output of %d bytes exceeds the cache size", entry.size())
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if element, ok := b.entries[key]; ok {
+		b.remove(element)
+	}
+	b.entries[key] = b.order.PushFront(&memoryEntry{key: key, entry: entry})
+	b.size += entry.size()
+	for b.overLimits() {
+		b.remove(b.order.Back())
+	}
+	return nil
+}
+
+func (b *MemoryBackend) Purge(match func(Entry) bool) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	purged := 0
+	for element := b.order.Front(); element != nil; {
+		next := element.Next()
+		if match(element.Value.(*memoryEntry).entry) {
+			b.remove(element)
+			purged++
+		}
+		element = next
+	}
+	return purged
+}
+
+func (b *MemoryBackend) overLimits() bool {
+	return (b.maxEntries > 0 && b.order.Len() > b.maxEntries) || (b.maxSize > 0 && b.size > b.maxSize)
+}
+
+func (b *MemoryBackend) remove(element *list.Element) {
+	entry := b.order.Remove(element).(*memoryEntry)
+	delete(b.entries, entry.key)
+	b.size -= entry.entry.size()
+}
+
+// DiskBackend keeps every entry in a file of its directory. The modification
+// time of the files tracks their last use.
+type DiskBackend struct {
+	dir        string
+	maxEntries int
+	maxSize    int64
+
+	mu sync.Mutex
+}
+
+const entryExt = ".json"
+
+// NewDiskBackend builds a disk backend holding at most maxEntries entries of maxSize bytes in total.
+func NewDiskBackend(dir string, maxEntries int, maxSize int64) (*DiskBackend, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("the disk cache backend needs a directory")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("could not create cache directory %s: %w", dir, err)
+	}
+	return &DiskBackend{dir: dir, maxEntries: maxEntries, maxSize: maxSize}, nil
+}
+
+func (b *DiskBackend) Get(key string) (Entry, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	path := b.path(key)
+	entry, err := readEntry(path)
+	if err != nil {
+		return Entry{}, false
+	}
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	return entry, true
+}
+
+func (b *DiskBackend) Set(key string, entry Entry) error {
+	if b.maxSize > 0 && entry.size() > b.maxSize {
+		return fmt.Errorf("output of %d bytes exceeds the cache size", entry.size())
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := ioutil.WriteFile(b.path(key), data, 0600); err != nil {
+		return fmt.Errorf("could not write cache entry: %w", err)
+	}
+	b.evict()
+	return nil
+}
+
+func (b *DiskBackend) Purge(match func(Entry) bool) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	files, err := b.files()
+	if err != nil {
+		zap.S().Errorw("could not list cache entries", "error", err)
+		return 0
+	}
+	purged := 0
+	for _, file := range files {
+		path := filepath.Join(b.dir, file.Name())
+		entry, err := readEntry(path)
+		if err == nil && !match(entry) {
+			continue
+		}
+		if os.Remove(path) == nil {
+			purged++
+		}
+	}
+	return purged
+}
+
+// evict removes the least recently used entries until the directory is within its limits.
+func (b *DiskBackend) evict() {
+	files, err := b.files()
+	if err != nil {
+		zap.S().Errorw("could not list cache entries", "error", err)
+		return
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+	var size int64
+	for _, file := range files {
+		size += file.Size()
+	}
+	for count := len(files); len(files) > 0; count-- {
+		if (b.maxEntries == 0 || count <= b.maxEntries) && (b.maxSize == 0 || size <= b.maxSize) {
+			return
+		}
+		if err := os.Remove(filepath.Join(b.dir, files[0].Name())); err != nil {
+			zap.S().Errorw("could not evict cache entry", "file", files[0].Name(), "error", err)
+		}
+		size -= files[0].Size()
+		files = files[1:]
+	}
+}
+
+func (b *DiskBackend) files() ([]os.FileInfo, error) {
+	all, err := ioutil.ReadDir(b.dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []os.FileInfo
+	for _, file := range all {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), entryExt) {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+func (b *DiskBackend) path(key string) string {
+	return filepath.Join(b.dir, key+entryExt)
+}
+
+func readEntry(path string) (Entry, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Entry{}, err
+	}
+	var entry Entry
+	err = json.Unmarshal(data, &entry)
+	return entry, err
+}
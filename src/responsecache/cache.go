@@ -0,0 +1,172 @@
+package responsecache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"docker-operator/config"
+	"docker-operator/src/registry"
+)
+
+// Backends of the cache.
+const (
+	BackendMemory = "memory"
+	BackendDisk   = "disk"
+)
+
+// Rule caches the GET executions of the images matching its glob.
+type Rule struct {
+	// Image is a glob matched against the image path and the full name, e.g. "tools/*".
+	Image string `mapstructure:"image"`
+	// TTL of the cached outputs. The max-age of the Cache-Control header of the
+	// output is used when it is zero.
+	TTL time.Duration `mapstructure:"ttl"`
+	// Vary are the request headers the output depends on, they are part of the key.
+	Vary []string `mapstructure:"vary"`
+}
+
+// Config holds the cached images, the first matching rule wins, and where and
+// how much is cached. A zero limit is not enforced.
+type Config struct {
+	Images     []Rule `mapstructure:"images"`
+	Backend    string `mapstructure:"backend"`
+	Dir        string `mapstructure:"dir"`
+	MaxEntries int    `mapstructure:"max_entries"`
+	MaxSize    int64  `mapstructure:"max_size"`
+}
+
+// Load reads the cached images from the given file and the storage of the cache
+// from the environment.
+func Load(path string) (Config, error) {
+	cfg := Config{}
+	if err := config.LoadFile(path, &cfg); err != nil {
+		return Config{}, err
+	}
+	cfg.Backend = config.DefaultConfig.GetString("RESPONSE_CACHE_BACKEND")
+	cfg.Dir = config.DefaultConfig.GetString("RESPONSE_CACHE_DIR")
+	cfg.MaxEntries = config.DefaultConfig.GetInt("RESPONSE_CACHE_MAX_ENTRIES")
+	cfg.MaxSize = int64(config.DefaultConfig.GetSizeInBytes("RESPONSE_CACHE_MAX_SIZE"))
+	return cfg, nil
+}
+
+// Entry is a cached output.
+type Entry struct {
+	Image   string            `json:"image"`
+	Body    []byte            `json:"body"`
+	Headers map[string]string `json:"headers"`
+	Stored  time.Time         `json:"stored"`
+	Expires time.Time         `json:"expires"`
+}
+
+func (e Entry) size() int64 {
+	size := int64(len(e.Body))
+	for name, value := range e.Headers {
+		size += int64(len(name) + len(value))
+	}
+	return size
+}
+
+// Backend stores the entries of the cache, evicting the least recently used
+// ones past its limits.
+type Backend interface {
+	Get(key string) (Entry, bool)
+	Set(key string, entry Entry) error
+	// Purge removes the entries matching and returns how many were removed.
+	Purge(match func(Entry) bool) int
+}
+
+// Cache holds the outputs of the executions of the cached images.
+type Cache struct {
+	rules   []Rule
+	backend Backend
+	now     func() time.Time
+}
+
+// New validates the rules and builds the backend of the cache.
+func New(cfg Config) (*Cache, error) {
+	for i, rule := range cfg.Images {
+		if rule.Image == "" {
+			return nil, fmt.Errorf("cache rule %d has no image", i)
+		}
+	}
+	var backend Backend
+	switch cfg.Backend {
+	case "", BackendMemory:
+		backend = NewMemoryBackend(cfg.MaxEntries, cfg.MaxSize)
+	case BackendDisk:
+		var err error
+		if backend, err = NewDiskBackend(cfg.Dir, cfg.MaxEntries, cfg.MaxSize); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown cache backend %s, expected %s or %s", cfg.Backend, BackendMemory, BackendDisk)
+	}
+	return &Cache{rules: cfg.Images, backend: backend, now: time.Now}, nil
+}
+
+// ruleOf returns the rule of the image, if it is cached.
+func (c *Cache) ruleOf(image string) (Rule, bool) {
+	for _, rule := range c.rules {
+		if registry.MatchImage(rule.Image, image) {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// Purge removes the entries of the images matching the glob, every entry when
+// it is empty, and returns how many were removed.
+func (c *Cache) Purge(image string) int {
+	return c.backend.Purge(func(entry Entry) bool {
+		return image == "" || registry.MatchImage(image, entry.Image)
+	})
+}
+
+// key identifies an output by the digest of the image, the params and the
+// request headers it varies on.
+func key(digest string, params []string, vary []string, header func(string) string) string {
+	hash := sha256.New()
+	hash.Write([]byte(digest))
+	for _, param := range params {
+		hash.Write([]byte("\n" + param))
+	}
+	for _, name := range vary {
+		hash.Write([]byte("\n" + http.CanonicalHeaderKey(name) + ": " + header(name)))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// ttl returns how long the output may be cached. Outputs whose Cache-Control
+// forbids caching and stale outputs, which carry a Warning, are never cached,
+// whatever the rule.
+func ttl(rule Rule, headers map[string]string) time.Duration {
+	var maxAge time.Duration
+	for name, value := range headers {
+		if strings.EqualFold(name, "Warning") {
+			return 0
+		}
+		if !strings.EqualFold(name, "Cache-Control") {
+			continue
+		}
+		for _, directive := range strings.Split(value, ",") {
+			directive = strings.ToLower(strings.TrimSpace(directive))
+			switch {
+			case directive == "no-store" || directive == "no-cache" || directive == "private":
+				return 0
+			case strings.HasPrefix(directive, "max-age="):
+				if seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age=")); err == nil && seconds > 0 {
+					maxAge = time.Duration(seconds) * time.Second
+				}
+			}
+		}
+	}
+	if rule.TTL > 0 {
+		return rule.TTL
+	}
+	return maxAge
+}
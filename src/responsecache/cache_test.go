@@ -0,0 +1,210 @@
+package responsecache
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"docker-operator/src/docker"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+const testImage = "registry.local/tools/hello:1"
+
+func newTestService(t *testing.T, cfg Config) (*docker.MockServiceInterface, *Cache, docker.ServiceInterface, *time.Time) {
+	ctrl := gomock.NewController(t)
+	t.Cleanup(ctrl.Finish)
+	next := docker.NewMockServiceInterface(ctrl)
+	cache, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+	return next, cache, NewService(next, cache), &now
+}
+
+func TestService_RunContainer(t *testing.T) {
+	tests := []struct {
+		description string
+		rule        Rule
+		headers     map[string]string
+		wantRuns    int
+	}{
+		{
+			description: "serve the second execution from the cache",
+			rule:        Rule{Image: "tools/*", TTL: time.Minute},
+			wantRuns:    1,
+		},
+		{
+			description: "use the max-age of the output without a rule ttl",
+			rule:        Rule{Image: "tools/*"},
+			headers:     map[string]string{"Cache-Control": "public, max-age=60"},
+			wantRuns:    1,
+		},
+		{
+			description: "never cache outputs marked no-store",
+			rule:        Rule{Image: "tools/*", TTL: time.Minute},
+			headers:     map[string]string{"Cache-Control": "no-store"},
+			wantRuns:    2,
+		},
+		{
+			description: "never cache stale outputs",
+			rule:        Rule{Image: "tools/*", TTL: time.Minute},
+			headers:     map[string]string{"Warning": `110 - "Response is Stale"`},
+			wantRuns:    2,
+		},
+		{
+			description: "never cache without a ttl",
+			rule:        Rule{Image: "tools/*"},
+			wantRuns:    2,
+		},
+		{
+			description: "never cache unmatched images",
+			rule:        Rule{Image: "other/*", TTL: time.Minute},
+			wantRuns:    2,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			next, _, service, now := newTestService(t, Config{Images: []Rule{test.rule}})
+			next.EXPECT().ImageDigest(testImage, gomock.Any()).Return("sha256:1", nil).AnyTimes()
+			next.EXPECT().RunContainer(testImage, []string{"a=1"}, gomock.Any()).
+				Return([]byte("ok"), &docker.Headers{Header: test.headers}, nil).Times(test.wantRuns)
+
+			out, headers, err := service.RunContainer(testImage, []string{"a=1"}, context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, "ok", string(out))
+			*now = now.Add(30 * time.Second)
+			out, headers, err = service.RunContainer(testImage, []string{"a=1"}, context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, "ok", string(out))
+			if test.wantRuns == 1 {
+				assert.Equal(t, "HIT", headers.Header[StatusHeader])
+				assert.Equal(t, "30", headers.Header[AgeHeader])
+			} else if test.rule.Image == "tools/*" {
+				assert.Equal(t, "MISS", headers.Header[StatusHeader])
+			}
+		})
+	}
+}
+
+func TestService_Key(t *testing.T) {
+	next, _, service, now := newTestService(t, Config{Images: []Rule{{Image: "tools/*", TTL: time.Minute, Vary: []string{"accept-language"}}}})
+	next.EXPECT().ImageDigest(testImage, gomock.Any()).Return("sha256:1", nil).AnyTimes()
+	next.EXPECT().RunContainer(testImage, gomock.Any(), gomock.Any()).Return([]byte("ok"), &docker.Headers{}, nil).Times(4)
+
+	english := docker.WithRequestHeader(context.Background(), func(name string, _ ...string) string { return "en" })
+	french := docker.WithRequestHeader(context.Background(), func(name string, _ ...string) string { return "fr" })
+	service.RunContainer(testImage, []string{"a=1"}, english)
+	service.RunContainer(testImage, []string{"a=1"}, english)
+	service.RunContainer(testImage, []string{"a=1"}, french)
+	service.RunContainer(testImage, []string{"a=2"}, english)
+
+	// expired
+	*now = now.Add(2 * time.Minute)
+	_, headers, err := service.RunContainer(testImage, []string{"a=1"}, english)
+	assert.NoError(t, err)
+	assert.Equal(t, "MISS", headers.Header[StatusHeader])
+}
+
+func TestService_PulledDigest(t *testing.T) {
+	next, cache, service, _ := newTestService(t, Config{Images: []Rule{{Image: "tools/*", TTL: time.Minute}}})
+	// the pull of the first execution replaces the local image
+	gomock.InOrder(
+		next.EXPECT().ImageDigest(testImage, gomock.Any()).Return("sha256:1", nil),
+		next.EXPECT().ImageDigest(testImage, gomock.Any()).Return("sha256:2", nil).AnyTimes(),
+	)
+	next.EXPECT().RunContainer(testImage, nil, gomock.Any()).Return([]byte("new"), &docker.Headers{}, nil).Times(2)
+
+	// the output is not cached under the digest looked up before the pull
+	_, headers, err := service.RunContainer(testImage, nil, context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "MISS", headers.Header[StatusHeader])
+	_, ok := cache.backend.Get(key("sha256:1", nil, nil, func(string) string { return "" }))
+	assert.False(t, ok)
+	_, headers, err = service.RunContainer(testImage, nil, context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "MISS", headers.Header[StatusHeader])
+	// the cached output reports the digest of the image it was stored under
+	var digest string
+	out, headers, err := service.RunContainer(testImage, nil, docker.WithImageDigest(context.Background(), func(d string) { digest = d }))
+	assert.NoError(t, err)
+	assert.Equal(t, "HIT", headers.Header[StatusHeader])
+	assert.Equal(t, "new", string(out))
+	assert.Equal(t, "sha256:2", digest)
+}
+
+func TestService_PinnedDigest(t *testing.T) {
+	next, _, service, _ := newTestService(t, Config{Images: []Rule{{Image: "tools/*", TTL: time.Minute}}})
+	pinned := "registry.local/tools/hello@sha256:" + strings.Repeat("2", 64)
+	next.EXPECT().RunContainer(pinned, nil, gomock.Any()).Return([]byte("ok"), &docker.Headers{}, nil).Times(1)
+
+	service.RunContainer(pinned, nil, context.Background())
+	_, headers, err := service.RunContainer(pinned, nil, context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "HIT", headers.Header[StatusHeader])
+}
+
+func TestService_Replay(t *testing.T) {
+	next, _, service, _ := newTestService(t, Config{Images: []Rule{{Image: "tools/*", TTL: time.Minute}}})
+	pinned := "registry.local/tools/hello@sha256:" + strings.Repeat("2", 64)
+	next.EXPECT().RunContainer(pinned, nil, gomock.Any()).Return([]byte("ok"), &docker.Headers{}, nil).Times(2)
+
+	service.RunContainer(pinned, nil, context.Background())
+	// the replay runs the image instead of being answered from the cache
+	out, headers, err := service.RunContainer(pinned, nil, docker.WithReplay(context.Background()))
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", string(out))
+	assert.Empty(t, headers.Header[StatusHeader])
+}
+
+func TestBackends(t *testing.T) {
+	disk, err := NewDiskBackend(t.TempDir(), 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backends := map[string]Backend{
+		"memory": NewMemoryBackend(2, 0),
+		"disk":   disk,
+	}
+	for name, backend := range backends {
+		t.Run(name, func(t *testing.T) {
+			for i, key := range []string{"a", "b", "c"} {
+				stored := time.Now().Add(time.Duration(i) * time.Second)
+				assert.NoError(t, backend.Set(key, Entry{Image: "registry.local/tools/" + key + ":1", Body: []byte(key), Stored: stored}))
+				if disk, ok := backend.(*DiskBackend); ok {
+					// the modification times order the entries
+					assert.NoError(t, os.Chtimes(disk.path(key), stored, stored))
+				}
+			}
+			_, ok := backend.Get("a")
+			assert.False(t, ok, "least recently used entry is evicted")
+			entry, ok := backend.Get("c")
+			assert.True(t, ok)
+			assert.Equal(t, "c", string(entry.Body))
+
+			cache := &Cache{backend: backend}
+			assert.Equal(t, 1, cache.Purge("tools/b"))
+			assert.Equal(t, 1, cache.Purge(""))
+			_, ok = backend.Get("c")
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestMemoryBackend_MaxSize(t *testing.T) {
+	backend := NewMemoryBackend(0, 4)
+	assert.Error(t, backend.Set("a", Entry{Body: []byte("too large")}))
+	assert.NoError(t, backend.Set("a", Entry{Body: []byte("ab")}))
+	assert.NoError(t, backend.Set("b", Entry{Body: []byte("cd")}))
+	assert.NoError(t, backend.Set("c", Entry{Body: []byte("ef")}))
+	_, ok := backend.Get("a")
+	assert.False(t, ok)
+	_, ok = backend.Get("c")
+	assert.True(t, ok)
+}
@@ -0,0 +1,94 @@
+package responsecache
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"docker-operator/log"
+	"docker-operator/src/docker"
+)
+
+// Headers added to the outputs of the cached images.
+const (
+	StatusHeader = "X-Cache"
+	AgeHeader    = "Age"
+)
+
+// Service answers the GET executions of the cached images from the cache, and
+// caches the outputs of the wrapped service.
+type Service struct {
+	docker.ServiceInterface
+	Cache *Cache
+}
+
+func (s *Service) RunContainer(image string, params []string, ctx context.Context) ([]byte, *docker.Headers, error) {
+	rule, ok := s.Cache.ruleOf(image)
+	// a replay checks the image still gives the recorded output, it always runs
+	if !ok || docker.IsReplay(ctx) {
+		return s.ServiceInterface.RunContainer(image, params, ctx)
+	}
+	// the image is not on the docker host yet when there is no digest, there is nothing cached for it
+	digest, err := s.digest(image, ctx)
+	if err == nil {
+		now := s.Cache.now()
+		if entry, ok := s.Cache.backend.Get(s.key(digest, params, ctx, rule)); ok && now.Before(entry.Expires) {
+			headers := docker.CopyHeaders(entry.Headers)
+			headers[StatusHeader] = "HIT"
+			headers[AgeHeader] = strconv.Itoa(int(now.Sub(entry.Stored).Seconds()))
+			docker.ImageDigestResolved(ctx, digest)
+			return append([]byte{}, entry.Body...), &docker.Headers{Header: headers}, nil
+		}
+	}
+	return s.run(image, params, ctx, rule, digest)
+}
+
+// run runs the image and caches its output under the digest of the image that
+// ran, which the pull may have changed since the cache was looked up.
+func (s *Service) run(image string, params []string, ctx context.Context, rule Rule, before string) ([]byte, *docker.Headers, error) {
+	var ran string
+	out, header, err := s.ServiceInterface.RunContainer(image, params, docker.WithImageDigest(ctx, func(digest string) { ran = digest }))
+	if err != nil {
+		return out, header, err
+	}
+	if ran == "" {
+		// the run did not report its digest, e.g. it joined another execution. Its
+		// output is only cached when the local image did not change around the run.
+		if after, err := s.digest(image, ctx); err == nil && after == before {
+			ran = after
+			docker.ImageDigestResolved(ctx, ran)
+		}
+	}
+	headers := docker.CopyHeaders(header.Header)
+	if ttl := ttl(rule, headers); ran != "" && ttl > 0 {
+		key := s.key(ran, params, ctx, rule)
+		now := s.Cache.now()
+		entry := Entry{Image: image, Body: out, Headers: header.Header, Stored: now, Expires: now.Add(ttl)}
+		if err := s.Cache.backend.Set(key, entry); err != nil {
+			log.FromContext(ctx).Warnw("could not cache output", "image", image, "error", err)
+		}
+	}
+	headers[StatusHeader] = "MISS"
+	return out, &docker.Headers{Header: headers}, nil
+}
+
+// key is the key of the output of the image of the digest run with the params.
+func (s *Service) key(digest string, params []string, ctx context.Context, rule Rule) string {
+	return key(digest, params, rule.Vary, func(name string) string { return docker.RequestHeader(ctx, name) })
+}
+
+// digest returns the digest the image is pinned to, or the one of the local image.
+func (s *Service) digest(image string, ctx context.Context) (string, error) {
+	if i := strings.Index(image, "@"); i >= 0 {
+		return image[i+1:], nil
+	}
+	return s.ServiceInterface.ImageDigest(image, ctx)
+}
+
+// NewService wraps a service so the outputs of the cached images are cached.
+func NewService(service docker.ServiceInterface, cache *Cache) docker.ServiceInterface {
+	return &Service{
+		ServiceInterface: service,
+		Cache:            cache,
+	}
+}
@@ -0,0 +1,83 @@
+package stale
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"docker-operator/log"
+	"docker-operator/src/docker"
+)
+
+// Headers added to the stale outputs.
+const (
+	WarningHeader = "Warning"
+	AgeHeader     = "X-Stale-Age"
+	// Warning is the value of the Warning header of the stale outputs.
+	Warning = `110 - "Response is Stale"`
+)
+
+// Service keeps the last successful output of the images served stale per
+// method and inputs, and answers with it when their execution fails.
+type Service struct {
+	docker.ServiceInterface
+	Store *Store
+}
+
+func (s *Service) RunContainer(image string, params []string, ctx context.Context) ([]byte, *docker.Headers, error) {
+	return s.run("GET", image, params, ctx, s.ServiceInterface.RunContainer)
+}
+
+func (s *Service) RunContainerPost(image string, reqBody []string, ctx context.Context) ([]byte, *docker.Headers, error) {
+	return s.run("POST", image, reqBody, ctx, s.ServiceInterface.RunContainerPost)
+}
+
+func (s *Service) run(method, image string, params []string, ctx context.Context,
+	run func(string, []string, context.Context) ([]byte, *docker.Headers, error)) ([]byte, *docker.Headers, error) {
+	rule, ok := s.Store.ruleOf(image)
+	// a replay reports the failure of its run rather than an earlier output
+	if !ok || docker.IsReplay(ctx) {
+		return run(image, params, ctx)
+	}
+	key := method + "\n" + image + "\n" + strings.Join(params, "\n")
+	out, header, err := run(image, params, ctx)
+	if err == nil {
+		var headers map[string]string
+		if header != nil {
+			headers = docker.CopyHeaders(header.Header)
+		}
+		s.Store.Set(key, append([]byte{}, out...), headers, rule.MaxStale)
+		return out, header, nil
+	}
+	if !servedStale(err) {
+		return out, header, err
+	}
+	output, age, ok := s.Store.Get(key)
+	if !ok {
+		return out, header, err
+	}
+	log.FromContext(ctx).Warnw("serving stale output of failed execution", "image", image, "age", age.String(), "error", err.Error())
+	docker.ServedStale(ctx, err)
+	headers := docker.CopyHeaders(output.Headers)
+	headers[WarningHeader] = Warning
+	headers[AgeHeader] = strconv.Itoa(int(age.Seconds()))
+	return append([]byte{}, output.Body...), &docker.Headers{Header: headers}, nil
+}
+
+// servedStale reports whether the error is a failure of the infrastructure the
+// image runs on, its pull or a call to the docker daemon. The failures of the
+// image itself, cancellations and refusals to run the image are not hidden.
+func servedStale(err error) bool {
+	var daemonErr *docker.DaemonError
+	return errors.Is(err, docker.NotFoundError) || errors.As(err, &daemonErr)
+}
+
+// NewService wraps a service so the images served stale answer with their last
+// successful output when their execution fails.
+func NewService(service docker.ServiceInterface, store *Store) docker.ServiceInterface {
+	return &Service{
+		ServiceInterface: service,
+		Store:            store,
+	}
+}
@@ -0,0 +1,139 @@
+package stale
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+
+	"docker-operator/config"
+	"docker-operator/src/registry"
+)
+
+// Rule serves the last successful output of the images matching its glob when
+// their execution fails.
+type Rule struct {
+	// Image is a glob matched against the image path and the full name, e.g. "tools/*".
+	Image string `mapstructure:"image"`
+	// MaxStale is the age past which an output is no longer served.
+	MaxStale time.Duration `mapstructure:"max_stale"`
+}
+
+// DefaultMaxEntries caps the outputs kept when no limit is configured.
+const DefaultMaxEntries = 1000
+
+// Config holds the images served stale, the first matching rule wins, and the
+// number of outputs kept, DefaultMaxEntries when zero.
+type Config struct {
+	Images     []Rule `mapstructure:"images"`
+	MaxEntries int    `mapstructure:"max_entries"`
+}
+
+// Load reads the images served stale from a file.
+func Load(path string) (Config, error) {
+	cfg := Config{}
+	if err := config.LoadFile(path, &cfg); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// Output is the last successful output of an image for some inputs, served
+// until it expires.
+type Output struct {
+	Body    []byte
+	Headers map[string]string
+	Stored  time.Time
+	Expires time.Time
+}
+
+type entry struct {
+	key    string
+	output Output
+}
+
+// Store keeps the last successful outputs until they expire, evicting the least
+// recently stored ones past its limit.
+type Store struct {
+	rules      []Rule
+	maxEntries int
+	now        func() time.Time
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+// New validates the rules of the store.
+func New(cfg Config) (*Store, error) {
+	for i, rule := range cfg.Images {
+		if rule.Image == "" {
+			return nil, fmt.Errorf("stale rule %d has no image", i)
+		}
+		if rule.MaxStale <= 0 {
+			return nil, fmt.Errorf("stale rule %d for %s has no max_stale", i, rule.Image)
+		}
+	}
+	if cfg.MaxEntries <= 0 {
+		cfg.MaxEntries = DefaultMaxEntries
+	}
+	return &Store{
+		rules:      cfg.Images,
+		maxEntries: cfg.MaxEntries,
+		now:        time.Now,
+		order:      list.New(),
+		entries:    make(map[string]*list.Element),
+	}, nil
+}
+
+// ruleOf returns the rule of the image, if it is served stale.
+func (s *Store) ruleOf(image string) (Rule, bool) {
+	for _, rule := range s.rules {
+		if registry.MatchImage(rule.Image, image) {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// Set keeps the output as the last successful one for the key, served until it
+// is older than maxStale, and removes the expired outputs.
+func (s *Store) Set(key string, body []byte, headers map[string]string, maxStale time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if element, ok := s.entries[key]; ok {
+		s.order.Remove(element)
+	}
+	for element := s.order.Front(); element != nil; {
+		next := element.Next()
+		if expired := element.Value.(*entry); now.After(expired.output.Expires) {
+			s.order.Remove(element)
+			delete(s.entries, expired.key)
+		}
+		element = next
+	}
+	output := Output{Body: body, Headers: headers, Stored: now, Expires: now.Add(maxStale)}
+	s.entries[key] = s.order.PushFront(&entry{key: key, output: output})
+	for s.order.Len() > s.maxEntries {
+		delete(s.entries, s.order.Remove(s.order.Back()).(*entry).key)
+	}
+}
+
+// Get returns the last successful output for the key and its age, unless it expired.
+func (s *Store) Get(key string) (Output, time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	element, ok := s.entries[key]
+	if !ok {
+		return Output{}, 0, false
+	}
+	output := element.Value.(*entry).output
+	now := s.now()
+	if now.After(output.Expires) {
+		s.order.Remove(element)
+		delete(s.entries, key)
+		return Output{}, 0, false
+	}
+	return output, now.Sub(output.Stored), true
+}
@@ -0,0 +1,196 @@
+package stale
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"docker-operator/src/common"
+	"docker-operator/src/docker"
+	"docker-operator/src/policy"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+const testImage = "registry.local/tools/report:1"
+
+var daemonErr = &docker.DaemonError{Err: fmt.Errorf("could not start container with: daemon unavailable")}
+
+func TestService_RunContainer(t *testing.T) {
+	tests := []struct {
+		description string
+		image       string
+		replay      bool
+		age         time.Duration
+		err         error
+		wantStale   bool
+		wantErr     error
+	}{
+		{
+			description: "serve the last output when the pull fails",
+			image:       testImage,
+			age:         30 * time.Second,
+			err:         fmt.Errorf("%w, registry unavailable", docker.NotFoundError),
+			wantStale:   true,
+		},
+		{
+			description: "serve the last output when the docker daemon fails",
+			image:       testImage,
+			age:         30 * time.Second,
+			err:         daemonErr,
+			wantStale:   true,
+		},
+		{
+			description: "fail past the max staleness",
+			image:       testImage,
+			age:         2 * time.Minute,
+			err:         daemonErr,
+			wantErr:     daemonErr,
+		},
+		{
+			description: "fail when the image fails",
+			image:       testImage,
+			age:         30 * time.Second,
+			err:         docker.ContainerRunError,
+			wantErr:     docker.ContainerRunError,
+		},
+		{
+			description: "fail when the execution is cancelled",
+			image:       testImage,
+			err:         docker.CancelledError,
+			wantErr:     docker.CancelledError,
+		},
+		{
+			description: "fail when the image is denied",
+			image:       testImage,
+			err:         &policy.DeniedError{Image: testImage, Reason: "denied"},
+			wantErr:     &policy.DeniedError{Image: testImage, Reason: "denied"},
+		},
+		{
+			description: "fail on client errors",
+			image:       testImage,
+			err:         common.HTTPError("invalid params", 400),
+			wantErr:     common.HTTPError("invalid params", 400),
+		},
+		{
+			description: "fail replays",
+			image:       testImage,
+			replay:      true,
+			age:         30 * time.Second,
+			err:         daemonErr,
+			wantErr:     daemonErr,
+		},
+		{
+			description: "fail for images not served stale",
+			image:       "registry.local/other:1",
+			err:         daemonErr,
+			wantErr:     daemonErr,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			next := docker.NewMockServiceInterface(ctrl)
+			store, err := New(Config{Images: []Rule{{Image: "tools/*", MaxStale: time.Minute}}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+			store.now = func() time.Time { return now }
+			service := NewService(next, store)
+			gomock.InOrder(
+				next.EXPECT().RunContainer(test.image, []string{"a=1"}, gomock.Any()).
+					Return([]byte("ok"), &docker.Headers{Header: map[string]string{"Content-Type": "text/plain"}}, nil),
+				next.EXPECT().RunContainer(test.image, []string{"a=1"}, gomock.Any()).Return(nil, nil, test.err),
+			)
+
+			_, _, err = service.RunContainer(test.image, []string{"a=1"}, context.Background())
+			assert.NoError(t, err)
+			now = now.Add(test.age)
+			var hidden error
+			ctx := docker.WithServedStale(context.Background(), func(err error) { hidden = err })
+			if test.replay {
+				ctx = docker.WithReplay(ctx)
+			}
+			out, headers, err := service.RunContainer(test.image, []string{"a=1"}, ctx)
+			if !test.wantStale {
+				assert.Equal(t, test.wantErr, err)
+				assert.Nil(t, hidden)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.err, hidden)
+			assert.Equal(t, "ok", string(out))
+			assert.Equal(t, map[string]string{"Content-Type": "text/plain", WarningHeader: Warning, AgeHeader: "30"}, headers.Header)
+		})
+	}
+}
+
+func TestService_Inputs(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	next := docker.NewMockServiceInterface(ctrl)
+	store, err := New(Config{Images: []Rule{{Image: "tools/*", MaxStale: time.Minute}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := NewService(next, store)
+	next.EXPECT().RunContainer(testImage, []string{"a=1"}, gomock.Any()).Return([]byte("ok"), &docker.Headers{}, nil)
+	next.EXPECT().RunContainer(testImage, []string{"a=2"}, gomock.Any()).Return(nil, nil, daemonErr)
+	next.EXPECT().RunContainerPost(testImage, []string{"a=1"}, gomock.Any()).Return(nil, nil, daemonErr)
+
+	service.RunContainer(testImage, []string{"a=1"}, context.Background())
+	_, _, err = service.RunContainer(testImage, []string{"a=2"}, context.Background())
+	assert.Equal(t, daemonErr, err)
+	_, _, err = service.RunContainerPost(testImage, []string{"a=1"}, context.Background())
+	assert.Equal(t, daemonErr, err)
+}
+
+func TestStore_MaxEntries(t *testing.T) {
+	store, err := New(Config{MaxEntries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Set("a", []byte("a"), nil, time.Minute)
+	store.Set("b", []byte("b"), nil, time.Minute)
+	store.Set("a", []byte("a"), nil, time.Minute)
+	store.Set("c", []byte("c"), nil, time.Minute)
+	_, _, ok := store.Get("b")
+	assert.False(t, ok)
+	_, _, ok = store.Get("a")
+	assert.True(t, ok)
+	_, err = New(Config{Images: []Rule{{Image: "tools/*"}}})
+	assert.Error(t, err)
+
+	store, err = New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, DefaultMaxEntries, store.maxEntries)
+}
+
+func TestStore_Expired(t *testing.T) {
+	store, err := New(Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+	store.Set("short", []byte("short"), nil, time.Minute)
+	store.Set("long", []byte("long"), nil, time.Hour)
+	store.Set("other", []byte("other"), nil, time.Minute)
+
+	now = now.Add(2 * time.Minute)
+	_, _, ok := store.Get("other")
+	assert.False(t, ok)
+	// the expired outputs are removed on the next store
+	store.Set("new", []byte("new"), nil, time.Minute)
+	assert.Len(t, store.entries, 2)
+	output, age, ok := store.Get("long")
+	assert.True(t, ok)
+	assert.Equal(t, "long", string(output.Body))
+	assert.Equal(t, 2*time.Minute, age)
+}
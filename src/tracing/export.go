@@ -0,0 +1,100 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+
+	"docker-operator/config"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.uber.org/zap"
+)
+
+// LoadExporter builds the exporter configured in the environment, nil when tracing is disabled.
+func LoadExporter() (sdktrace.SpanExporter, error) {
+	switch exporter := config.DefaultConfig.GetString("TRACING_EXPORTER"); exporter {
+	case "":
+		return nil, nil
+	case "otlp":
+		endpoint := config.DefaultConfig.GetString("TRACING_OTLP_ENDPOINT")
+		if endpoint == "" {
+			return nil, fmt.Errorf("TRACING_OTLP_ENDPOINT is required by the otlp exporter")
+		}
+		return otlptracehttp.New(context.Background(), otlpOptions(endpoint)...)
+	case "file":
+		path := config.DefaultConfig.GetString("TRACING_FILE")
+		if path == "" {
+			return nil, fmt.Errorf("TRACING_FILE is required by the file exporter")
+		}
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("could not open trace file %s: %w", path, err)
+		}
+		return stdouttrace.New(stdouttrace.WithWriter(file))
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", exporter)
+	}
+}
+
+// otlpOptions sends the spans to the endpoint, e.g. "http://collector:4318/v1/traces".
+func otlpOptions(endpoint string) []otlptracehttp.Option {
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint)}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return options
+	}
+	options = []otlptracehttp.Option{otlptracehttp.WithEndpoint(u.Host)}
+	if u.Path != "" {
+		options = append(options, otlptracehttp.WithURLPath(u.Path))
+	}
+	if u.Scheme == "http" {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	return options
+}
+
+// SetExporter enables tracing, the ended spans are sent to the exporter in batches.
+func SetExporter(exporter sdktrace.SpanExporter) {
+	service := config.DefaultConfig.GetString("TRACING_SERVICE_NAME")
+	if service == "" {
+		service = "docker-operator"
+	}
+	p := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", service))),
+	)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		zap.S().Errorw("could not export spans", "error", err)
+	}))
+	globalMu.Lock()
+	defer globalMu.Unlock()
+	provider = p
+}
+
+// Flush exports the spans ended so far.
+func Flush() {
+	if p := current(); p != nil {
+		if err := p.ForceFlush(context.Background()); err != nil {
+			zap.S().Errorw("could not export spans", "error", err)
+		}
+	}
+}
+
+// Shutdown exports the spans ended so far and disables tracing.
+func Shutdown(ctx context.Context) error {
+	globalMu.Lock()
+	p := provider
+	provider = nil
+	globalMu.Unlock()
+	if p == nil {
+		return nil
+	}
+	return p.Shutdown(ctx)
+}
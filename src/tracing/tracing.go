@@ -0,0 +1,103 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Span kinds of the spans started by the operator.
+const (
+	KindInternal = trace.SpanKindInternal
+	KindServer   = trace.SpanKindServer
+)
+
+const instrumentation = "docker-operator"
+
+// propagator reads and writes the W3C traceparent header.
+var propagator = propagation.TraceContext{}
+
+// Span is a timed step of a trace. A nil span is valid and records nothing,
+// which is what Start returns when tracing is disabled.
+type Span struct {
+	span trace.Span
+}
+
+var (
+	globalMu sync.RWMutex
+	provider *sdktrace.TracerProvider
+)
+
+func current() *sdktrace.TracerProvider {
+	globalMu.RLock()
+	defer globalMu.RUnlock()
+	return provider
+}
+
+// ContextWithTraceParent continues the trace of a W3C traceparent header, e.g.
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01". Invalid headers are ignored.
+func ContextWithTraceParent(ctx context.Context, traceparent string) context.Context {
+	return propagator.Extract(ctx, propagation.MapCarrier{"traceparent": traceparent})
+}
+
+// TraceParent returns the traceparent header of the current span of the context,
+// or of the remote parent when tracing is disabled, empty when there is none.
+func TraceParent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// Start starts a span as child of the span of the context. When tracing is
+// disabled, the context is returned unchanged with a nil span.
+func Start(ctx context.Context, name string, kind trace.SpanKind) (context.Context, *Span) {
+	p := current()
+	if p == nil {
+		return ctx, nil
+	}
+	ctx, span := p.Tracer(instrumentation).Start(ctx, name, trace.WithSpanKind(kind))
+	return ctx, &Span{span: span}
+}
+
+// SetAttribute records a string, bool or number describing the span.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.span.SetAttributes(toAttribute(key, value))
+}
+
+// End ends the span, marking it as failed when err is set.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}
+
+func toAttribute(key string, value interface{}) attribute.KeyValue {
+	switch typed := value.(type) {
+	case bool:
+		return attribute.Bool(key, typed)
+	case int:
+		return attribute.Int(key, typed)
+	case int64:
+		return attribute.Int64(key, typed)
+	case float64:
+		return attribute.Float64(key, typed)
+	case string:
+		return attribute.String(key, typed)
+	default:
+		return attribute.String(key, fmt.Sprint(typed))
+	}
+}
@@ -0,0 +1,64 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestStart(t *testing.T) {
+	ctx, span := Start(context.Background(), "disabled", KindServer)
+	assert.Nil(t, span)
+	assert.Equal(t, context.Background(), ctx)
+	span.SetAttribute("ignored", true)
+	span.End(nil)
+
+	exporter := tracetest.NewInMemoryExporter()
+	SetExporter(exporter)
+	defer Shutdown(context.Background())
+
+	parent := ContextWithTraceParent(context.Background(), "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, root := Start(parent, "GET /api/exec/*", KindServer)
+	root.SetAttribute("container.image.name", "registry.local/alpine:3.13")
+	root.SetAttribute("container.exit_code", int64(3))
+	_, pull := Start(ctx, "pull", KindInternal)
+	pull.End(errors.New("pull failed"))
+	root.End(nil)
+
+	unsampled := ContextWithTraceParent(context.Background(), "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	_, skipped := Start(unsampled, "GET /api/exec/*", KindServer)
+	skipped.End(nil)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", TraceParent(unsampled))
+
+	Flush()
+	spans := exporter.GetSpans()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, "pull", spans[0].Name)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].SpanContext.TraceID().String())
+	assert.Equal(t, spans[1].SpanContext.SpanID(), spans[0].Parent.SpanID())
+	assert.Equal(t, codes.Error, spans[0].Status.Code)
+	assert.Equal(t, "pull failed", spans[0].Status.Description)
+	assert.Equal(t, "00f067aa0ba902b7", spans[1].Parent.SpanID().String())
+	assert.Equal(t, []attribute.KeyValue{
+		attribute.String("container.image.name", "registry.local/alpine:3.13"),
+		attribute.Int64("container.exit_code", 3),
+	}, spans[1].Attributes)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+spans[1].SpanContext.SpanID().String()+"-01", TraceParent(ctx))
+}
+
+func TestContextWithTraceParent(t *testing.T) {
+	for _, header := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-xyz-01",
+	} {
+		assert.Equal(t, "", TraceParent(ContextWithTraceParent(context.Background(), header)), header)
+	}
+}
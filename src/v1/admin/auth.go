@@ -0,0 +1,34 @@
+package admin
+
+import (
+	"docker-operator/log"
+	"docker-operator/src/auth"
+	"docker-operator/src/common"
+	"docker-operator/src/rbac"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Require only lets requests through whose caller holds the admin token or is
+// granted the capability by the authorization policy.
+func Require(policy *rbac.Policy, capability string) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		identity := auth.FromContext(c)
+		if identity != nil && identity.Method == auth.AdminTokenMethod {
+			return c.Next()
+		}
+		reason := "the admin token is required"
+		if policy != nil {
+			err := policy.Authorize(rbac.Request{Identity: identity, Capability: capability})
+			if err == nil {
+				return c.Next()
+			}
+			reason = err.(*rbac.DeniedError).Reason
+		}
+		log.FromContext(c.UserContext()).Warnw("request denied by authorization policy", "caller", identity, "capability", capability, "path", c.Path(), "reason", reason)
+		return common.ErrorJSON(c, fiber.StatusForbidden, fiber.Map{
+			"msg":    "forbidden",
+			"reason": reason,
+		})
+	}
+}
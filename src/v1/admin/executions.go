@@ -0,0 +1,28 @@
+package admin
+
+import (
+	"docker-operator/src/common"
+	"docker-operator/src/execution"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ListExecutions lists the executions in flight.
+func ListExecutions(executions *execution.Registry) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(executions.List())
+	}
+}
+
+// CancelExecution kills the container of an execution, the waiting request fails with a cancellation error.
+func CancelExecution(executions *execution.Registry) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		cancelled, err := executions.Cancel(c.Params("id"))
+		if err == execution.NotFoundError {
+			return common.ErrorJSON(c, fiber.StatusNotFound, fiber.Map{
+				"msg": err.Error(),
+			})
+		}
+		return c.JSON(cancelled)
+	}
+}
@@ -0,0 +1,140 @@
+package admin
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"docker-operator/log"
+	"docker-operator/src/common"
+	dockerservice "docker-operator/src/docker"
+	"docker-operator/src/imagecache"
+	"docker-operator/src/registry"
+	"docker-operator/src/v1/docker"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/gofiber/fiber/v2"
+)
+
+type image struct {
+	ID       string     `json:"id"`
+	Tags     []string   `json:"tags"`
+	Digests  []string   `json:"digests"`
+	Size     int64      `json:"size"`
+	Created  time.Time  `json:"created"`
+	LastUsed *time.Time `json:"last_used,omitempty"`
+}
+
+type latestTag struct {
+	Image       string `json:"image"`
+	Registry    string `json:"registry"`
+	ResolvedTag string `json:"resolved_tag"`
+}
+
+// ListImages lists the images on the docker host. The last use is known for
+// the images tracked by the image cache.
+func ListImages(dockerClient dockerservice.ClientInterface, cache *imagecache.Cache) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		summaries, err := dockerClient.ImageList(context.Background(), types.ImageListOptions{})
+		if err != nil {
+			log.FromContext(c.UserContext()).Errorw("could not list images", "error", err)
+			return docker.ErrorResponse(c, err)
+		}
+		lastUsed := make(map[string]time.Time)
+		if cache != nil {
+			for _, entry := range cache.Entries() {
+				if entry.LastUsed.After(lastUsed[entry.ID]) {
+					lastUsed[entry.ID] = entry.LastUsed
+				}
+			}
+		}
+		images := make([]image, 0, len(summaries))
+		for _, summary := range summaries {
+			img := image{
+				ID:      summary.ID,
+				Tags:    summary.RepoTags,
+				Digests: summary.RepoDigests,
+				Size:    summary.Size,
+				Created: time.Unix(summary.Created, 0).UTC(),
+			}
+			if used, ok := lastUsed[summary.ID]; ok {
+				img.LastUsed = &used
+			}
+			images = append(images, img)
+		}
+		sort.Slice(images, func(i, j int) bool {
+			return images[i].Created.After(images[j].Created)
+		})
+		return c.JSON(images)
+	}
+}
+
+// PullImage pulls the image addressed by the route, again if it already exists.
+// The registry can be named with the registry query parameter.
+func PullImage(dockerService dockerservice.ServiceInterface, registries *registry.Registries) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		reg, ref, err := docker.ResolveImage(c.Params("*"), c.Query("registry"), registries)
+		if err != nil {
+			return docker.ErrorResponse(c, err)
+		}
+		reference := reg.Reference(ref.Name, ref.Tag, ref.Digest)
+		if err := dockerService.PullImage(reference, context.Background()); err != nil {
+			return docker.ErrorResponse(c, err)
+		}
+		digest, _ := dockerService.ImageDigest(reference, context.Background())
+		log.FromContext(c.UserContext()).Infow("pulled image through admin api", "image", reference, "digest", digest)
+		return c.JSON(fiber.Map{
+			"image":  reference,
+			"digest": digest,
+		})
+	}
+}
+
+// RemoveImage removes the image addressed by the route from the docker host.
+func RemoveImage(dockerClient dockerservice.ClientInterface, registries *registry.Registries, cache *imagecache.Cache) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		reg, ref, err := docker.ResolveImage(c.Params("*"), c.Query("registry"), registries)
+		if err != nil {
+			return docker.ErrorResponse(c, err)
+		}
+		reference := reg.Reference(ref.Name, ref.Tag, ref.Digest)
+		deleted, err := dockerClient.ImageRemove(context.Background(), reference, types.ImageRemoveOptions{
+			Force:         c.Query("force") == "true",
+			PruneChildren: true,
+		})
+		if err != nil {
+			if client.IsErrNotFound(err) {
+				return docker.ErrorResponse(c, common.HTTPError(err, fiber.StatusNotFound))
+			}
+			return docker.ErrorResponse(c, err)
+		}
+		if cache != nil {
+			cache.Forget(reference)
+		}
+		log.FromContext(c.UserContext()).Infow("removed image through admin api", "image", reference)
+		return c.JSON(fiber.Map{
+			"image":   reference,
+			"deleted": deleted,
+		})
+	}
+}
+
+// LatestTag shows the tag latest currently resolves to for the image named by the route.
+func LatestTag(registries *registry.Registries) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		reg, name, err := docker.ResolveImageName(c.Params("*"), c.Query("registry"), registries)
+		if err != nil {
+			return docker.ErrorResponse(c, err)
+		}
+		tag, err := reg.LatestTag(name)
+		if err != nil {
+			return docker.ErrorResponse(c, common.HTTPError(err, fiber.StatusBadGateway, "could not resolve latest"))
+		}
+		return c.JSON(latestTag{
+			Image:       name,
+			Registry:    reg.Name,
+			ResolvedTag: tag,
+		})
+	}
+}
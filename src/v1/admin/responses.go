@@ -0,0 +1,19 @@
+package admin
+
+import (
+	"docker-operator/log"
+	"docker-operator/src/responsecache"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// PurgeResponses removes the cached outputs of the images matching the image
+// query parameter, every cached output without it.
+func PurgeResponses(cache *responsecache.Cache) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		image := c.Query("image")
+		purged := cache.Purge(image)
+		log.FromContext(c.UserContext()).Infow("purged cached outputs through admin api", "image", image, "purged", purged)
+		return c.JSON(fiber.Map{"purged": purged})
+	}
+}
@@ -0,0 +1,90 @@
+package audit
+
+import (
+	"encoding/json"
+	"time"
+
+	"docker-operator/log"
+	auditlog "docker-operator/src/audit"
+	"docker-operator/src/auth"
+	"docker-operator/src/registry"
+	"docker-operator/src/v1/docker"
+	"docker-operator/src/v1/idempotency"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Record writes every exec request to the audit log once it is answered,
+// including the requests turned away before the image was run.
+func Record(auditLog *auditlog.Log, registries *registry.Registries) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		err := c.Next()
+
+		entry := auditlog.Entry{
+			Time:       start,
+			Caller:     "anonymous",
+			Client:     c.IP(),
+			Method:     c.Method(),
+			Image:      c.Params("*"),
+			ParamsHash: docker.ParamsHash(c),
+			Status:     c.Response().StatusCode(),
+			DurationMs: time.Since(start).Milliseconds(),
+		}
+		if identity := auth.FromContext(c); identity != nil {
+			entry.Caller = identity.Name
+		}
+		if execution, ok := c.Locals(docker.ExecutionLocal).(string); ok {
+			entry.Execution = execution
+		}
+		if err != nil {
+			entry.Status = fiber.StatusInternalServerError
+			entry.Error = err.Error()
+		} else if entry.Status >= fiber.StatusBadRequest {
+			var body struct {
+				Msg string `json:"msg"`
+			}
+			if json.Unmarshal(c.Response().Body(), &body) == nil {
+				entry.Error = body.Msg
+			}
+		}
+		entry.Outcome = outcome(c, entry.Status)
+		if reg, ref, resolveErr := docker.ResolveImage(c.Params("*"), c.Params("registry"), registries); resolveErr == nil {
+			entry.Image = reg.Reference(ref.Name, ref.Tag, ref.Digest)
+			entry.Digest = ref.Digest
+		}
+		// the digest reported by the run, the image may have been pulled since
+		if digest, ok := c.Locals(docker.DigestLocal).(string); ok {
+			entry.Digest = digest
+		}
+		if appendErr := auditLog.Append(entry); appendErr != nil {
+			log.FromContext(c.UserContext()).Errorw("could not write audit log", "execution", entry.Execution, "error", appendErr)
+		}
+		return err
+	}
+}
+
+func outcome(c *fiber.Ctx, status int) string {
+	cancelled, _ := c.Locals(docker.CancelledLocal).(bool)
+	inProgress, _ := c.Locals(idempotency.InProgressLocal).(bool)
+	switch {
+	case cancelled:
+		return auditlog.OutcomeCancelled
+	case inProgress:
+		return auditlog.OutcomeInProgress
+	case status < fiber.StatusBadRequest:
+		return auditlog.OutcomeSuccess
+	case status == fiber.StatusUnauthorized:
+		return auditlog.OutcomeUnauthenticated
+	case status == fiber.StatusForbidden:
+		return auditlog.OutcomeDenied
+	case status == fiber.StatusTooManyRequests:
+		return auditlog.OutcomeRateLimited
+	case status == fiber.StatusBadRequest:
+		return auditlog.OutcomeInvalid
+	case status == fiber.StatusNotFound:
+		return auditlog.OutcomeNotFound
+	default:
+		return auditlog.OutcomeError
+	}
+}
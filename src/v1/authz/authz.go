@@ -0,0 +1,77 @@
+package authz
+
+import (
+	"strings"
+
+	"docker-operator/log"
+	"docker-operator/src/auth"
+	"docker-operator/src/rbac"
+	"docker-operator/src/registry"
+	"docker-operator/src/v1/docker"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Authorize checks the image of an exec request against the authorization
+// policy before the request reaches the docker service.
+func Authorize(policy *rbac.Policy, registries *registry.Registries) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		reg, ref, err := docker.ResolveImage(c.Params("*"), c.Params("registry"), registries)
+		if err != nil {
+			return docker.ErrorResponse(c, err)
+		}
+		req := rbac.Request{
+			Identity: auth.FromContext(c),
+			Method:   c.Method(),
+			Image:    reg.Reference(ref.Name, ref.Tag, ref.Digest),
+		}
+		if err := policy.Authorize(req); err != nil {
+			log.FromContext(c.UserContext()).Warnw("request denied by authorization policy", "caller", req.Identity, "method", req.Method, "image", req.Image, "reason", err.(*rbac.DeniedError).Reason)
+			return docker.ErrorResponse(c, err)
+		}
+		return c.Next()
+	}
+}
+
+// Explain evaluates an exec request without running it, for the caller or,
+// given the authz:explain capability, for the identity and groups in the query.
+// The method is read from the query and defaults to GET.
+func Explain(policy *rbac.Policy, registries *registry.Registries) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		identity := auth.FromContext(c)
+		if name := c.Query("identity"); name != "" {
+			if err := mayExplainFor(policy, identity); err != nil {
+				return docker.ErrorResponse(c, err)
+			}
+			identity = &auth.Identity{Name: name}
+			if groups := c.Query("groups"); groups != "" {
+				identity.Groups = strings.Split(groups, ",")
+			}
+		}
+		req := rbac.Request{
+			Identity:   identity,
+			Method:     strings.ToUpper(c.Query("method", fiber.MethodGet)),
+			Capability: c.Query("capability"),
+		}
+		if req.Capability == "" {
+			reg, ref, err := docker.ResolveImage(c.Params("*"), c.Query("registry"), registries)
+			if err != nil {
+				return docker.ErrorResponse(c, err)
+			}
+			req.Image = reg.Reference(ref.Name, ref.Tag, ref.Digest)
+		} else {
+			req.Method = ""
+		}
+		return c.JSON(fiber.Map{
+			"request":  req,
+			"decision": policy.Evaluate(req),
+		})
+	}
+}
+
+func mayExplainFor(policy *rbac.Policy, identity *auth.Identity) error {
+	if identity != nil && identity.Method == auth.AdminTokenMethod {
+		return nil
+	}
+	return policy.Authorize(rbac.Request{Identity: identity, Capability: rbac.AuthzExplain})
+}
@@ -0,0 +1,68 @@
+package docker
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// sendOutput answers with the output of a container and its headers. The
+// SHA-256 hash of the output is its ETag unless the image emits one, and GET
+// requests whose validators still match are answered with 304 Not Modified.
+func sendOutput(c *fiber.Ctx, out []byte, headers map[string]string, hash string) error {
+	for key, value := range headers {
+		c.Set(key, value)
+	}
+	etag := headerValue(headers, fiber.HeaderETag)
+	if etag == "" {
+		etag = `"` + hash + `"`
+		c.Set(fiber.HeaderETag, etag)
+	}
+	if c.Method() == fiber.MethodGet && notModified(c, etag, headerValue(headers, fiber.HeaderLastModified)) {
+		c.Status(fiber.StatusNotModified)
+		return nil
+	}
+	return c.Send(out)
+}
+
+// notModified evaluates If-None-Match, and If-Modified-Since when the request
+// has no If-None-Match and the image emits Last-Modified.
+func notModified(c *fiber.Ctx, etag, lastModified string) bool {
+	if noneMatch := c.Get(fiber.HeaderIfNoneMatch); noneMatch != "" {
+		for _, candidate := range strings.Split(noneMatch, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || weakTag(candidate) == weakTag(etag) {
+				return true
+			}
+		}
+		return false
+	}
+	modifiedSince := c.Get(fiber.HeaderIfModifiedSince)
+	if modifiedSince == "" || lastModified == "" {
+		return false
+	}
+	since, err := http.ParseTime(modifiedSince)
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(lastModified)
+	if err != nil {
+		return false
+	}
+	return !modified.After(since)
+}
+
+// weakTag drops the weak indicator, If-None-Match uses the weak comparison.
+func weakTag(etag string) string {
+	return strings.TrimPrefix(etag, "W/")
+}
+
+func headerValue(headers map[string]string, name string) string {
+	for key, value := range headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
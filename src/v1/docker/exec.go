@@ -2,153 +2,209 @@ package docker
 
 import (
 	"context"
-	"crypto/md5"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
-	"sort"
 	"time"
 
 	"docker-operator/config"
+	"docker-operator/log"
+	"docker-operator/src/admission"
+	"docker-operator/src/artifacts"
+	"docker-operator/src/auth"
+	"docker-operator/src/common"
 	"docker-operator/src/docker"
+	"docker-operator/src/execution"
+	"docker-operator/src/history"
+	"docker-operator/src/policy"
+	"docker-operator/src/rbac"
+	"docker-operator/src/redact"
+	"docker-operator/src/registry"
+	"docker-operator/src/tracing"
 
 	"github.com/gofiber/fiber/v2"
-	"go.uber.org/zap"
 )
 
+// ExecutionLocal holds the id of the execution of an exec request.
+const ExecutionLocal = "execution"
+
+// DigestLocal holds the digest of the image the execution of an exec request ran.
+const DigestLocal = "digest"
+
+// CancelledLocal is set when the execution of an exec request was cancelled.
+const CancelledLocal = "cancelled"
+
+// ExecutionHeader is the response header holding the id of the execution of an exec request.
+const ExecutionHeader = "X-Execution-ID"
+
 type event struct {
+	Execution          string            `json:"execution"`
+	Caller             *auth.Identity    `json:"caller,omitempty"`
+	Registry           string            `json:"registry"`
 	Image              string            `json:"image"`
 	Tag                string            `json:"tag"`
+	Digest             string            `json:"digest,omitempty"`
 	RequestTime        time.Time         `json:"request_time"`
 	Params             []string          `json:"params"`
+	ParamsHash         string            `json:"params_hash"`
+	ReplayOf           string            `json:"replay_of,omitempty"`
+	CoalescedWith      string            `json:"coalesced_with,omitempty"`
+	Stale              string            `json:"stale,omitempty"`
 	Method             string            `json:"method"`
 	ResponseTime       time.Time         `json:"response_time"`
 	ImageExistsInLocal bool              `json:"image_exists_in_local"`
 	Headers            map[string]string `json:"headers,omitempty"`
 	Error              string            `json:"error,omitempty"`
-	ContentMD5         []byte            `json:"content_md5,omitempty"`
+	ContentSHA256      string            `json:"content_sha256,omitempty"`
 	Content            string            `json:"content,omitempty"`
 }
 
-type imageTag struct {
-	Name string   `json:"name"`
-	Tags []string `json:"tags"`
-}
-
-func RunContainerGet(dockerService docker.ServiceInterface) func(c *fiber.Ctx) error {
+func RunContainerGet(dockerService docker.ServiceInterface, registries *registry.Registries, executions *execution.Registry, records *history.Store, archive *artifacts.Store) func(c *fiber.Ctx) error {
+	x := &executor{service: dockerService, registries: registries, executions: executions, records: records, archive: archive}
 	return func(c *fiber.Ctx) error {
 		var params []string
 		ctx := c.Context()
-		registry := config.DefaultConfig.GetString("REGISTRY")
 		query := ctx.QueryArgs().String()
 		if query != "" {
 			params = append(params, query)
 		}
-		imageName := c.Params("image_name")
-		tag := c.Params("tag")
-		image := fmt.Sprintf("%s/%s:%s", registry, imageName, tag)
-		if tag == "latest" {
-			originalTag, _ := getOriginalTag(registry, imageName)
-			tag = originalTag
-		}
-		exists := dockerService.ImageExists(image, context.Background())
-		event := event{
-			Image:              imageName,
-			Tag:                tag,
-			RequestTime:        time.Now(),
-			Params:             params,
-			Method:             c.Method(),
-			ImageExistsInLocal: exists,
-		}
-		out, header, err := dockerService.RunContainer(image, params, context.Background())
-		if err != nil {
-			logRequestAndResponse(event, err.Error(), nil)
-			if err == docker.NotFoundError {
-				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-					"error": true,
-					"msg":   err.Error(),
-				})
-			}
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": true,
-				"msg":   err.Error(),
-			})
-		}
-		err = c.Send(out)
-		for key, value := range header.Header {
-			c.Set(key, value)
-		}
-		event.ContentMD5 = getMD5Hash(out)
-		event.Content = firstNCharacter(string(out))
-		logRequestAndResponse(event, "", header.Header)
-		return err
+		return x.exec(c, params)
 	}
 }
 
-func RunContainerPost(dockerService docker.ServiceInterface) func(c *fiber.Ctx) error {
+func RunContainerPost(dockerService docker.ServiceInterface, registries *registry.Registries, executions *execution.Registry, records *history.Store, archive *artifacts.Store) func(c *fiber.Ctx) error {
+	x := &executor{service: dockerService, registries: registries, executions: executions, records: records, archive: archive}
 	return func(c *fiber.Ctx) error {
 		requestBody := c.Body()
-		registry := config.DefaultConfig.GetString("REGISTRY")
 		var params []string
 		if string(requestBody) != "" {
 			params = append(params, fmt.Sprintf("POST_DATA=%s", string(requestBody)))
 		}
-		imageName := c.Params("image_name")
-		tag := c.Params("tag")
-		image := fmt.Sprintf("%s/%s:%s", registry, imageName, tag)
-		exists := dockerService.ImageExists(image, context.Background())
-		if tag == "latest" {
-			originalTag, _ := getOriginalTag(registry, imageName)
-			tag = originalTag
-		}
-		event := event{
-			Image:              imageName,
-			RequestTime:        time.Now(),
-			Params:             params,
-			Method:             c.Method(),
-			ImageExistsInLocal: exists,
-		}
-		out, header, err := dockerService.RunContainerPost(image, params, context.Background())
-		if err != nil {
-			logRequestAndResponse(event, err.Error(), nil)
-			if err == docker.NotFoundError {
-				return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-					"error": true,
-					"msg":   err.Error(),
-				})
-			}
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": true,
-				"msg":   err.Error(),
-			})
-		}
-		err = c.Send(out)
-		for key, value := range header.Header {
-			c.Set(key, value)
-		}
-		event.ContentMD5 = getMD5Hash(out)
-		event.Content = firstNCharacter(string(out))
-		event.Tag = tag
-		logRequestAndResponse(event, "", header.Header)
-		return err
+		return x.exec(c, params)
+	}
+}
+
+// exec runs the image of the request with the params and answers with its output.
+func (x *executor) exec(c *fiber.Ctx, params []string) error {
+	reg, ref, err := ResolveImage(c.Params("*"), c.Params("registry"), x.registries)
+	if err != nil {
+		return ErrorResponse(c, err)
 	}
+	image := reg.Reference(ref.Name, ref.Tag, ref.Digest)
+	tag := ref.Tag
+	if tag == "latest" {
+		originalTag, _ := reg.LatestTag(ref.Name)
+		tag = originalTag
+	}
+	event := event{
+		Caller:             auth.FromContext(c),
+		Registry:           reg.Name,
+		Image:              ref.Name,
+		Tag:                tag,
+		Digest:             ref.Digest,
+		Params:             params,
+		ParamsHash:         ParamsHash(c),
+		Method:             c.Method(),
+		ImageExistsInLocal: x.service.ImageExists(image, c.UserContext()),
+	}
+	out, header, err := x.runExecution(c, image, params, &event)
+	if err != nil {
+		return ErrorResponse(c, err)
+	}
+	return sendOutput(c, out, header.Header, event.ContentSHA256)
 }
 
-func logRequestAndResponse(event event, err string, header map[string]string) {
-	event.Error = err
+// ErrorResponse maps an error of the docker service to its response.
+func ErrorResponse(c *fiber.Ctx, err error) error {
+	var httpErr common.HttpError
+	if errors.As(err, &httpErr) {
+		return common.ErrorJSON(c, httpErr.StatusCode(), fiber.Map{
+			"msg": err.Error(),
+		})
+	}
+	var denied *policy.DeniedError
+	if errors.As(err, &denied) {
+		return common.ErrorJSON(c, fiber.StatusForbidden, fiber.Map{
+			"msg":    err.Error(),
+			"reason": denied.Reason,
+		})
+	}
+	var unauthorized *rbac.DeniedError
+	if errors.As(err, &unauthorized) {
+		return common.ErrorJSON(c, fiber.StatusForbidden, fiber.Map{
+			"msg":    err.Error(),
+			"reason": unauthorized.Reason,
+		})
+	}
+	var rejected *admission.RejectedError
+	if errors.As(err, &rejected) {
+		return common.ErrorJSON(c, fiber.StatusForbidden, fiber.Map{
+			"msg":        err.Error(),
+			"violations": rejected.Violations,
+		})
+	}
+	if errors.Is(err, docker.CancelledError) {
+		return common.ErrorJSON(c, fiber.StatusConflict, fiber.Map{
+			"msg": err.Error(),
+		})
+	}
+	if err == docker.NotFoundError {
+		return common.ErrorJSON(c, fiber.StatusNotFound, fiber.Map{
+			"msg": err.Error(),
+		})
+	}
+	var daemonErr *docker.DaemonError
+	if errors.As(err, &daemonErr) {
+		return common.ErrorJSON(c, fiber.StatusServiceUnavailable, fiber.Map{
+			"msg": err.Error(),
+		})
+	}
+	return common.ErrorJSON(c, fiber.StatusInternalServerError, fiber.Map{
+		"msg": err.Error(),
+	})
+}
+
+// startSpan starts the span of an execution, continuing the trace of the
+// traceparent header of the request when there is one.
+func startSpan(c *fiber.Ctx, ctx context.Context, image, execution string) (context.Context, *tracing.Span) {
+	ctx = tracing.ContextWithTraceParent(ctx, c.Get("traceparent"))
+	ctx, span := tracing.Start(ctx, c.Method()+" "+c.Route().Path, tracing.KindServer)
+	span.SetAttribute("http.method", c.Method())
+	span.SetAttribute("url.path", c.Path())
+	span.SetAttribute("container.image.name", image)
+	span.SetAttribute("execution.id", execution)
+	return ctx, span
+}
+
+// cancellationError reports any failure of a cancelled execution as a cancellation,
+// as it may be cancelled before its container is created.
+func cancellationError(executions *execution.Registry, exec *execution.Execution, err error) error {
+	if executions.IsCancelled(exec) {
+		return fmt.Errorf("%w: %s", docker.CancelledError, exec.ID)
+	}
+	return err
+}
+
+// setOutput fingerprints the output and keeps its beginning, unless the content
+// of the image must not be logged.
+func (e *event) setOutput(ctx context.Context, image string, out []byte) {
+	e.ContentSHA256 = outputHash(out)
+	if redact.FromContext(ctx).LogContent(image) {
+		e.Content = firstNCharacter(string(out))
+	}
+}
+
+func logRequestAndResponse(ctx context.Context, event event, err string, header map[string]string) {
+	redactor := redact.FromContext(ctx)
+	event.Params = redactor.Params(event.Params)
+	event.Content = redactor.String(event.Content)
+	event.Error = redactor.String(err)
 	event.ResponseTime = time.Now()
-	event.Headers = header
-	zap.S().With(
+	event.Headers = redactor.Headers(header)
+	log.FromContext(ctx).With(
 		"request", event,
 	).Infow("incoming request")
 }
 
-func getMD5Hash(data []byte) []byte {
-	hash := md5.New()
-	hash.Write(data)
-	return hash.Sum(nil)
-}
-
 func firstNCharacter(data string) string {
 	n := config.DefaultConfig.GetInt("CONTENT_LENGTH")
 	if len(data) < n {
@@ -156,19 +212,3 @@ func firstNCharacter(data string) string {
 	}
 	return data[0:n]
 }
-
-func getOriginalTag(repository, imageName string) (string, error) {
-	url := fmt.Sprintf(`https://%s/v2/%s/tags/list`, repository, imageName)
-	resp, err := http.Get(url)
-	if err != nil {
-		return "", err
-	}
-	imageTagResponse := &imageTag{}
-	err = json.NewDecoder(resp.Body).Decode(imageTagResponse)
-	if err != nil {
-		return "", err
-	}
-	tags := imageTagResponse.Tags
-	sort.Strings(tags)
-	return tags[len(tags)-2], nil
-}
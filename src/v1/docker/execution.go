@@ -0,0 +1,90 @@
+package docker
+
+import (
+	"errors"
+	"time"
+
+	"docker-operator/log"
+	"docker-operator/src/artifacts"
+	"docker-operator/src/docker"
+	"docker-operator/src/execution"
+	"docker-operator/src/history"
+	"docker-operator/src/registry"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// executor runs the images of the exec and replay requests.
+type executor struct {
+	service    docker.ServiceInterface
+	registries *registry.Registries
+	executions *execution.Registry
+	records    *history.Store
+	archive    *artifacts.Store
+}
+
+// runExecution registers the execution of the image for the request, runs it
+// with the hooks reporting its exit code, logs, the digest of its image and how
+// it was answered, then logs it and adds it to the history. The event of the execution is completed
+// along the way. The returned error is the one to answer with.
+func (x *executor) runExecution(c *fiber.Ctx, image string, params []string, event *event) ([]byte, *docker.Headers, error) {
+	requestID := log.RequestID(c.UserContext())
+	runCtx, exec, done := x.executions.Start(c.UserContext(), x.executionID(requestID), requestID, image, params, event.Method, c.IP())
+	defer done()
+	c.Locals(ExecutionLocal, exec.ID)
+	c.Set(ExecutionHeader, exec.ID)
+	runCtx, span := startSpan(c, runCtx, image, exec.ID)
+	var exitCode *int64
+	var coalescedWith, stale, digest string
+	runCtx = docker.WithContainerExited(runCtx, func(code int64) { exitCode = &code })
+	runCtx = archiveOutput(c, runCtx, x.archive, exec.ID)
+	runCtx = docker.WithRequestHeader(runCtx, c.Get)
+	runCtx = docker.WithExecutionJoined(runCtx, func(leader string) { coalescedWith = leader })
+	runCtx = docker.WithServedStale(runCtx, func(err error) { stale = err.Error() })
+	runCtx = docker.WithImageDigest(runCtx, func(d string) { digest = d })
+	if event.ReplayOf != "" {
+		runCtx = docker.WithReplay(runCtx)
+	}
+	event.Execution = exec.ID
+	event.RequestTime = time.Now()
+
+	run := x.service.RunContainer
+	if event.Method == fiber.MethodPost {
+		run = x.service.RunContainerPost
+	}
+	out, header, err := run(image, params, runCtx)
+	span.End(err)
+	event.CoalescedWith, event.Stale = coalescedWith, stale
+	if digest != "" {
+		event.Digest = digest
+		c.Locals(DigestLocal, digest)
+	}
+	if err != nil {
+		err = cancellationError(x.executions, exec, err)
+		if errors.Is(err, docker.CancelledError) {
+			c.Locals(CancelledLocal, true)
+		}
+		logRequestAndResponse(c.UserContext(), *event, err.Error(), nil)
+		recordExecution(c, x.records, *event, exitCode, nil, err)
+		return nil, nil, err
+	}
+	event.setOutput(c.UserContext(), image, out)
+	logRequestAndResponse(c.UserContext(), *event, "", header.Header)
+	recordExecution(c, x.records, *event, exitCode, out, nil)
+	return out, header, nil
+}
+
+// executionID names the execution after its request, unless an execution of the
+// history or of the archived logs already has that name, e.g. when a request is
+// sent again with the same id.
+func (x *executor) executionID(requestID string) string {
+	if x.records != nil {
+		if _, ok := x.records.Get(requestID); ok {
+			return ""
+		}
+	}
+	if x.archive != nil && x.archive.Exists(requestID) {
+		return ""
+	}
+	return requestID
+}
@@ -0,0 +1,94 @@
+package docker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"docker-operator/log"
+	"docker-operator/src/artifacts"
+	"docker-operator/src/docker"
+	"docker-operator/src/history"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// ParamsHash hashes the query and body the image is run with, so identical
+// executions can be told apart without storing their inputs.
+func ParamsHash(c *fiber.Ctx) string {
+	hash := sha256.New()
+	hash.Write(c.Context().QueryArgs().QueryString())
+	hash.Write([]byte{'\n'})
+	hash.Write(c.Body())
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// recordExecution adds the finished execution to the history, if any.
+func recordExecution(c *fiber.Ctx, records *history.Store, event event, exitCode *int64, out []byte, err error) {
+	if records == nil {
+		return
+	}
+	// the strings of the request are only valid until it is answered
+	record := history.Record{
+		ID:            utils.CopyString(event.Execution),
+		RequestID:     utils.CopyString(log.RequestID(c.UserContext())),
+		Time:          event.RequestTime,
+		Registry:      utils.CopyString(event.Registry),
+		Image:         utils.CopyString(event.Image),
+		Tag:           utils.CopyString(event.Tag),
+		Digest:        utils.CopyString(event.Digest),
+		Method:        event.Method,
+		Params:        event.Params,
+		ParamsHash:    event.ParamsHash,
+		ReplayOf:      event.ReplayOf,
+		CoalescedWith: event.CoalescedWith,
+		Caller:        "anonymous",
+		Status:        history.StatusSuccess,
+		ExitCode:      exitCode,
+		DurationMs:    time.Since(event.RequestTime).Milliseconds(),
+	}
+	if event.Caller != nil {
+		record.Caller = event.Caller.Name
+	}
+	switch {
+	case errors.Is(err, docker.CancelledError):
+		record.Status = history.StatusCancelled
+		record.Error = err.Error()
+	case err != nil:
+		record.Status = history.StatusError
+		record.Error = err.Error()
+	default:
+		record.OutputHash = outputHash(out)
+		record.OutputSize = len(out)
+	}
+	if event.Stale != "" {
+		// the request was answered with an earlier output, the execution itself failed
+		record.Status = history.StatusError
+		record.Stale = true
+		record.Error = event.Stale
+	}
+	if err := records.Add(record); err != nil {
+		log.FromContext(c.UserContext()).Errorw("could not write execution history", "execution", record.ID, "error", err)
+	}
+}
+
+// archiveOutput returns a context archiving the stdout and stderr of the
+// container of the execution, if there is an archive.
+func archiveOutput(c *fiber.Ctx, ctx context.Context, archive *artifacts.Store, id string) context.Context {
+	if archive == nil {
+		return ctx
+	}
+	return docker.WithContainerOutput(ctx, func(stdout, stderr []byte) {
+		if err := archive.Save(id, stdout, stderr); err != nil {
+			log.FromContext(ctx).Errorw("could not archive container logs", "execution", id, "error", err)
+		}
+	})
+}
+
+func outputHash(out []byte) string {
+	hash := sha256.Sum256(out)
+	return hex.EncodeToString(hash[:])
+}
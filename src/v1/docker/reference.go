@@ -0,0 +1,113 @@
+package docker
+
+import (
+	"fmt"
+	"strings"
+
+	"docker-operator/src/common"
+	"docker-operator/src/registry"
+
+	"github.com/docker/distribution/reference"
+	"github.com/gofiber/fiber/v2"
+	"github.com/opencontainers/go-digest"
+)
+
+// ImageRef is the image addressed by the path of a route.
+type ImageRef struct {
+	Name   string
+	Tag    string
+	Digest string
+}
+
+// ParseImagePath parses the image path of a route, which is either a repository
+// path followed by a tag ("team/project/tool/1.0") or a repository path pinned
+// to a digest ("team/project/tool@sha256:..."). The repository path has to follow
+// the docker reference grammar and must not name a registry host.
+func ParseImagePath(path string) (ImageRef, error) {
+	var ref ImageRef
+	if i := strings.LastIndex(path, "@"); i >= 0 {
+		ref.Name = path[:i]
+		d, err := digest.Parse(path[i+1:])
+		if err != nil {
+			return ImageRef{}, fmt.Errorf("invalid digest %q: %w", path[i+1:], err)
+		}
+		ref.Digest = d.String()
+	} else {
+		i := strings.LastIndex(path, "/")
+		if i < 0 {
+			return ImageRef{}, fmt.Errorf("image path %q has no tag", path)
+		}
+		ref.Name, ref.Tag = path[:i], path[i+1:]
+		if ref.Tag == "" {
+			return ImageRef{}, fmt.Errorf("image path %q has no tag", path)
+		}
+	}
+
+	named, err := ParseImageName(ref.Name)
+	if err != nil {
+		return ImageRef{}, err
+	}
+	if ref.Tag != "" {
+		if _, err := reference.WithTag(named, ref.Tag); err != nil {
+			return ImageRef{}, fmt.Errorf("invalid tag %q: %w", ref.Tag, err)
+		}
+	}
+	return ref, nil
+}
+
+// ParseImageName validates a repository path against the docker reference grammar.
+// The tag or digest of the image is part of the route, never of its name.
+func ParseImageName(name string) (reference.Named, error) {
+	// same rule docker uses to tell a registry host from the first path component
+	if first := strings.SplitN(name, "/", 2)[0]; strings.ContainsAny(first, ".:") || first == "localhost" {
+		return nil, fmt.Errorf("invalid image name %q: registry host %s is not allowed", name, first)
+	}
+	// without a registry host the whole name is validated as repository path
+	named, err := reference.ParseNormalizedNamed(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image name %q: %w", name, err)
+	}
+	if _, ok := named.(reference.Tagged); ok {
+		return nil, fmt.Errorf("invalid image name %q: a tag is not allowed in the name", name)
+	}
+	if _, ok := named.(reference.Digested); ok {
+		return nil, fmt.Errorf("invalid image name %q: a digest is not allowed in the name", name)
+	}
+	return named, nil
+}
+
+// ResolveImage parses an image path and picks the named registry, or routes the
+// image name by prefix when no registry is named. The returned image name is the
+// one to use in the picked registry.
+func ResolveImage(path, registryName string, registries *registry.Registries) (*registry.Registry, ImageRef, error) {
+	ref, err := ParseImagePath(path)
+	if err != nil {
+		return nil, ImageRef{}, common.HTTPError(err, fiber.StatusBadRequest)
+	}
+	reg, name, err := route(ref.Name, registryName, registries)
+	if err != nil {
+		return nil, ImageRef{}, err
+	}
+	ref.Name = name
+	return reg, ref, nil
+}
+
+// ResolveImageName is ResolveImage for a bare repository path without tag or digest.
+func ResolveImageName(name, registryName string, registries *registry.Registries) (*registry.Registry, string, error) {
+	if _, err := ParseImageName(name); err != nil {
+		return nil, "", common.HTTPError(err, fiber.StatusBadRequest)
+	}
+	return route(name, registryName, registries)
+}
+
+func route(name, registryName string, registries *registry.Registries) (*registry.Registry, string, error) {
+	if registryName == "" {
+		reg, routedName := registries.Route(name)
+		return reg, routedName, nil
+	}
+	reg, ok := registries.Get(registryName)
+	if !ok {
+		return nil, "", common.HTTPError(fmt.Sprintf("unknown registry %s", registryName), fiber.StatusNotFound)
+	}
+	return reg, name, nil
+}
@@ -0,0 +1,73 @@
+package docker
+
+import (
+	"fmt"
+
+	"docker-operator/src/artifacts"
+	"docker-operator/src/auth"
+	"docker-operator/src/common"
+	"docker-operator/src/docker"
+	"docker-operator/src/execution"
+	"docker-operator/src/history"
+	"docker-operator/src/registry"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ReplayResult is the output of a replayed execution next to the output hash of the original one.
+type ReplayResult struct {
+	Execution          string            `json:"execution"`
+	ReplayOf           string            `json:"replay_of"`
+	Image              string            `json:"image"`
+	Output             string            `json:"output"`
+	Headers            map[string]string `json:"headers"`
+	OutputHash         string            `json:"output_hash"`
+	OriginalOutputHash string            `json:"original_output_hash,omitempty"`
+	Identical          bool              `json:"identical"`
+}
+
+// Replay runs a recorded execution again with the same method and inputs, on
+// the image pinned to the digest the execution ran.
+func Replay(dockerService docker.ServiceInterface, registries *registry.Registries, executions *execution.Registry, records *history.Store, archive *artifacts.Store) func(c *fiber.Ctx) error {
+	x := &executor{service: dockerService, registries: registries, executions: executions, records: records, archive: archive}
+	return func(c *fiber.Ctx) error {
+		original, ok := records.Get(c.Params("id"))
+		if !ok {
+			return ErrorResponse(c, common.HTTPError("execution not found", fiber.StatusNotFound))
+		}
+		if original.Digest == "" {
+			return ErrorResponse(c, common.HTTPError(fmt.Sprintf("execution %s has no digest to pin, it did not run", original.ID), fiber.StatusUnprocessableEntity))
+		}
+		reg, ok := registries.Get(original.Registry)
+		if !ok {
+			return ErrorResponse(c, common.HTTPError(fmt.Sprintf("unknown registry %s", original.Registry), fiber.StatusNotFound))
+		}
+		image := reg.Reference(original.Image, "", original.Digest)
+		event := event{
+			Caller:     auth.FromContext(c),
+			Registry:   reg.Name,
+			Image:      original.Image,
+			Tag:        original.Tag,
+			Digest:     original.Digest,
+			Params:     original.Params,
+			ParamsHash: original.ParamsHash,
+			ReplayOf:   original.ID,
+			Method:     original.Method,
+		}
+		out, header, err := x.runExecution(c, image, original.Params, &event)
+		if err != nil {
+			return ErrorResponse(c, err)
+		}
+		result := ReplayResult{
+			Execution:          event.Execution,
+			ReplayOf:           original.ID,
+			Image:              image,
+			Output:             string(out),
+			Headers:            header.Header,
+			OutputHash:         outputHash(out),
+			OriginalOutputHash: original.OutputHash,
+		}
+		result.Identical = result.OutputHash == result.OriginalOutputHash
+		return c.JSON(result)
+	}
+}
@@ -1,6 +1,7 @@
 package routes
 
 import (
+	"docker-operator/log"
 	"docker-operator/src/common"
 
 	"github.com/gofiber/fiber/v2"
@@ -8,18 +9,20 @@ import (
 
 // ErrorResp represents the structure of a standard error response.
 type ErrorResp struct {
-	S      string `json:"s"`
-	ErrMsg string `json:"errmsg"`
+	S         string `json:"s"`
+	ErrMsg    string `json:"errmsg"`
+	RequestID string `json:"request_id,omitempty"`
 }
 
 // StandardErrorHandler generates a standardized error message
 func StandardErrorHandler(ctx *fiber.Ctx, err error) error {
 	ctx.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	requestID := log.RequestID(ctx.UserContext())
 
 	switch err := err.(type) {
 	case common.HttpError:
-		return ctx.Status(err.StatusCode()).JSON(ErrorResp{S: "error", ErrMsg: err.Error()})
+		return ctx.Status(err.StatusCode()).JSON(ErrorResp{S: "error", ErrMsg: err.Error(), RequestID: requestID})
 	default:
-		return ctx.Status(200).JSON(ErrorResp{S: "error", ErrMsg: err.Error()})
+		return ctx.Status(200).JSON(ErrorResp{S: "error", ErrMsg: err.Error(), RequestID: requestID})
 	}
 }
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"time"
 
+	"docker-operator/src/common"
+	"docker-operator/src/prewarm"
 	"docker-operator/version"
 
 	"github.com/gofiber/fiber/v2"
@@ -13,32 +15,38 @@ import (
 var upSince = time.Now()
 
 type healthCheck struct {
-	Alive     bool   `json:"alive"`
-	Since     string `json:"since"`
-	Version   string `json:"version"`
-	BuildDate string `json:"build_date"`
-	GoVersion string `json:"go_version"`
-	Commit    string `json:"commit"`
+	Alive     bool            `json:"alive"`
+	Since     string          `json:"since"`
+	Version   string          `json:"version"`
+	BuildDate string          `json:"build_date"`
+	GoVersion string          `json:"go_version"`
+	Commit    string          `json:"commit"`
+	Prewarm   *prewarm.Status `json:"prewarm,omitempty"`
 }
 
-// CheckHandler : A very simple health check.
-func CheckHandler(c *fiber.Ctx) error {
-	zap.S().Debug("handle health check")
-	healthCheck := &healthCheck{
-		Alive:     true,
-		Since:     time.Since(upSince).String(),
-		Version:   version.Version,
-		BuildDate: version.BuildDate,
-		GoVersion: version.GoVersion,
-		Commit:    version.GitCommit,
+// CheckHandler : A very simple health check, reporting the prewarmed images when a prewarmer is given.
+func CheckHandler(prewarmer *prewarm.Prewarmer) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		zap.S().Debug("handle health check")
+		healthCheck := &healthCheck{
+			Alive:     true,
+			Since:     time.Since(upSince).String(),
+			Version:   version.Version,
+			BuildDate: version.BuildDate,
+			GoVersion: version.GoVersion,
+			Commit:    version.GitCommit,
+		}
+		if prewarmer != nil {
+			status := prewarmer.Status()
+			healthCheck.Prewarm = &status
+		}
+		responseBytes, err := json.Marshal(healthCheck)
+		if err != nil {
+			zap.S().Error("Error marshalling healthCheck response: ", err)
+			return common.ErrorJSON(c, fiber.StatusInternalServerError, fiber.Map{
+				"msg": err,
+			})
+		}
+		return c.Send(responseBytes)
 	}
-	responseBytes, err := json.Marshal(healthCheck)
-	if err != nil {
-		zap.S().Error("Error marshalling healthCheck response: ", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": true,
-			"msg":   err,
-		})
-	}
-	return c.Send(responseBytes)
 }
@@ -0,0 +1,108 @@
+package history
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"docker-operator/src/artifacts"
+	"docker-operator/src/common"
+	historystore "docker-operator/src/history"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// List returns a page of the execution history, filtered by the image, status,
+// caller, since and until query parameters and paginated with offset and limit.
+func List(records *historystore.Store) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		query, err := parseQuery(c)
+		if err != nil {
+			return common.ErrorJSON(c, fiber.StatusBadRequest, fiber.Map{
+				"msg": err.Error(),
+			})
+		}
+		page := records.Find(query)
+		// the inputs are only kept to replay the executions
+		for i := range page.Records {
+			page.Records[i].Params = nil
+		}
+		return c.JSON(page)
+	}
+}
+
+func parseQuery(c *fiber.Ctx) (historystore.Query, error) {
+	query := historystore.Query{
+		Image:  c.Query("image"),
+		Status: c.Query("status"),
+		Caller: c.Query("caller"),
+	}
+	var err error
+	if query.Since, err = parseTime(c, "since"); err != nil {
+		return query, err
+	}
+	if query.Until, err = parseTime(c, "until"); err != nil {
+		return query, err
+	}
+	if query.Offset, err = parseCount(c, "offset"); err != nil {
+		return query, err
+	}
+	if query.Limit, err = parseCount(c, "limit"); err != nil {
+		return query, err
+	}
+	return query, nil
+}
+
+func parseTime(c *fiber.Ctx, key string) (time.Time, error) {
+	value := c.Query(key)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s %q, expected an RFC 3339 time", key, value)
+	}
+	return t, nil
+}
+
+func parseCount(c *fiber.Ctx, key string) (int, error) {
+	value := c.Query(key)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q, expected a positive number", key, value)
+	}
+	return n, nil
+}
+
+// Logs returns the archived stdout and stderr of an execution, or only one of
+// them as plain text with ?stream=stdout or ?stream=stderr.
+func Logs(archive *artifacts.Store) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		logs, err := archive.Get(c.Params("id"))
+		if err == artifacts.NotFoundError {
+			return common.ErrorJSON(c, fiber.StatusNotFound, fiber.Map{
+				"msg": err.Error(),
+			})
+		}
+		if err != nil {
+			return common.ErrorJSON(c, fiber.StatusInternalServerError, fiber.Map{
+				"msg": err.Error(),
+			})
+		}
+		switch stream := c.Query("stream"); stream {
+		case "":
+			return c.JSON(logs)
+		case "stdout":
+			return c.SendString(logs.Stdout)
+		case "stderr":
+			return c.SendString(logs.Stderr)
+		default:
+			return common.ErrorJSON(c, fiber.StatusBadRequest, fiber.Map{
+				"msg": fmt.Sprintf("invalid stream %q, expected stdout or stderr", stream),
+			})
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package idempotency
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"docker-operator/log"
+	"docker-operator/src/auth"
+	"docker-operator/src/common"
+	idemstore "docker-operator/src/idempotency"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Headers of the idempotent requests.
+const (
+	KeyHeader      = "Idempotency-Key"
+	ReplayedHeader = "Idempotent-Replayed"
+)
+
+// InProgressLocal is set when a request was turned away because the first
+// request with its key is still running.
+const InProgressLocal = "idempotency-in-progress"
+
+const maxKeyLength = 255
+
+// forgotten are the statuses of the requests that did not run, or whose run
+// failed on the infrastructure, whose key is released so they can be retried.
+var forgotten = map[int]bool{
+	fiber.StatusUnauthorized:       true,
+	fiber.StatusForbidden:          true,
+	fiber.StatusRequestTimeout:     true,
+	fiber.StatusConflict:           true,
+	fiber.StatusTooManyRequests:    true,
+	fiber.StatusBadGateway:         true,
+	fiber.StatusServiceUnavailable: true,
+	fiber.StatusGatewayTimeout:     true,
+}
+
+// Middleware answers the non-GET requests repeating the Idempotency-Key of an
+// earlier request of the same caller with its stored result. A key reused for
+// another method, route or body is rejected with 422, and a key whose first
+// request is still running with 409.
+func Middleware(store *idemstore.Store) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		key := c.Get(KeyHeader)
+		if key == "" || c.Method() == fiber.MethodGet {
+			return c.Next()
+		}
+		if len(key) > maxKeyLength {
+			return common.ErrorJSON(c, fiber.StatusBadRequest, fiber.Map{
+				"msg": "idempotency key is longer than 255 characters",
+			})
+		}
+		caller := "ip:" + c.IP()
+		if identity := auth.FromContext(c); identity != nil {
+			caller = "identity:" + identity.Name
+		}
+		key = caller + "\n" + key
+		state, result := store.Begin(key, fingerprint(c))
+		switch state {
+		case idemstore.Mismatch:
+			return common.ErrorJSON(c, fiber.StatusUnprocessableEntity, fiber.Map{
+				"msg": "idempotency key was used for another request",
+			})
+		case idemstore.InProgress:
+			c.Locals(InProgressLocal, true)
+			return common.ErrorJSON(c, fiber.StatusConflict, fiber.Map{
+				"msg": "a request with this idempotency key is in progress",
+			})
+		case idemstore.Done:
+			log.FromContext(c.UserContext()).Infow("replaying result of idempotent request", "caller", caller)
+			for name, value := range result.Headers {
+				c.Set(name, value)
+			}
+			c.Set(ReplayedHeader, "true")
+			return c.Status(result.Status).Send(result.Body)
+		}
+
+		// the key is released unless the result is stored
+		defer store.Abort(key)
+		before := headers(c)
+		if err := c.Next(); err != nil {
+			return err
+		}
+		status := c.Response().StatusCode()
+		if forgotten[status] {
+			return nil
+		}
+		// only the headers set past this middleware belong to the result
+		set := make(map[string]string)
+		for name, value := range headers(c) {
+			if before[name] != value {
+				set[name] = value
+			}
+		}
+		store.Finish(key, idemstore.Result{
+			Status:  status,
+			Headers: set,
+			Body:    append([]byte{}, c.Response().Body()...),
+		})
+		return nil
+	}
+}
+
+// fingerprint identifies a request by its method, route and body.
+func fingerprint(c *fiber.Ctx) string {
+	hash := sha256.New()
+	hash.Write([]byte(c.Method() + "\n" + c.OriginalURL() + "\n"))
+	hash.Write(c.Body())
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func headers(c *fiber.Ctx) map[string]string {
+	values := make(map[string]string)
+	c.Response().Header.VisitAll(func(name, value []byte) {
+		values[string(name)] = string(value)
+	})
+	return values
+}
@@ -0,0 +1,51 @@
+package limits
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"docker-operator/log"
+	"docker-operator/src/auth"
+	"docker-operator/src/common"
+	ratelimit "docker-operator/src/limits"
+	"docker-operator/src/registry"
+	"docker-operator/src/v1/docker"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Limit counts exec requests against the limits of the caller and rejects them
+// with 429 once they are exceeded. The caller is the authenticated identity or the client IP.
+func Limit(limiter *ratelimit.Limiter, registries *registry.Registries) func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		reg, ref, err := docker.ResolveImage(c.Params("*"), c.Params("registry"), registries)
+		if err != nil {
+			return docker.ErrorResponse(c, err)
+		}
+		caller := "ip:" + c.IP()
+		if identity := auth.FromContext(c); identity != nil {
+			caller = "identity:" + identity.Name
+		}
+		image := reg.Reference(ref.Name, ref.Tag, ref.Digest)
+		decision, err := limiter.Allow(caller, image)
+		if err != nil {
+			// do not turn callers away because the counters are unavailable
+			log.FromContext(c.UserContext()).Errorw("could not check rate limit", "caller", caller, "error", err)
+			return c.Next()
+		}
+		if decision.Limit > 0 {
+			c.Set("RateLimit-Limit", strconv.Itoa(decision.Limit))
+			c.Set("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+		}
+		if !decision.Allowed {
+			retryAfter := int(math.Ceil(decision.RetryAfter.Seconds()))
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+			log.FromContext(c.UserContext()).Warnw("request rate limited", "caller", caller, "image", image, "reason", decision.Reason)
+			return common.ErrorJSON(c, fiber.StatusTooManyRequests, fiber.Map{
+				"msg": fmt.Sprintf("too many requests: %s", decision.Reason),
+			})
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,43 @@
+package requestid
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+
+	"docker-operator/log"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// Header carries the id of a request, from the caller or generated.
+const Header = "X-Request-ID"
+
+var validID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// New accepts the request id sent by the caller or generates one. The id is
+// attached to the user context of the request, so it annotates its logs and
+// error bodies, and echoed in the response header.
+func New() func(c *fiber.Ctx) error {
+	return func(c *fiber.Ctx) error {
+		id := utils.CopyString(c.Get(Header))
+		if !validID.MatchString(id) {
+			id = generate()
+		}
+		c.SetUserContext(log.WithRequestID(c.UserContext(), id))
+		c.Set(Header, id)
+		return c.Next()
+	}
+}
+
+// Get returns the id of the request.
+func Get(c *fiber.Ctx) string {
+	return log.RequestID(c.UserContext())
+}
+
+func generate() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
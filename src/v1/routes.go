@@ -1,18 +1,150 @@
 package routes
 
 import (
-	docker2 "docker-operator/src/docker"
+	"docker-operator/src/artifacts"
+	auditlog "docker-operator/src/audit"
+	"docker-operator/src/auth"
+	dockerservice "docker-operator/src/docker"
+	"docker-operator/src/execution"
+	historystore "docker-operator/src/history"
+	idemstore "docker-operator/src/idempotency"
+	"docker-operator/src/imagecache"
+	ratelimit "docker-operator/src/limits"
+	"docker-operator/src/metrics"
+	"docker-operator/src/prewarm"
+	"docker-operator/src/rbac"
+	"docker-operator/src/redact"
+	"docker-operator/src/registry"
+	"docker-operator/src/responsecache"
+	"docker-operator/src/v1/admin"
+	"docker-operator/src/v1/audit"
+	"docker-operator/src/v1/authz"
 	"docker-operator/src/v1/docker"
 	"docker-operator/src/v1/health"
+	"docker-operator/src/v1/history"
+	"docker-operator/src/v1/idempotency"
+	"docker-operator/src/v1/limits"
+	"docker-operator/src/v1/requestid"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-func AddRoutes(app *fiber.App, dockerService docker2.ServiceInterface) {
+// Dependencies holds what the routes are built from. Optional parts are nil when disabled.
+type Dependencies struct {
+	Service    dockerservice.ServiceInterface
+	Client     dockerservice.ClientInterface
+	Registries *registry.Registries
+	Prewarmer  *prewarm.Prewarmer
+	Cache      *imagecache.Cache
+	// ResponseCache is purged through the admin endpoints when set.
+	ResponseCache *responsecache.Cache
+	// Executions tracks the executions in flight, a new registry is used when nil.
+	Executions *execution.Registry
+	// Authenticators protect the exec routes when set.
+	Authenticators []auth.Authenticator
+	// Authorization decides which callers may run which images and use which admin capabilities.
+	Authorization *rbac.Policy
+	// History stores the finished executions when set.
+	History *historystore.Store
+	// Artifacts archives the stdout and stderr of the executions when set.
+	Artifacts *artifacts.Store
+	// Redactor hides secrets and personal data from the logged requests when set.
+	Redactor *redact.Redactor
+	// AuditLog records every exec request when set.
+	AuditLog *auditlog.Log
+	// Metrics are served at /metrics when set.
+	Metrics *metrics.Metrics
+	// Limiter rate limits the exec routes when set.
+	Limiter *ratelimit.Limiter
+	// Idempotency replays the results of the non-GET exec and replay requests repeating an Idempotency-Key when set.
+	Idempotency *idemstore.Store
+	// AdminToken grants every admin capability to the requests carrying it as bearer token.
+	AdminToken string
+}
+
+func AddRoutes(app *fiber.App, deps Dependencies) {
+	if deps.Executions == nil {
+		deps.Executions = execution.NewRegistry(deps.Client)
+	}
+	app.Use(requestid.New())
+	if deps.Redactor != nil {
+		app.Use(func(c *fiber.Ctx) error {
+			c.SetUserContext(redact.WithRedactor(c.UserContext(), deps.Redactor))
+			return c.Next()
+		})
+	}
+	if deps.Metrics != nil {
+		app.Get("/metrics", deps.Metrics.Handler())
+	}
 	v1 := app.Group("/api")
 	// Health
-	v1.Get("/status", health.CheckHandler)
+	v1.Get("/status", health.CheckHandler(deps.Prewarmer))
 	// Run container
-	v1.Get("/exec/:image_name/:tag", docker.RunContainerGet(dockerService))
-	v1.Post("/exec/:image_name/:tag", docker.RunContainerPost(dockerService))
+	var guards []fiber.Handler
+	if len(deps.Authenticators) > 0 {
+		guards = append(guards, auth.Middleware(deps.Authenticators...))
+	}
+	execHandlers := func(handler fiber.Handler) []fiber.Handler {
+		var handlers []fiber.Handler
+		if deps.AuditLog != nil {
+			handlers = append(handlers, audit.Record(deps.AuditLog, deps.Registries))
+		}
+		handlers = append(handlers, guards...)
+		if deps.Authorization != nil {
+			handlers = append(handlers, authz.Authorize(deps.Authorization, deps.Registries))
+		}
+		if deps.Limiter != nil {
+			handlers = append(handlers, limits.Limit(deps.Limiter, deps.Registries))
+		}
+		if deps.Idempotency != nil {
+			handlers = append(handlers, idempotency.Middleware(deps.Idempotency))
+		}
+		return append(handlers, handler)
+	}
+	// The wildcard holds the image path, e.g. team/tool/1.0 or team/tool@sha256:...
+	v1.Get("/exec/*", execHandlers(docker.RunContainerGet(deps.Service, deps.Registries, deps.Executions, deps.History, deps.Artifacts))...)
+	v1.Post("/exec/*", execHandlers(docker.RunContainerPost(deps.Service, deps.Registries, deps.Executions, deps.History, deps.Artifacts))...)
+	// Run container from an explicitly named registry
+	v1.Get("/registries/:registry/exec/*", execHandlers(docker.RunContainerGet(deps.Service, deps.Registries, deps.Executions, deps.History, deps.Artifacts))...)
+	v1.Post("/registries/:registry/exec/*", execHandlers(docker.RunContainerPost(deps.Service, deps.Registries, deps.Executions, deps.History, deps.Artifacts))...)
+	if deps.Authorization != nil {
+		// Explain the decision on an exec request without running it
+		v1.Get("/authz/explain/*", append(append([]fiber.Handler{}, guards...), authz.Explain(deps.Authorization, deps.Registries))...)
+	}
+
+	if deps.AdminToken == "" && (deps.Authorization == nil || len(deps.Authenticators) == 0) {
+		return
+	}
+	adminAuthenticators := append([]auth.Authenticator{auth.NewToken(deps.AdminToken)}, deps.Authenticators...)
+	require := func(capability string) fiber.Handler {
+		return admin.Require(deps.Authorization, capability)
+	}
+	if deps.History != nil {
+		// Execution history, guarded like the admin endpoints
+		v1.Get("/executions", auth.Middleware(adminAuthenticators...), require(rbac.ExecutionsRead), history.List(deps.History))
+	}
+	if deps.History != nil && deps.History.ReplayInputs() {
+		replayHandlers := []fiber.Handler{auth.Middleware(adminAuthenticators...), require(rbac.ExecutionsReplay)}
+		if deps.Idempotency != nil {
+			replayHandlers = append(replayHandlers, idempotency.Middleware(deps.Idempotency))
+		}
+		replayHandlers = append(replayHandlers, docker.Replay(deps.Service, deps.Registries, deps.Executions, deps.History, deps.Artifacts))
+		v1.Post("/executions/:id/replay", replayHandlers...)
+	}
+	if deps.Artifacts != nil {
+		v1.Get("/executions/:id/logs", auth.Middleware(adminAuthenticators...), require(rbac.ExecutionsRead), history.Logs(deps.Artifacts))
+	}
+	adminGroup := v1.Group("/admin", auth.Middleware(adminAuthenticators...))
+	// Images
+	adminGroup.Get("/images", require(rbac.ImagesRead), admin.ListImages(deps.Client, deps.Cache))
+	adminGroup.Post("/images/*", require(rbac.ImagesWrite), admin.PullImage(deps.Service, deps.Registries))
+	adminGroup.Delete("/images/*", require(rbac.ImagesWrite), admin.RemoveImage(deps.Client, deps.Registries, deps.Cache))
+	adminGroup.Get("/latest/*", require(rbac.ImagesRead), admin.LatestTag(deps.Registries))
+	// Executions
+	adminGroup.Get("/executions", require(rbac.ExecutionsRead), admin.ListExecutions(deps.Executions))
+	adminGroup.Delete("/executions/:id", require(rbac.ExecutionsCancel), admin.CancelExecution(deps.Executions))
+	if deps.ResponseCache != nil {
+		// Cached outputs
+		adminGroup.Delete("/responses", require(rbac.ResponsesPurge), admin.PurgeResponses(deps.ResponseCache))
+	}
 }
@@ -0,0 +1,222 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"docker-operator/src/docker"
+	"docker-operator/src/execution"
+	routes "docker-operator/src/v1"
+	"docker-operator/src/v1/requestid"
+
+	"github.com/docker/docker/api/types"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+const testAdminToken = "admin-secret"
+
+func TestAdminImages(t *testing.T) {
+	tests := []struct {
+		description        string
+		route              string
+		method             string
+		token              string
+		expectedStatusCode int
+		mockService        func(ms *docker.MockServiceInterface)
+		mockClient         func(mc *docker.MockClientInterface)
+		expectedBody       []byte
+	}{
+		{
+			description:        "reject requests without the admin token",
+			route:              "/api/admin/images",
+			method:             "GET",
+			expectedStatusCode: http.StatusUnauthorized,
+			expectedBody:       []byte(`{"error":true,"msg":"unauthorized","request_id":"test-request"}`),
+		},
+		{
+			description:        "reject requests with a wrong admin token",
+			route:              "/api/admin/images",
+			method:             "GET",
+			token:              "guess",
+			expectedStatusCode: http.StatusUnauthorized,
+			expectedBody:       []byte(`{"error":true,"msg":"unauthorized","request_id":"test-request"}`),
+		},
+		{
+			description:        "list the images",
+			route:              "/api/admin/images",
+			method:             "GET",
+			token:              testAdminToken,
+			expectedStatusCode: http.StatusOK,
+			mockClient: func(mc *docker.MockClientInterface) {
+				mc.EXPECT().ImageList(gomock.Any(), types.ImageListOptions{}).Return([]types.ImageSummary{{
+					ID:          "sha256:1",
+					RepoTags:    []string{"registry.local/alpine:3.13"},
+					RepoDigests: []string{"registry.local/alpine@sha256:2"},
+					Size:        5600000,
+					Created:     1622505600,
+				}}, nil)
+			},
+			expectedBody: []byte(`[{"id":"sha256:1","tags":["registry.local/alpine:3.13"],"digests":["registry.local/alpine@sha256:2"],"size":5600000,"created":"2021-06-01T00:00:00Z"}]`),
+		},
+		{
+			description:        "pull an image",
+			route:              "/api/admin/images/team/tool/1.0?registry=mirror",
+			method:             "POST",
+			token:              testAdminToken,
+			expectedStatusCode: http.StatusOK,
+			mockService: func(ms *docker.MockServiceInterface) {
+				ms.EXPECT().PullImage("mirror.local/team/tool:1.0", gomock.Any()).Return(nil)
+				ms.EXPECT().ImageDigest("mirror.local/team/tool:1.0", gomock.Any()).Return("sha256:2", nil)
+			},
+			expectedBody: []byte(`{"digest":"sha256:2","image":"mirror.local/team/tool:1.0"}`),
+		},
+		{
+			description:        "return 400 for a malformed image",
+			route:              "/api/admin/images/Team/tool/1.0",
+			method:             "POST",
+			token:              testAdminToken,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedBody:       []byte(`{"error":true,"msg":"invalid image name \"Team/tool\": invalid reference format: repository name must be lowercase","request_id":"test-request"}`),
+		},
+		{
+			description:        "remove an image",
+			route:              "/api/admin/images/alpine/3.13",
+			method:             "DELETE",
+			token:              testAdminToken,
+			expectedStatusCode: http.StatusOK,
+			mockClient: func(mc *docker.MockClientInterface) {
+				mc.EXPECT().ImageRemove(gomock.Any(), "registry.local/alpine:3.13", types.ImageRemoveOptions{PruneChildren: true}).
+					Return([]types.ImageDeleteResponseItem{{Untagged: "registry.local/alpine:3.13"}}, nil)
+			},
+			expectedBody: []byte(`{"deleted":[{"Untagged":"registry.local/alpine:3.13"}],"image":"registry.local/alpine:3.13"}`),
+		},
+		{
+			description:        "return 500 when the image cannot be removed",
+			route:              "/api/admin/images/alpine/3.13",
+			method:             "DELETE",
+			token:              testAdminToken,
+			expectedStatusCode: http.StatusInternalServerError,
+			mockClient: func(mc *docker.MockClientInterface) {
+				mc.EXPECT().ImageRemove(gomock.Any(), "registry.local/alpine:3.13", gomock.Any()).
+					Return(nil, fmt.Errorf("image is being used by running container"))
+			},
+			expectedBody: []byte(`{"error":true,"msg":"image is being used by running container","request_id":"test-request"}`),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			app := fiber.New()
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			dockerService := docker.NewMockServiceInterface(ctrl)
+			if test.mockService != nil {
+				test.mockService(dockerService)
+			}
+			dockerClient := docker.NewMockClientInterface(ctrl)
+			if test.mockClient != nil {
+				test.mockClient(dockerClient)
+			}
+			routes.AddRoutes(app, routes.Dependencies{
+				Service:    dockerService,
+				Client:     dockerClient,
+				Registries: testRegistries(t),
+				AdminToken: testAdminToken,
+			})
+			req := httptest.NewRequest(test.method, test.route, nil)
+			req.Header.Set(requestid.Header, testRequestID)
+			if test.token != "" {
+				req.Header.Set("Authorization", "Bearer "+test.token)
+			}
+			resp, err := app.Test(req, -1)
+			assert.NoError(t, err)
+			assert.Equalf(t, test.expectedStatusCode, resp.StatusCode, test.description)
+			body, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				t.Fail()
+			}
+			assert.Equalf(t, string(test.expectedBody), string(body), test.description)
+		})
+	}
+}
+
+func TestAdminExecutions(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dockerService := docker.NewMockServiceInterface(ctrl)
+	dockerClient := docker.NewMockClientInterface(ctrl)
+	executions := execution.NewRegistry(dockerClient)
+	app := fiber.New()
+	routes.AddRoutes(app, routes.Dependencies{
+		Service:    dockerService,
+		Client:     dockerClient,
+		Registries: testRegistries(t),
+		Executions: executions,
+		AdminToken: testAdminToken,
+	})
+	adminRequest := func(method, route string) (int, string) {
+		req := httptest.NewRequest(method, route, nil)
+		req.Header.Set(requestid.Header, testRequestID)
+		req.Header.Set("Authorization", "Bearer "+testAdminToken)
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+		body, err := ioutil.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+
+	status, body := adminRequest("GET", "/api/admin/executions")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "[]", body)
+	status, body = adminRequest("DELETE", "/api/admin/executions/unknown")
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Equal(t, `{"error":true,"msg":"execution not found","request_id":"test-request"}`, body)
+
+	running := make(chan struct{})
+	dockerService.EXPECT().ImageExists("registry.local/tool:1", gomock.Any()).Return(true)
+	dockerService.EXPECT().RunContainer("registry.local/tool:1", gomock.Any(), gomock.Any()).
+		DoAndReturn(func(image string, params []string, ctx context.Context) ([]byte, *docker.Headers, error) {
+			close(running)
+			<-ctx.Done()
+			return nil, nil, ctx.Err()
+		})
+	type result struct {
+		status int
+		body   string
+	}
+	results := make(chan result)
+	go func() {
+		resp, err := app.Test(httptest.NewRequest("GET", "/api/exec/tool/1?token=secret", nil), -1)
+		assert.NoError(t, err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		results <- result{resp.StatusCode, string(body)}
+	}()
+
+	select {
+	case <-running:
+	case <-time.After(5 * time.Second):
+		t.Fatal("execution did not start")
+	}
+	inFlight := executions.List()
+	assert.Len(t, inFlight, 1)
+	assert.Equal(t, "registry.local/tool:1", inFlight[0].Image)
+	assert.Equal(t, []string{"token=***"}, inFlight[0].Params)
+	status, _ = adminRequest("DELETE", "/api/admin/executions/"+inFlight[0].ID)
+	assert.Equal(t, http.StatusOK, status)
+
+	select {
+	case res := <-results:
+		assert.Equal(t, http.StatusConflict, res.status)
+		assert.Equal(t, fmt.Sprintf(`{"error":true,"msg":"execution cancelled: %s","request_id":"%s"}`, inFlight[0].ID, inFlight[0].RequestID), res.body)
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancelled execution did not fail")
+	}
+	assert.Empty(t, executions.List())
+}
@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"docker-operator/src/audit"
+	"docker-operator/src/docker"
+	"docker-operator/src/rbac"
+	routes "docker-operator/src/v1"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecAuditLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := audit.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dockerService := docker.NewMockServiceInterface(ctrl)
+	dockerService.EXPECT().ImageExists("registry.local/tools/report:1.0", gomock.Any()).Return(true).Times(2)
+	dockerService.EXPECT().RunContainer("registry.local/tools/report:1.0", []string{"a=1"}, gomock.Any()).
+		DoAndReturn(runsImage("sha256:"+testDigest, []byte("ok")))
+	dockerService.EXPECT().RunContainer("registry.local/tools/report:1.0", []string{"a=2"}, gomock.Any()).Return(nil, nil, docker.CancelledError)
+	routes.AddRoutes(app, routes.Dependencies{
+		Service:       dockerService,
+		Registries:    testRegistries(t),
+		AuditLog:      auditLog,
+		Authorization: &rbac.Policy{Rules: []rbac.Rule{{Name: "tools", Images: []string{"tools/*"}}}},
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/api/exec/tools/report/1.0?a=1", nil), -1)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, err = app.Test(httptest.NewRequest("GET", "/api/exec/billing/1.0", nil), -1)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp, err = app.Test(httptest.NewRequest("GET", "/api/exec/tools/report/1.0?a=2", nil), -1)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+	assert.NoError(t, auditLog.Close())
+
+	count, err := audit.Verify(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var entries []audit.Entry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry audit.Entry
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+	assert.Len(t, entries, 3)
+	assert.Equal(t, "registry.local/tools/report:1.0", entries[0].Image)
+	assert.Equal(t, "sha256:"+testDigest, entries[0].Digest)
+	assert.Equal(t, audit.OutcomeSuccess, entries[0].Outcome)
+	assert.Equal(t, "anonymous", entries[0].Caller)
+	assert.NotEmpty(t, entries[0].Execution)
+	assert.NotEmpty(t, entries[0].ParamsHash)
+	assert.Equal(t, "registry.local/billing:1.0", entries[1].Image)
+	assert.Equal(t, audit.OutcomeDenied, entries[1].Outcome)
+	assert.Equal(t, "request denied by authorization policy: no rule allows GET registry.local/billing:1.0 of anonymous", entries[1].Error)
+	assert.Equal(t, audit.OutcomeCancelled, entries[2].Outcome)
+	assert.Empty(t, entries[2].Digest)
+}
@@ -0,0 +1,91 @@
+package tests
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"docker-operator/src/auth"
+	"docker-operator/src/docker"
+	routes "docker-operator/src/v1"
+	"docker-operator/src/v1/requestid"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecAuthentication(t *testing.T) {
+	tests := []struct {
+		description        string
+		route              string
+		apiKey             string
+		expectedStatusCode int
+		mockService        func(ms *docker.MockServiceInterface)
+		expectedBody       []byte
+	}{
+		{
+			description:        "keep the health check open",
+			route:              "/api/status",
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			description:        "reject exec requests without api key",
+			route:              "/api/exec/alpine/3.13",
+			expectedStatusCode: http.StatusUnauthorized,
+			expectedBody:       []byte(`{"error":true,"msg":"unauthorized","request_id":"test-request"}`),
+		},
+		{
+			description:        "reject exec requests on a named registry without api key",
+			route:              "/api/registries/mirror/exec/alpine/3.13",
+			expectedStatusCode: http.StatusUnauthorized,
+			expectedBody:       []byte(`{"error":true,"msg":"unauthorized","request_id":"test-request"}`),
+		},
+		{
+			description:        "run the image for a known api key",
+			route:              "/api/exec/alpine/3.13",
+			apiKey:             "ci-key",
+			expectedStatusCode: http.StatusOK,
+			mockService: func(ms *docker.MockServiceInterface) {
+				ms.EXPECT().ImageExists("registry.local/alpine:3.13", gomock.Any()).Return(true)
+				ms.EXPECT().RunContainer("registry.local/alpine:3.13", nil, gomock.Any()).Return([]byte("ok"), &docker.Headers{}, nil)
+			},
+			expectedBody: []byte("ok"),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			app := fiber.New()
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			dockerService := docker.NewMockServiceInterface(ctrl)
+			if test.mockService != nil {
+				test.mockService(dockerService)
+			}
+			keys, err := auth.NewAPIKeys([]auth.Key{{Identity: "ci", Hash: auth.HashKey("ci-key")}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			routes.AddRoutes(app, routes.Dependencies{
+				Service:        dockerService,
+				Registries:     testRegistries(t),
+				Authenticators: []auth.Authenticator{keys},
+			})
+			req := httptest.NewRequest("GET", test.route, nil)
+			req.Header.Set(requestid.Header, testRequestID)
+			if test.apiKey != "" {
+				req.Header.Set(auth.APIKeyHeader, test.apiKey)
+			}
+			resp, err := app.Test(req, -1)
+			assert.NoError(t, err)
+			assert.Equalf(t, test.expectedStatusCode, resp.StatusCode, test.description)
+			if test.expectedBody == nil {
+				return
+			}
+			body, err := ioutil.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equalf(t, string(test.expectedBody), string(body), test.description)
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package tests
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"docker-operator/src/auth"
+	"docker-operator/src/docker"
+	"docker-operator/src/rbac"
+	routes "docker-operator/src/v1"
+	"docker-operator/src/v1/requestid"
+
+	"github.com/docker/docker/api/types"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthorization(t *testing.T) {
+	tests := []struct {
+		description        string
+		route              string
+		method             string
+		apiKey             string
+		expectedStatusCode int
+		mockService        func(ms *docker.MockServiceInterface)
+		mockClient         func(mc *docker.MockClientInterface)
+		expectedBody       []byte
+	}{
+		{
+			description:        "run an image the caller is allowed to",
+			route:              "/api/exec/tools/report/1.0",
+			method:             "GET",
+			apiKey:             "ci-key",
+			expectedStatusCode: http.StatusOK,
+			mockService: func(ms *docker.MockServiceInterface) {
+				ms.EXPECT().ImageExists("registry.local/tools/report:1.0", gomock.Any()).Return(true)
+				ms.EXPECT().RunContainer("registry.local/tools/report:1.0", nil, gomock.Any()).Return([]byte("ok"), &docker.Headers{}, nil)
+			},
+			expectedBody: []byte("ok"),
+		},
+		{
+			description:        "deny an image before calling the docker service",
+			route:              "/api/exec/billing/1.0",
+			method:             "GET",
+			apiKey:             "ci-key",
+			expectedStatusCode: http.StatusForbidden,
+			expectedBody:       []byte(`{"error":true,"msg":"request denied by authorization policy: no rule allows GET registry.local/billing:1.0 of ci","reason":"no rule allows GET registry.local/billing:1.0 of ci","request_id":"test-request"}`),
+		},
+		{
+			description:        "deny a method the caller is not allowed to",
+			route:              "/api/exec/tools/report/1.0",
+			method:             "POST",
+			apiKey:             "ci-key",
+			expectedStatusCode: http.StatusForbidden,
+			expectedBody:       []byte(`{"error":true,"msg":"request denied by authorization policy: no rule allows POST registry.local/tools/report:1.0 of ci","reason":"no rule allows POST registry.local/tools/report:1.0 of ci","request_id":"test-request"}`),
+		},
+		{
+			description:        "explain a decision for the caller",
+			route:              "/api/authz/explain/billing/1.0?method=post",
+			method:             "GET",
+			apiKey:             "ci-key",
+			expectedStatusCode: http.StatusOK,
+			expectedBody:       []byte(`{"decision":{"allowed":false,"reason":"no rule allows POST registry.local/billing:1.0 of ci","checks":[{"rule":"ci","matched":false,"reason":"does not allow method POST"},{"rule":"operators","matched":false,"reason":"does not apply to the caller"}]},"request":{"identity":{"name":"ci","method":"api-key"},"method":"POST","image":"registry.local/billing:1.0"}}`),
+		},
+		{
+			description:        "explain a decision for another identity with the capability",
+			route:              "/api/authz/explain/billing/1.0?identity=bob&groups=oncall",
+			method:             "GET",
+			apiKey:             "oncall-key",
+			expectedStatusCode: http.StatusOK,
+			expectedBody:       []byte(`{"decision":{"allowed":false,"reason":"no rule allows GET registry.local/billing:1.0 of bob","checks":[{"rule":"ci","matched":false,"reason":"does not apply to the caller"},{"rule":"operators","matched":false,"reason":"does not match the image"}]},"request":{"identity":{"name":"bob","method":"","groups":["oncall"]},"method":"GET","image":"registry.local/billing:1.0"}}`),
+		},
+		{
+			description:        "refuse to explain for another identity without the capability",
+			route:              "/api/authz/explain/billing/1.0?identity=bob",
+			method:             "GET",
+			apiKey:             "ci-key",
+			expectedStatusCode: http.StatusForbidden,
+			expectedBody:       []byte(`{"error":true,"msg":"request denied by authorization policy: no rule allows authz:explain of ci","reason":"no rule allows authz:explain of ci","request_id":"test-request"}`),
+		},
+		{
+			description:        "grant admin capabilities through the policy",
+			route:              "/api/admin/images",
+			method:             "GET",
+			apiKey:             "oncall-key",
+			expectedStatusCode: http.StatusOK,
+			mockClient: func(mc *docker.MockClientInterface) {
+				mc.EXPECT().ImageList(gomock.Any(), types.ImageListOptions{}).Return(nil, nil)
+			},
+			expectedBody: []byte(`[]`),
+		},
+		{
+			description:        "deny admin capabilities not granted",
+			route:              "/api/admin/images",
+			method:             "GET",
+			apiKey:             "ci-key",
+			expectedStatusCode: http.StatusForbidden,
+			expectedBody:       []byte(`{"error":true,"msg":"forbidden","reason":"no rule allows images:read of ci","request_id":"test-request"}`),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			app := fiber.New()
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			dockerService := docker.NewMockServiceInterface(ctrl)
+			if test.mockService != nil {
+				test.mockService(dockerService)
+			}
+			dockerClient := docker.NewMockClientInterface(ctrl)
+			if test.mockClient != nil {
+				test.mockClient(dockerClient)
+			}
+			keys, err := auth.NewAPIKeys([]auth.Key{
+				{Identity: "ci", Hash: auth.HashKey("ci-key")},
+				{Identity: "alice", Groups: []string{"oncall"}, Hash: auth.HashKey("oncall-key")},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			routes.AddRoutes(app, routes.Dependencies{
+				Service:        dockerService,
+				Client:         dockerClient,
+				Registries:     testRegistries(t),
+				Authenticators: []auth.Authenticator{keys},
+				Authorization: &rbac.Policy{Rules: []rbac.Rule{
+					{Name: "ci", Identities: []string{"ci"}, Images: []string{"tools/*"}, Methods: []string{"GET"}},
+					{Name: "operators", Groups: []string{"oncall"}, Images: []string{"tools/*"}, Capabilities: []string{"images:read", rbac.AuthzExplain}},
+				}},
+			})
+			req := httptest.NewRequest(test.method, test.route, nil)
+			req.Header.Set(requestid.Header, testRequestID)
+			req.Header.Set(auth.APIKeyHeader, test.apiKey)
+			resp, err := app.Test(req, -1)
+			assert.NoError(t, err)
+			assert.Equalf(t, test.expectedStatusCode, resp.StatusCode, test.description)
+			body, err := ioutil.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equalf(t, string(test.expectedBody), string(body), test.description)
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package tests
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -10,13 +11,18 @@ import (
 	"testing"
 
 	"docker-operator/src/docker"
+	"docker-operator/src/policy"
+	"docker-operator/src/registry"
 	routes "docker-operator/src/v1"
+	"docker-operator/src/v1/requestid"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 )
 
+const testDigest = "6a92cd1fcdc8d8cdec60f33dda4db2cb1fcdcacf3410a8e05b3741f44a9b5998"
+
 func TestExecImageGET(t *testing.T) {
 	tests := []struct {
 		description        string
@@ -41,6 +47,142 @@ func TestExecImageGET(t *testing.T) {
 			},
 			expectedBody: []byte(`content of response`),
 		},
+		{
+			description:        "execute the image from a named registry",
+			route:              "/api/registries/mirror/exec/alpine/3.13",
+			method:             "GET",
+			expectedError:      false,
+			expectedStatusCode: http.StatusOK,
+			mockService: func(ms *docker.MockServiceInterface) *docker.MockServiceInterface {
+				ms.EXPECT().RunContainer("mirror.local/alpine:3.13", gomock.Any(), gomock.Any()).Return([]byte(`content of response`),
+					&docker.Headers{Header: map[string]string{}}, nil)
+				ms.EXPECT().ImageExists("mirror.local/alpine:3.13", gomock.Any()).Return(true)
+				return ms
+			},
+			expectedBody: []byte(`content of response`),
+		},
+		{
+			description:        "route the image to a registry by prefix",
+			route:              "/api/exec/mirror_alpine/3.13",
+			method:             "GET",
+			expectedError:      false,
+			expectedStatusCode: http.StatusOK,
+			mockService: func(ms *docker.MockServiceInterface) *docker.MockServiceInterface {
+				ms.EXPECT().RunContainer("mirror.local/alpine:3.13", gomock.Any(), gomock.Any()).Return([]byte(`content of response`),
+					&docker.Headers{Header: map[string]string{}}, nil)
+				ms.EXPECT().ImageExists("mirror.local/alpine:3.13", gomock.Any()).Return(true)
+				return ms
+			},
+			expectedBody: []byte(`content of response`),
+		},
+		{
+			description:        "return 404 for an unknown registry",
+			route:              "/api/registries/unknown/exec/alpine/3.13",
+			method:             "GET",
+			expectedError:      false,
+			expectedStatusCode: http.StatusNotFound,
+			mockService: func(ms *docker.MockServiceInterface) *docker.MockServiceInterface {
+				return ms
+			},
+			expectedBody: []byte(`{"error":true,"msg":"unknown registry unknown","request_id":"test-request"}`),
+		},
+		{
+			description:        "execute an image with a nested repository path",
+			route:              "/api/exec/team/project/tool/1.0",
+			method:             "GET",
+			expectedError:      false,
+			expectedStatusCode: http.StatusOK,
+			mockService: func(ms *docker.MockServiceInterface) *docker.MockServiceInterface {
+				ms.EXPECT().RunContainer("registry.local/team/project/tool:1.0", gomock.Any(), gomock.Any()).Return([]byte(`content of response`),
+					&docker.Headers{Header: map[string]string{}}, nil)
+				ms.EXPECT().ImageExists("registry.local/team/project/tool:1.0", gomock.Any()).Return(true)
+				return ms
+			},
+			expectedBody: []byte(`content of response`),
+		},
+		{
+			description:        "execute an image pinned to a digest",
+			route:              "/api/exec/team/tool@sha256:" + testDigest,
+			method:             "GET",
+			expectedError:      false,
+			expectedStatusCode: http.StatusOK,
+			mockService: func(ms *docker.MockServiceInterface) *docker.MockServiceInterface {
+				ms.EXPECT().RunContainer("registry.local/team/tool@sha256:"+testDigest, gomock.Any(), gomock.Any()).Return([]byte(`content of response`),
+					&docker.Headers{Header: map[string]string{}}, nil)
+				ms.EXPECT().ImageExists("registry.local/team/tool@sha256:"+testDigest, gomock.Any()).Return(true)
+				return ms
+			},
+			expectedBody: []byte(`content of response`),
+		},
+		{
+			description:        "return 400 for path tricks in the image name",
+			route:              "/api/exec/team/../tool/1.0",
+			method:             "GET",
+			expectedError:      false,
+			expectedStatusCode: http.StatusBadRequest,
+			mockService: func(ms *docker.MockServiceInterface) *docker.MockServiceInterface {
+				return ms
+			},
+			expectedBody: []byte(`{"error":true,"msg":"invalid image name \"team/../tool\": invalid reference format","request_id":"test-request"}`),
+		},
+		{
+			description:        "return 400 for an image name with a registry host",
+			route:              "/api/exec/evil.example.com/tool/1.0",
+			method:             "GET",
+			expectedError:      false,
+			expectedStatusCode: http.StatusBadRequest,
+			mockService: func(ms *docker.MockServiceInterface) *docker.MockServiceInterface {
+				return ms
+			},
+			expectedBody: []byte(`{"error":true,"msg":"invalid image name \"evil.example.com/tool\": registry host evil.example.com is not allowed","request_id":"test-request"}`),
+		},
+		{
+			description:        "return 400 for an image name with a tag",
+			route:              "/api/exec/team/tool:evil/1.0",
+			method:             "GET",
+			expectedError:      false,
+			expectedStatusCode: http.StatusBadRequest,
+			mockService: func(ms *docker.MockServiceInterface) *docker.MockServiceInterface {
+				return ms
+			},
+			expectedBody: []byte(`{"error":true,"msg":"invalid image name \"team/tool:evil\": a tag is not allowed in the name","request_id":"test-request"}`),
+		},
+		{
+			description:        "return 400 for an image name with a tag and a digest",
+			route:              "/api/exec/team/tool:evil@sha256:2222222222222222222222222222222222222222222222222222222222222222",
+			method:             "GET",
+			expectedError:      false,
+			expectedStatusCode: http.StatusBadRequest,
+			mockService: func(ms *docker.MockServiceInterface) *docker.MockServiceInterface {
+				return ms
+			},
+			expectedBody: []byte(`{"error":true,"msg":"invalid image name \"team/tool:evil\": a tag is not allowed in the name","request_id":"test-request"}`),
+		},
+		{
+			description:        "return 400 for a malformed digest",
+			route:              "/api/exec/tool@sha256:abc",
+			method:             "GET",
+			expectedError:      false,
+			expectedStatusCode: http.StatusBadRequest,
+			mockService: func(ms *docker.MockServiceInterface) *docker.MockServiceInterface {
+				return ms
+			},
+			expectedBody: []byte(`{"error":true,"msg":"invalid digest \"sha256:abc\": invalid checksum digest length","request_id":"test-request"}`),
+		},
+		{
+			description:        "return 403 when the policy denies the image",
+			route:              "/api/exec/alpine/3.13",
+			method:             "GET",
+			expectedError:      false,
+			expectedStatusCode: http.StatusForbidden,
+			mockService: func(ms *docker.MockServiceInterface) *docker.MockServiceInterface {
+				ms.EXPECT().RunContainer(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, nil,
+					&policy.DeniedError{Image: "registry.local/alpine:3.13", Reason: "not on the allowlist"})
+				ms.EXPECT().ImageExists(gomock.Any(), gomock.Any()).Return(true)
+				return ms
+			},
+			expectedBody: []byte(`{"error":true,"msg":"image registry.local/alpine:3.13 denied by policy: not on the allowlist","reason":"not on the allowlist","request_id":"test-request"}`),
+		},
 		{
 			description:        "return 500 internal error",
 			route:              "/api/exec/alpine/latest",
@@ -53,7 +195,7 @@ func TestExecImageGET(t *testing.T) {
 				ms.EXPECT().ImageExists(gomock.Any(), gomock.Any()).Return(true)
 				return ms
 			},
-			expectedBody: []byte(`{"error":true,"msg":"internal error"}`),
+			expectedBody: []byte(`{"error":true,"msg":"internal error","request_id":"test-request"}`),
 		},
 	}
 
@@ -63,8 +205,9 @@ func TestExecImageGET(t *testing.T) {
 			app := fiber.New()
 			ctrl := gomock.NewController(t)
 			dockerService := test.mockService(docker.NewMockServiceInterface(ctrl))
-			routes.AddRoutes(app, dockerService)
+			routes.AddRoutes(app, routes.Dependencies{Service: dockerService, Registries: testRegistries(t)})
 			req := httptest.NewRequest(test.method, test.route, nil)
+			req.Header.Set(requestid.Header, testRequestID)
 			resp, err := app.Test(req, -1) // the -1 disables request latency
 			assert.Equalf(t, test.expectedError, err != nil, test.description)
 			if test.expectedError {
@@ -127,7 +270,7 @@ func TestExecImagePOST(t *testing.T) {
 					fmt.Errorf("internal error")).AnyTimes()
 				return ms
 			},
-			expectedBody: []byte(`{"error":true,"msg":"internal error"}`),
+			expectedBody: []byte(`{"error":true,"msg":"internal error","request_id":"test-request"}`),
 		},
 	}
 
@@ -137,8 +280,9 @@ func TestExecImagePOST(t *testing.T) {
 			app := fiber.New()
 			ctrl := gomock.NewController(t)
 			dockerService := test.mockService(docker.NewMockServiceInterface(ctrl))
-			routes.AddRoutes(app, dockerService)
+			routes.AddRoutes(app, routes.Dependencies{Service: dockerService, Registries: testRegistries(t)})
 			req := httptest.NewRequest(test.method, test.route, test.requestBody)
+			req.Header.Set(requestid.Header, testRequestID)
 			req.Header.Set("Content-Type", "application/json")
 			resp, err := app.Test(req, -1) // the -1 disables request latency
 			assert.Equalf(t, test.expectedError, err != nil, test.description)
@@ -160,3 +304,130 @@ func TestExecImagePOST(t *testing.T) {
 		})
 	}
 }
+
+func testRegistries(t *testing.T) *registry.Registries {
+	registries, err := registry.New(
+		&registry.Registry{Name: "default", Host: "registry.local", Default: true},
+		&registry.Registry{Name: "mirror", Host: "mirror.local", Prefixes: []string{"mirror_"}, StripPrefix: true},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return registries
+}
+
+// runsImage answers the runs of the mocked service with out, reporting the digest
+// of the image that ran like the docker service does.
+func runsImage(digest string, out []byte) func(string, []string, context.Context) ([]byte, *docker.Headers, error) {
+	return func(_ string, _ []string, ctx context.Context) ([]byte, *docker.Headers, error) {
+		docker.ImageDigestResolved(ctx, digest)
+		return out, &docker.Headers{}, nil
+	}
+}
+
+func TestExecConditionalGET(t *testing.T) {
+	const etag = `"2689367b205c16ce32ed4200942b8b8b1e262dfc70d9bc9fbc77c49699a4f1df"`
+	const lastModified = "Tue, 01 Jun 2021 12:00:00 GMT"
+	tests := []struct {
+		description        string
+		method             string
+		headers            map[string]string
+		outputHeaders      map[string]string
+		expectedStatusCode int
+		expectedBody       string
+		expectedETag       string
+	}{
+		{
+			description:        "return the output hash as etag",
+			method:             "GET",
+			expectedStatusCode: http.StatusOK,
+			expectedBody:       "ok",
+			expectedETag:       etag,
+		},
+		{
+			description:        "return 304 when the etag matches",
+			method:             "GET",
+			headers:            map[string]string{"If-None-Match": `"other", W/` + etag},
+			expectedStatusCode: http.StatusNotModified,
+			expectedETag:       etag,
+		},
+		{
+			description:        "return the output when the etag changed",
+			method:             "GET",
+			headers:            map[string]string{"If-None-Match": `"other"`},
+			expectedStatusCode: http.StatusOK,
+			expectedBody:       "ok",
+			expectedETag:       etag,
+		},
+		{
+			description:        "keep the etag of the image",
+			method:             "GET",
+			headers:            map[string]string{"If-None-Match": `"v1"`},
+			outputHeaders:      map[string]string{"ETag": `"v1"`},
+			expectedStatusCode: http.StatusNotModified,
+			expectedETag:       `"v1"`,
+		},
+		{
+			description:        "return 304 when not modified since",
+			method:             "GET",
+			headers:            map[string]string{"If-Modified-Since": lastModified},
+			outputHeaders:      map[string]string{"Last-Modified": lastModified},
+			expectedStatusCode: http.StatusNotModified,
+			expectedETag:       etag,
+		},
+		{
+			description:        "return the output when modified since",
+			method:             "GET",
+			headers:            map[string]string{"If-Modified-Since": "Mon, 31 May 2021 12:00:00 GMT"},
+			outputHeaders:      map[string]string{"Last-Modified": lastModified},
+			expectedStatusCode: http.StatusOK,
+			expectedBody:       "ok",
+			expectedETag:       etag,
+		},
+		{
+			description:        "ignore if-modified-since without last-modified",
+			method:             "GET",
+			headers:            map[string]string{"If-Modified-Since": lastModified},
+			expectedStatusCode: http.StatusOK,
+			expectedBody:       "ok",
+			expectedETag:       etag,
+		},
+		{
+			description:        "never return 304 to POST",
+			method:             "POST",
+			headers:            map[string]string{"If-None-Match": etag},
+			expectedStatusCode: http.StatusOK,
+			expectedBody:       "ok",
+			expectedETag:       etag,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			app := fiber.New()
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			dockerService := docker.NewMockServiceInterface(ctrl)
+			dockerService.EXPECT().ImageExists(gomock.Any(), gomock.Any()).Return(true)
+			output := func(string, []string, context.Context) ([]byte, *docker.Headers, error) {
+				return []byte("ok"), &docker.Headers{Header: test.outputHeaders}, nil
+			}
+			dockerService.EXPECT().RunContainer(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(output).AnyTimes()
+			dockerService.EXPECT().RunContainerPost(gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(output).AnyTimes()
+			routes.AddRoutes(app, routes.Dependencies{
+				Service:    dockerService,
+				Registries: testRegistries(t),
+			})
+			req := httptest.NewRequest(test.method, "/api/exec/alpine/3.13", nil)
+			for name, value := range test.headers {
+				req.Header.Set(name, value)
+			}
+			resp, err := app.Test(req, -1)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedStatusCode, resp.StatusCode)
+			assert.Equal(t, test.expectedETag, resp.Header.Get("ETag"))
+			body, err := ioutil.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedBody, string(body))
+		})
+	}
+}
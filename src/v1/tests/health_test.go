@@ -38,7 +38,7 @@ func Test_healthCheckHandler(t *testing.T) {
 	app := fiber.New()
 	ctrl := gomock.NewController(t)
 	dockerService := docker.NewMockServiceInterface(ctrl)
-	routes.AddRoutes(app, dockerService)
+	routes.AddRoutes(app, routes.Dependencies{Service: dockerService, Registries: testRegistries(t)})
 	for _, test := range tests {
 		req := httptest.NewRequest(test.method, test.route, test.body)
 		resp, err := app.Test(req, -1) // the -1 disables request latency
@@ -0,0 +1,355 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"docker-operator/src/artifacts"
+	"docker-operator/src/docker"
+	"docker-operator/src/history"
+	"docker-operator/src/idempotency"
+	"docker-operator/src/stale"
+	routes "docker-operator/src/v1"
+	dockerroutes "docker-operator/src/v1/docker"
+	idempotencyroutes "docker-operator/src/v1/idempotency"
+	"docker-operator/src/v1/requestid"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecutionHistory(t *testing.T) {
+	store, err := history.Open(history.Config{Path: filepath.Join(t.TempDir(), "history.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	app := fiber.New()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dockerService := docker.NewMockServiceInterface(ctrl)
+	dockerService.EXPECT().ImageExists(gomock.Any(), gomock.Any()).Return(true).Times(2)
+	dockerService.EXPECT().RunContainer("registry.local/tools/report:1.0", []string{"a=1"}, gomock.Any()).
+		DoAndReturn(runsImage("sha256:"+testDigest, []byte("ok")))
+	dockerService.EXPECT().RunContainerPost("registry.local/alpine:3.13", gomock.Any(), gomock.Any()).Return(nil, nil, fmt.Errorf("internal error"))
+	routes.AddRoutes(app, routes.Dependencies{
+		Service:    dockerService,
+		Registries: testRegistries(t),
+		History:    store,
+		AdminToken: testAdminToken,
+	})
+
+	req := httptest.NewRequest("GET", "/api/exec/tools/report/1.0?a=1", nil)
+	req.Header.Set(requestid.Header, "req-1")
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	first := resp.Header.Get(dockerroutes.ExecutionHeader)
+	assert.NotEmpty(t, first)
+	req = httptest.NewRequest("POST", "/api/exec/alpine/3.13", nil)
+	req.Header.Set(requestid.Header, "req-2")
+	resp, err = app.Test(req, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	second := resp.Header.Get(dockerroutes.ExecutionHeader)
+
+	list := func(query string) (int, history.Page) {
+		req := httptest.NewRequest("GET", "/api/executions"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+testAdminToken)
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+		body, err := ioutil.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		var page history.Page
+		json.Unmarshal(body, &page)
+		return resp.StatusCode, page
+	}
+
+	status, page := list("")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 2, page.Total)
+	assert.Equal(t, second, page.Records[0].ID)
+	assert.Equal(t, "req-2", page.Records[0].RequestID)
+	assert.Equal(t, history.StatusError, page.Records[0].Status)
+	assert.Equal(t, "internal error", page.Records[0].Error)
+	assert.Equal(t, first, page.Records[1].ID)
+	assert.Equal(t, "req-1", page.Records[1].RequestID)
+	assert.Equal(t, "tools/report", page.Records[1].Image)
+	assert.Equal(t, "1.0", page.Records[1].Tag)
+	assert.Equal(t, "sha256:"+testDigest, page.Records[1].Digest)
+	assert.Equal(t, "anonymous", page.Records[1].Caller)
+	assert.Equal(t, "2689367b205c16ce32ed4200942b8b8b1e262dfc70d9bc9fbc77c49699a4f1df", page.Records[1].OutputHash)
+	assert.Equal(t, 2, page.Records[1].OutputSize)
+	assert.NotEmpty(t, page.Records[1].ParamsHash)
+	assert.Empty(t, page.Records[1].Params)
+	// the inputs are not kept, the executions cannot be replayed
+	stored, ok := store.Get(first)
+	assert.True(t, ok)
+	assert.Empty(t, stored.Params)
+	req = httptest.NewRequest("POST", "/api/executions/"+first+"/replay", nil)
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	resp, err = app.Test(req, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	status, page = list("?image=tools/report&status=success&caller=anonymous")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 1, page.Total)
+	assert.Equal(t, first, page.Records[0].ID)
+	status, page = list("?limit=1&offset=1")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 2, page.Total)
+	assert.Equal(t, first, page.Records[0].ID)
+	status, _ = list("?since=yesterday")
+	assert.Equal(t, http.StatusBadRequest, status)
+
+	resp, err = app.Test(httptest.NewRequest("GET", "/api/executions", nil), -1)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestExecutionLogs(t *testing.T) {
+	archive, err := artifacts.New(artifacts.Config{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, archive.Save("req-1", []byte("partial output"), []byte("panic: boom")))
+	app := fiber.New()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	routes.AddRoutes(app, routes.Dependencies{
+		Service:    docker.NewMockServiceInterface(ctrl),
+		Registries: testRegistries(t),
+		Artifacts:  archive,
+		AdminToken: testAdminToken,
+	})
+	tests := []struct {
+		description        string
+		route              string
+		expectedStatusCode int
+		expectedBody       string
+	}{
+		{
+			description:        "return the stderr of an execution",
+			route:              "/api/executions/req-1/logs?stream=stderr",
+			expectedStatusCode: http.StatusOK,
+			expectedBody:       "panic: boom",
+		},
+		{
+			description:        "return 404 for an execution without logs",
+			route:              "/api/executions/req-2/logs",
+			expectedStatusCode: http.StatusNotFound,
+			expectedBody:       `{"error":true,"msg":"logs not found","request_id":"test-request"}`,
+		},
+		{
+			description:        "return 400 for an unknown stream",
+			route:              "/api/executions/req-1/logs?stream=stdin",
+			expectedStatusCode: http.StatusBadRequest,
+			expectedBody:       `{"error":true,"msg":"invalid stream \"stdin\", expected stdout or stderr","request_id":"test-request"}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			req := httptest.NewRequest("GET", test.route, nil)
+			req.Header.Set(requestid.Header, testRequestID)
+			req.Header.Set("Authorization", "Bearer "+testAdminToken)
+			resp, err := app.Test(req, -1)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedStatusCode, resp.StatusCode)
+			body, err := ioutil.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedBody, string(body))
+		})
+	}
+
+	req := httptest.NewRequest("GET", "/api/executions/req-1/logs", nil)
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err)
+	var logs artifacts.Logs
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&logs))
+	assert.Equal(t, "req-1", logs.Execution)
+	assert.Equal(t, "partial output", logs.Stdout)
+	assert.Equal(t, "panic: boom", logs.Stderr)
+}
+
+func TestReplayExecution(t *testing.T) {
+	store, err := history.Open(history.Config{Path: filepath.Join(t.TempDir(), "history.db"), ReplayInputs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	app := fiber.New()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dockerService := docker.NewMockServiceInterface(ctrl)
+	pinned := "registry.local/tools/report@sha256:" + testDigest
+	dockerService.EXPECT().ImageExists(gomock.Any(), gomock.Any()).Return(true).Times(2)
+	dockerService.EXPECT().RunContainerPost("registry.local/tools/report:1.0", []string{"POST_DATA={}"}, gomock.Any()).
+		DoAndReturn(runsImage("sha256:"+testDigest, []byte("ok")))
+	dockerService.EXPECT().RunContainer("registry.local/alpine:3.13", gomock.Any(), gomock.Any()).Return(nil, nil, fmt.Errorf("internal error"))
+	dockerService.EXPECT().RunContainerPost(pinned, []string{"POST_DATA={}"}, gomock.Any()).
+		Return([]byte("changed"), &docker.Headers{Header: map[string]string{"Content-Type": "text/plain"}}, nil).Times(2)
+	routes.AddRoutes(app, routes.Dependencies{
+		Service:     dockerService,
+		Registries:  testRegistries(t),
+		History:     store,
+		Idempotency: idempotency.New(idempotency.Config{Window: time.Minute}),
+		AdminToken:  testAdminToken,
+	})
+
+	req := httptest.NewRequest("POST", "/api/exec/tools/report/1.0", strings.NewReader("{}"))
+	req.Header.Set(requestid.Header, "req-1")
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	first := resp.Header.Get(dockerroutes.ExecutionHeader)
+	assert.Equal(t, "req-1", first)
+	req = httptest.NewRequest("GET", "/api/exec/alpine/3.13", nil)
+	req.Header.Set(requestid.Header, "req-2")
+	resp, err = app.Test(req, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	second := resp.Header.Get(dockerroutes.ExecutionHeader)
+
+	replay := func(id string) (int, string) {
+		req := httptest.NewRequest("POST", "/api/executions/"+id+"/replay", nil)
+		req.Header.Set(requestid.Header, "replay")
+		req.Header.Set("Authorization", "Bearer "+testAdminToken)
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+		body, err := ioutil.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+	status, body := replay(first)
+	assert.Equal(t, http.StatusOK, status)
+	var result dockerroutes.ReplayResult
+	assert.NoError(t, json.Unmarshal([]byte(body), &result))
+	assert.NotEqual(t, first, result.Execution)
+	assert.Equal(t, first, result.ReplayOf)
+	assert.Equal(t, pinned, result.Image)
+	assert.Equal(t, "changed", result.Output)
+	assert.Equal(t, map[string]string{"Content-Type": "text/plain"}, result.Headers)
+	assert.Equal(t, "2689367b205c16ce32ed4200942b8b8b1e262dfc70d9bc9fbc77c49699a4f1df", result.OriginalOutputHash)
+	assert.NotEqual(t, result.OriginalOutputHash, result.OutputHash)
+	assert.False(t, result.Identical)
+	replayed, ok := store.Get(result.Execution)
+	assert.True(t, ok)
+	assert.Equal(t, first, replayed.ReplayOf)
+	assert.Equal(t, "replay", replayed.RequestID)
+
+	status, body = replay(second)
+	assert.Equal(t, http.StatusUnprocessableEntity, status)
+	assert.Equal(t, fmt.Sprintf(`{"error":true,"msg":"execution %s has no digest to pin, it did not run","request_id":"replay"}`, second), body)
+	status, body = replay("unknown")
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Equal(t, `{"error":true,"msg":"execution not found","request_id":"replay"}`, body)
+
+	// a retried replay runs once
+	var executions []string
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("POST", "/api/executions/"+first+"/replay", nil)
+		req.Header.Set("Authorization", "Bearer "+testAdminToken)
+		req.Header.Set(idempotencyroutes.KeyHeader, "replay-1")
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		executions = append(executions, resp.Header.Get(dockerroutes.ExecutionHeader))
+	}
+	assert.Equal(t, executions[0], executions[1])
+}
+
+func TestExecutionHistory_RepeatedRequestID(t *testing.T) {
+	store, err := history.Open(history.Config{Path: filepath.Join(t.TempDir(), "history.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	app := fiber.New()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dockerService := docker.NewMockServiceInterface(ctrl)
+	dockerService.EXPECT().ImageExists(gomock.Any(), gomock.Any()).Return(true).Times(2)
+	dockerService.EXPECT().RunContainer("registry.local/tools/report:1.0", gomock.Any(), gomock.Any()).Return([]byte("ok"), &docker.Headers{}, nil).Times(2)
+	routes.AddRoutes(app, routes.Dependencies{
+		Service:    dockerService,
+		Registries: testRegistries(t),
+		History:    store,
+		AdminToken: testAdminToken,
+	})
+
+	var executions []string
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", "/api/exec/tools/report/1.0", nil)
+		req.Header.Set(requestid.Header, "req-1")
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		executions = append(executions, resp.Header.Get(dockerroutes.ExecutionHeader))
+	}
+	// the request id names one execution, the one sent again gets a generated id
+	assert.Equal(t, "req-1", executions[0])
+	assert.Len(t, executions[1], 32)
+	for _, id := range executions {
+		record, ok := store.Get(id)
+		assert.True(t, ok)
+		assert.Equal(t, "req-1", record.RequestID)
+	}
+}
+
+func TestExecutionHistory_Stale(t *testing.T) {
+	store, err := history.Open(history.Config{Path: filepath.Join(t.TempDir(), "history.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	outputs, err := stale.New(stale.Config{Images: []stale.Rule{{Image: "tools/*", MaxStale: time.Minute}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dockerService := docker.NewMockServiceInterface(ctrl)
+	dockerService.EXPECT().ImageExists(gomock.Any(), gomock.Any()).Return(true).Times(2)
+	dockerService.EXPECT().ImageDigest(gomock.Any(), gomock.Any()).Return("sha256:"+testDigest, nil).AnyTimes()
+	gomock.InOrder(
+		dockerService.EXPECT().RunContainer("registry.local/tools/report:1.0", gomock.Any(), gomock.Any()).
+			Return([]byte("ok"), &docker.Headers{Header: map[string]string{"Content-Type": "text/plain"}}, nil),
+		dockerService.EXPECT().RunContainer("registry.local/tools/report:1.0", gomock.Any(), gomock.Any()).
+			Return(nil, nil, &docker.DaemonError{Err: fmt.Errorf("could not start container with: daemon unavailable")}),
+	)
+	routes.AddRoutes(app, routes.Dependencies{
+		Service:    stale.NewService(dockerService, outputs),
+		Registries: testRegistries(t),
+		History:    store,
+		AdminToken: testAdminToken,
+	})
+
+	var executions []string
+	for i := 0; i < 2; i++ {
+		resp, err := app.Test(httptest.NewRequest("GET", "/api/exec/tools/report/1.0", nil), -1)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		executions = append(executions, resp.Header.Get(dockerroutes.ExecutionHeader))
+	}
+	fresh, ok := store.Get(executions[0])
+	assert.True(t, ok)
+	assert.Equal(t, history.StatusSuccess, fresh.Status)
+	assert.False(t, fresh.Stale)
+	// the stale answer does not hide the failure of the execution
+	served, ok := store.Get(executions[1])
+	assert.True(t, ok)
+	assert.Equal(t, history.StatusError, served.Status)
+	assert.True(t, served.Stale)
+	assert.Equal(t, "could not start container with: daemon unavailable", served.Error)
+}
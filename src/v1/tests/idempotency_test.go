@@ -0,0 +1,100 @@
+package tests
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"docker-operator/src/docker"
+	"docker-operator/src/idempotency"
+	routes "docker-operator/src/v1"
+	idempotencyroutes "docker-operator/src/v1/idempotency"
+	"docker-operator/src/v1/requestid"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	app := fiber.New()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dockerService := docker.NewMockServiceInterface(ctrl)
+	dockerService.EXPECT().ImageExists(gomock.Any(), gomock.Any()).Return(true).AnyTimes()
+	dockerService.EXPECT().RunContainerPost("registry.local/tools/mailer:1", []string{`POST_DATA={"to":"a"}`}, gomock.Any()).
+		Return([]byte("sent"), &docker.Headers{Header: map[string]string{"Content-Type": "text/plain"}}, nil)
+	dockerService.EXPECT().RunContainerPost("registry.local/tools/mailer:1", []string{`POST_DATA={"to":"b"}`}, gomock.Any()).
+		Return(nil, nil, fmt.Errorf("internal error"))
+	gomock.InOrder(
+		dockerService.EXPECT().RunContainerPost("registry.local/tools/mailer:1", []string{`POST_DATA={"to":"c"}`}, gomock.Any()).
+			Return(nil, nil, &docker.DaemonError{Err: fmt.Errorf("daemon unavailable")}),
+		dockerService.EXPECT().RunContainerPost("registry.local/tools/mailer:1", []string{`POST_DATA={"to":"c"}`}, gomock.Any()).
+			Return([]byte("sent"), &docker.Headers{}, nil),
+	)
+	dockerService.EXPECT().RunContainer("registry.local/tools/mailer:1", nil, gomock.Any()).
+		Return([]byte("listed"), &docker.Headers{}, nil).Times(2)
+	routes.AddRoutes(app, routes.Dependencies{
+		Service:     dockerService,
+		Registries:  testRegistries(t),
+		Idempotency: idempotency.New(idempotency.Config{Window: time.Minute}),
+	})
+	exec := func(method, key, body string) (*http.Response, string) {
+		req := httptest.NewRequest(method, "/api/exec/tools/mailer/1", strings.NewReader(body))
+		req.Header.Set(requestid.Header, testRequestID)
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set(idempotencyroutes.KeyHeader, key)
+		}
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+		out, err := ioutil.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		return resp, string(out)
+	}
+
+	resp, body := exec("POST", "key-1", `{"to":"a"}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "sent", body)
+	assert.Empty(t, resp.Header.Get(idempotencyroutes.ReplayedHeader))
+
+	resp, body = exec("POST", "key-1", `{"to":"a"}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "sent", body)
+	assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "true", resp.Header.Get(idempotencyroutes.ReplayedHeader))
+
+	resp, body = exec("POST", "key-1", `{"to":"b"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.Equal(t, `{"error":true,"msg":"idempotency key was used for another request","request_id":"test-request"}`, body)
+
+	// failed executions are results too
+	resp, _ = exec("POST", "key-2", `{"to":"b"}`)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	resp, body = exec("POST", "key-2", `{"to":"b"}`)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Equal(t, `{"error":true,"msg":"internal error","request_id":"test-request"}`, body)
+	assert.Equal(t, "true", resp.Header.Get(idempotencyroutes.ReplayedHeader))
+
+	// failures of the infrastructure are not, the request can be retried
+	resp, _ = exec("POST", "key-4", `{"to":"c"}`)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	resp, body = exec("POST", "key-4", `{"to":"c"}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "sent", body)
+	assert.Empty(t, resp.Header.Get(idempotencyroutes.ReplayedHeader))
+
+	// GET requests are not concerned
+	resp, body = exec("GET", "key-3", "")
+	assert.Equal(t, "listed", body)
+	resp, body = exec("GET", "key-3", "")
+	assert.Equal(t, "listed", body)
+	assert.Empty(t, resp.Header.Get(idempotencyroutes.ReplayedHeader))
+
+	resp, _ = exec("POST", strings.Repeat("k", 256), `{"to":"a"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
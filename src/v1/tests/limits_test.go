@@ -0,0 +1,49 @@
+package tests
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"docker-operator/src/docker"
+	"docker-operator/src/limits"
+	routes "docker-operator/src/v1"
+	"docker-operator/src/v1/requestid"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecRateLimit(t *testing.T) {
+	app := fiber.New()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dockerService := docker.NewMockServiceInterface(ctrl)
+	dockerService.EXPECT().ImageExists("registry.local/alpine:3.13", gomock.Any()).Return(true)
+	dockerService.EXPECT().RunContainer("registry.local/alpine:3.13", nil, gomock.Any()).Return([]byte("ok"), &docker.Headers{}, nil)
+	limiter, err := limits.New(limits.Config{Default: limits.Limit{Rate: 1, Period: time.Hour}}, limits.NewMemoryStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes.AddRoutes(app, routes.Dependencies{Service: dockerService, Registries: testRegistries(t), Limiter: limiter})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/api/exec/alpine/3.13", nil), -1)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "1", resp.Header.Get("RateLimit-Limit"))
+	assert.Equal(t, "0", resp.Header.Get("RateLimit-Remaining"))
+
+	req := httptest.NewRequest("GET", "/api/exec/alpine/3.13", nil)
+	req.Header.Set(requestid.Header, testRequestID)
+	resp, err = app.Test(req, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "0", resp.Header.Get("RateLimit-Remaining"))
+	assert.NotEmpty(t, resp.Header.Get("Retry-After"))
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"error":true,"msg":"too many requests: rate limit of 1 executions per 1h0m0s exceeded","request_id":"test-request"}`, string(body))
+}
@@ -0,0 +1,69 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"docker-operator/src/docker"
+	"docker-operator/src/redact"
+	routes "docker-operator/src/v1"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestExecRedaction(t *testing.T) {
+	os.Setenv("CONTENT_LENGTH", "10")
+	defer os.Unsetenv("CONTENT_LENGTH")
+	core, logs := observer.New(zap.InfoLevel)
+	defer zap.ReplaceGlobals(zap.New(core))()
+	redactor, err := redact.New(redact.Config{
+		Keys:    []string{"token"},
+		Headers: []string{"Set-Cookie"},
+		Content: []redact.ContentRule{{Image: "billing/*", Log: false}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dockerService := docker.NewMockServiceInterface(ctrl)
+	dockerService.EXPECT().ImageExists(gomock.Any(), gomock.Any()).Return(true).Times(2)
+	dockerService.EXPECT().RunContainer("registry.local/tools/report:1.0", []string{"a=1&token=secret"}, gomock.Any()).
+		Return([]byte("report"), &docker.Headers{Header: map[string]string{"Set-Cookie": "session=1"}}, nil)
+	dockerService.EXPECT().RunContainerPost("registry.local/billing/invoices:1.0", []string{`POST_DATA={"token":"secret"}`}, gomock.Any()).
+		Return([]byte("invoice"), &docker.Headers{}, nil)
+	routes.AddRoutes(app, routes.Dependencies{Service: dockerService, Registries: testRegistries(t), Redactor: redactor})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/api/exec/tools/report/1.0?a=1&token=secret", nil), -1)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, err = app.Test(httptest.NewRequest("POST", "/api/exec/billing/invoices/1.0", strings.NewReader(`{"token":"secret"}`)), -1)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var events []map[string]interface{}
+	for _, entry := range logs.FilterMessage("incoming request").All() {
+		data, err := json.Marshal(entry.ContextMap()["request"])
+		assert.NoError(t, err)
+		var event map[string]interface{}
+		assert.NoError(t, json.Unmarshal(data, &event))
+		events = append(events, event)
+	}
+	assert.Len(t, events, 2)
+	assert.Equal(t, []interface{}{"a=1&token=***"}, events[0]["params"])
+	assert.Equal(t, map[string]interface{}{"Set-Cookie": "***"}, events[0]["headers"])
+	assert.Equal(t, "report", events[0]["content"])
+	assert.Equal(t, "845e91831319e89c4d656bdb80c278ac09a7230d61e5dfd2e1b1fbb436ac8917", events[0]["content_sha256"])
+	assert.Equal(t, []interface{}{`POST_DATA={"token":"***"}`}, events[1]["params"])
+	assert.Nil(t, events[1]["content"])
+	assert.NotEmpty(t, events[1]["content_sha256"])
+}
@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"docker-operator/log"
+	"docker-operator/src/common"
+	"docker-operator/src/docker"
+	"docker-operator/src/execution"
+	routes "docker-operator/src/v1"
+	dockerroutes "docker-operator/src/v1/docker"
+	"docker-operator/src/v1/requestid"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+const testRequestID = "test-request"
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		description  string
+		requestID    string
+		expectedID   func(id string) bool
+		runError     error
+		expectedBody string
+	}{
+		{
+			description: "echo the request id of the caller",
+			requestID:   "req-1",
+			expectedID:  func(id string) bool { return id == "req-1" },
+		},
+		{
+			description: "generate a request id when there is none",
+			expectedID:  func(id string) bool { return len(id) == 32 },
+		},
+		{
+			description: "replace an invalid request id",
+			requestID:   "no spaces\tallowed",
+			expectedID:  func(id string) bool { return len(id) == 32 },
+		},
+		{
+			description:  "add the request id to error bodies",
+			requestID:    "req-2",
+			expectedID:   func(id string) bool { return id == "req-2" },
+			runError:     docker.ContainerRunError,
+			expectedBody: `{"error":true,"msg":"error occured while running the image","request_id":"req-2"}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			dockerService := docker.NewMockServiceInterface(ctrl)
+			var runID string
+			var running execution.Execution
+			executions := execution.NewRegistry(nil)
+			dockerService.EXPECT().ImageExists("registry.local/alpine:3.13", gomock.Any()).Return(true)
+			dockerService.EXPECT().RunContainer("registry.local/alpine:3.13", gomock.Any(), gomock.Any()).
+				DoAndReturn(func(image string, params []string, ctx context.Context) ([]byte, *docker.Headers, error) {
+					runID = log.RequestID(ctx)
+					running = executions.List()[0]
+					if test.runError != nil {
+						return nil, nil, test.runError
+					}
+					return []byte("ok"), &docker.Headers{}, nil
+				})
+			app := fiber.New()
+			routes.AddRoutes(app, routes.Dependencies{Service: dockerService, Registries: testRegistries(t), Executions: executions})
+
+			req := httptest.NewRequest("GET", "/api/exec/alpine/3.13", nil)
+			if test.requestID != "" {
+				req.Header.Set(requestid.Header, test.requestID)
+			}
+			resp, err := app.Test(req, -1)
+			assert.NoError(t, err)
+			id := resp.Header.Get(requestid.Header)
+			assert.Truef(t, test.expectedID(id), "unexpected request id %q", id)
+			assert.Equal(t, id, runID)
+			assert.Equal(t, id, running.RequestID)
+			// the request id names the execution
+			assert.Equal(t, id, running.ID)
+			assert.Equal(t, running.ID, resp.Header.Get(dockerroutes.ExecutionHeader))
+			if test.expectedBody != "" {
+				assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+				body, err := ioutil.ReadAll(resp.Body)
+				assert.NoError(t, err)
+				assert.Equal(t, test.expectedBody, string(body))
+			}
+		})
+	}
+}
+
+func TestRequestID_ErrorHandler(t *testing.T) {
+	app := fiber.New(fiber.Config{ErrorHandler: routes.StandardErrorHandler})
+	app.Use(requestid.New())
+	app.Get("/fail", func(c *fiber.Ctx) error {
+		return common.HTTPError("gone", fiber.StatusGone)
+	})
+	req := httptest.NewRequest("GET", "/fail", nil)
+	req.Header.Set(requestid.Header, testRequestID)
+	resp, err := app.Test(req, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusGone, resp.StatusCode)
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"s":"error","errmsg":"gone","request_id":"test-request"}`, string(body))
+}
@@ -0,0 +1,72 @@
+package tests
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"docker-operator/src/docker"
+	"docker-operator/src/responsecache"
+	routes "docker-operator/src/v1"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseCache(t *testing.T) {
+	cache, err := responsecache.New(responsecache.Config{
+		Images: []responsecache.Rule{{Image: "tools/*", TTL: time.Minute, Vary: []string{"Accept-Language"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	dockerService := docker.NewMockServiceInterface(ctrl)
+	dockerService.EXPECT().ImageExists("registry.local/tools/report:1.0", gomock.Any()).Return(true).AnyTimes()
+	dockerService.EXPECT().ImageDigest("registry.local/tools/report:1.0", gomock.Any()).Return("sha256:"+testDigest, nil).AnyTimes()
+	dockerService.EXPECT().RunContainer("registry.local/tools/report:1.0", []string{"a=1"}, gomock.Any()).
+		Return([]byte("ok"), &docker.Headers{Header: map[string]string{"Content-Type": "text/plain"}}, nil).Times(3)
+	routes.AddRoutes(app, routes.Dependencies{
+		Service:       responsecache.NewService(dockerService, cache),
+		Registries:    testRegistries(t),
+		ResponseCache: cache,
+		AdminToken:    testAdminToken,
+	})
+	exec := func(language string) string {
+		req := httptest.NewRequest("GET", "/api/exec/tools/report/1.0?a=1", nil)
+		req.Header.Set("Accept-Language", language)
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		body, err := ioutil.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "ok", string(body))
+		assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+		return resp.Header.Get(responsecache.StatusHeader)
+	}
+
+	assert.Equal(t, "MISS", exec("en"))
+	assert.Equal(t, "HIT", exec("en"))
+	assert.Equal(t, "MISS", exec("fr"))
+
+	purge := func(token string) (int, string) {
+		req := httptest.NewRequest("DELETE", "/api/admin/responses?image=tools/*", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Test(req, -1)
+		assert.NoError(t, err)
+		body, err := ioutil.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+	status, _ := purge("guess")
+	assert.Equal(t, http.StatusUnauthorized, status)
+	status, body := purge(testAdminToken)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, `{"purged":2}`, body)
+	assert.Equal(t, "MISS", exec("en"))
+}